@@ -1,42 +1,192 @@
 package cron
 
 import (
+	"chat-system/internal/config"
 	"chat-system/internal/database"
+	"chat-system/internal/jobs"
+	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"context"
+	"errors"
+	"fmt"
 	"log"
+	"time"
+)
+
+const (
+	// countReconcileOverlap widens each reconciliation back before the last
+	// one, for rows stamped before it but committed after
+	countReconcileOverlap = time.Minute
+	maxLoggedDrifts       = 20
+)
 
-	"github.com/robfig/cron/v3"
+// Names of the jobs, as shown and triggered by the admin routes
+const (
+	ReconcileCountsJob   = "reconcile-counts"
+	PurgeDeletedJob      = "purge-deleted"
+	SweepTaskStatusesJob = "sweep-task-statuses"
+	ReindexJob           = "reindex"
 )
 
+// Reindexer queues reindexes of the search index.
+type Reindexer interface {
+	QueueReindex(ctx context.Context, scope models.IndexScope) (string, error)
+}
+
+// CronJob holds the background jobs of the API, which a jobs.Scheduler runs.
 type CronJob struct {
-	applicationDBHandler *database.ApplicationsDatabaseHandler
-	chatsDBHandler       *database.ChatsDatabaseHandler
+	applicationDBHandler  *database.ApplicationsDatabaseHandler
+	chatsDBHandler        *database.ChatsDatabaseHandler
+	messagesDBHandler     *database.MessagesDatabaseHandler
+	taskStatusesDBHandler *database.TaskStatusesDatabaseHandler
+	idempotencyDBHandler  *database.IdempotencyKeysDatabaseHandler
+	reindexer             Reindexer
+	// checkpoints keeps the database clock of the last count reconciliation,
+	// and reads it
+	checkpoints jobs.CheckpointStore
+	config      config.Jobs
 }
 
-func NewCronJob() *CronJob {
-	appDBHandler := database.NewApplicationsDatabaseHandler()
-	chatDBHandler := database.NewChatsDatabaseHandler()
-	return &CronJob{applicationDBHandler: appDBHandler, chatsDBHandler: chatDBHandler}
+func NewCronJob(searcher search.MessageSearcher, reindexer Reindexer, checkpoints jobs.CheckpointStore, cfg config.Config) *CronJob {
+	appDBHandler := database.NewApplicationsDatabaseHandler(searcher)
+	chatDBHandler := database.NewChatsDatabaseHandler(searcher)
+	messagesDBHandler := database.NewMessagesDatabaseHandler(searcher)
+	taskStatusesDBHandler := database.NewTaskStatusesDatabaseHandler(cfg.Tasks.StatusRetention)
+	idempotencyDBHandler := database.NewIdempotencyKeysDatabaseHandler(cfg.Tasks.IdempotencyKeyTTL)
+	return &CronJob{
+		applicationDBHandler:  appDBHandler,
+		chatsDBHandler:        chatDBHandler,
+		messagesDBHandler:     messagesDBHandler,
+		taskStatusesDBHandler: taskStatusesDBHandler,
+		idempotencyDBHandler:  idempotencyDBHandler,
+		reindexer:             reindexer,
+		checkpoints:           checkpoints,
+		config:                cfg.Jobs,
+	}
 }
 
-func (cj *CronJob) Start() {
-	c := cron.New()
+// Jobs lists the jobs with their schedule from the configuration. A schedule
+// of "off" disables a job, which can still be triggered.
+func (cj *CronJob) Jobs() []jobs.Job {
+	return []jobs.Job{
+		{
+			Name:     ReconcileCountsJob,
+			Schedule: cj.config.CountReconcileSchedule,
+			Run:      cj.reconcileCounts,
+		},
+		{
+			Name:     PurgeDeletedJob,
+			Schedule: cj.config.PurgeSchedule,
+			Run: func(ctx context.Context) error {
+				return cj.purgeDeleted(cj.config.SoftDeleteRetention)
+			},
+		},
+		{
+			Name:     SweepTaskStatusesJob,
+			Schedule: cj.config.TaskStatusSweepSchedule,
+			Run:      cj.sweepTaskStatuses,
+		},
+		{
+			Name:     ReindexJob,
+			Schedule: cj.config.ReindexSchedule,
+			Run:      cj.reindex,
+		},
+	}
+}
 
-	_, err := c.AddFunc("@every 50m", func() {
-		log.Println("Cron job started: Updating stats...")
-		err := cj.applicationDBHandler.UpdateChatsCount()
-		if err != nil {
-			log.Printf("Error updating chats_count: %v\n", err)
-		}
-		err = cj.chatsDBHandler.UpdateMessagesCount()
-		if err != nil {
-			log.Printf("Error updating messages_count: %v\n", err)
-		}
-		log.Println("Cron job completed.")
-	})
+// sweepTaskStatuses deletes the task statuses and idempotency keys past their
+// retention.
+func (cj *CronJob) sweepTaskStatuses(ctx context.Context) error {
+	deleted, err := cj.taskStatusesDBHandler.DeleteExpiredTaskStatuses()
 	if err != nil {
-		log.Fatalf("Failed to schedule cron job: %v\n", err)
+		return err
 	}
+	log.Printf("Swept %d expired task statuses.\n", deleted)
 
-	c.Start()
-	log.Println("Cron scheduler started.")
+	deleted, err = cj.idempotencyDBHandler.DeleteExpiredIdempotencyKeys()
+	if err != nil {
+		return err
+	}
+	log.Printf("Swept %d expired idempotency keys.\n", deleted)
+	return nil
+}
+
+// purgeDeleted permanently removes applications, chats and messages that were
+// soft deleted more than retention ago. A failure of one kind of row does not
+// keep the others from being purged.
+func (cj *CronJob) purgeDeleted(retention time.Duration) error {
+	var errs []error
+	purged, err := cj.applicationDBHandler.PurgeDeletedApplications(retention)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("purging applications: %w", err))
+	}
+	log.Printf("Purged %d applications.\n", purged)
+
+	purged, err = cj.chatsDBHandler.PurgeDeletedChats(retention)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("purging chats: %w", err))
+	}
+	log.Printf("Purged %d chats.\n", purged)
+
+	purged, err = cj.messagesDBHandler.PurgeDeletedMessages(retention)
+	if err != nil {
+		errs = append(errs, fmt.Errorf("purging messages: %w", err))
+	}
+	log.Printf("Purged %d messages.\n", purged)
+	return errors.Join(errs...)
+}
+
+// reconcileCounts checks chats_count and messages_count, which writes keep up
+// to date in their transactions, against the rows they count. Only the rows
+// changed since the last successful run, on any instance, are recounted; the
+// first run ever recounts everything. Counts found to drift are fixed and
+// logged.
+func (cj *CronJob) reconcileCounts(ctx context.Context) error {
+	checkpoint, err := cj.checkpoints.Now(ctx)
+	if err != nil {
+		return err
+	}
+	reconciledAt, err := cj.checkpoints.GetJobCheckpoint(ctx, ReconcileCountsJob)
+	if err != nil {
+		return err
+	}
+	since := time.Time{}
+	if !reconciledAt.IsZero() {
+		since = reconciledAt.Add(-countReconcileOverlap)
+	}
+
+	chatsDrifts, err := cj.applicationDBHandler.ReconcileChatsCount(since)
+	if err != nil {
+		return err
+	}
+	logDrifts("chats_count", "application", chatsDrifts)
+
+	messagesDrifts, err := cj.chatsDBHandler.ReconcileMessagesCount(since)
+	if err != nil {
+		return err
+	}
+	logDrifts("messages_count", "chat", messagesDrifts)
+
+	return cj.checkpoints.SetJobCheckpoint(ctx, ReconcileCountsJob, checkpoint)
+}
+
+// logDrifts reports the counts a reconciliation had to fix, the first
+// maxLoggedDrifts one by one. They should stay at zero; anything else points
+// at a write path that misses its count.
+func logDrifts(column string, owner string, drifts []database.CountDrift) {
+	for _, drift := range drifts[:min(len(drifts), maxLoggedDrifts)] {
+		log.Printf("Fixed %s of %s %d: stored %d, counted %d.\n", column, owner, drift.Id, drift.Stored, drift.Actual)
+	}
+	log.Printf("Reconciled %s, %d drifted.\n", column, len(drifts))
+}
+
+// reindex queues a full reindex, which rebuilds the search index behind its
+// alias.
+func (cj *CronJob) reindex(ctx context.Context) error {
+	taskID, err := cj.reindexer.QueueReindex(ctx, models.IndexScope{})
+	if err != nil {
+		return err
+	}
+	log.Printf("Queued reindex in task %s.\n", taskID)
+	return nil
 }
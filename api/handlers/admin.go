@@ -0,0 +1,173 @@
+package handlers
+
+import (
+	"chat-system/internal/jobs"
+	"chat-system/internal/queue"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultDeadLetterLimit = 100
+	maxDeadLetterLimit     = 1000
+)
+
+type AdminHandlers struct {
+	Queue        *queue.BoundedQueue
+	DeadLetters  queue.DeadLetterStore
+	TaskStatuses TaskStatusStore
+	Jobs         *jobs.Scheduler
+}
+
+func CreateAdminHandlers(taskQueue *queue.BoundedQueue, deadLetters queue.DeadLetterStore, taskStatuses TaskStatusStore, scheduler *jobs.Scheduler) *AdminHandlers {
+	return &AdminHandlers{
+		Queue:        taskQueue,
+		DeadLetters:  deadLetters,
+		TaskStatuses: taskStatuses,
+		Jobs:         scheduler,
+	}
+}
+
+func (h *AdminHandlers) HandleGetQueueDepths(c echo.Context) error {
+	queueDepths := []queueDepthResponse{}
+	for _, queueName := range []string{chatsQueue, messagesQueue, reindexQueue} {
+		depth, err := h.Queue.Depth(c.Request().Context(), queueName)
+		if err != nil {
+			return fmt.Errorf("failed to get depth of %s queue: %w", queueName, err)
+		}
+		queueDepths = append(queueDepths, queueDepthResponse{
+			Queue:    queueName,
+			Depth:    depth,
+			Capacity: h.Queue.Capacity(),
+		})
+	}
+	response := &response[[]queueDepthResponse]{Data: queueDepths}
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *AdminHandlers) HandleListDeadLetters(c echo.Context) error {
+	limit := defaultDeadLetterLimit
+	if value := c.QueryParam("limit"); value != "" {
+		parsed, err := strconv.Atoi(value)
+		if err != nil || parsed < 1 || parsed > maxDeadLetterLimit {
+			return echo.NewHTTPError(http.StatusBadRequest, "invalid limit")
+		}
+		limit = parsed
+	}
+
+	deadLetters, err := h.DeadLetters.ListDeadLetters(c.Request().Context(), c.QueryParam("queue"), limit)
+	if err != nil {
+		return fmt.Errorf("failed to list dead letters: %w", err)
+	}
+
+	deadLetterResponses := []deadLetterResponse{}
+	for _, deadLetter := range deadLetters {
+		deadLetterResponses = append(deadLetterResponses, toDeadLetterResponse(deadLetter))
+	}
+	response := &response[[]deadLetterResponse]{Data: deadLetterResponses}
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *AdminHandlers) HandleGetDeadLetter(c echo.Context) error {
+	deadLetter, err := h.DeadLetters.GetDeadLetter(c.Request().Context(), c.Param("taskID"))
+	if err != nil {
+		return fmt.Errorf("failed to get dead letter: %w", err)
+	}
+
+	response := &response[deadLetterResponse]{Data: toDeadLetterResponse(*deadLetter)}
+	return c.JSON(http.StatusOK, response)
+}
+
+// HandleReplayDeadLetter puts a dead letter back in its queue. The status of
+// a task that has one goes back to pending along with the replay, so that the
+// original status URL follows it.
+func (h *AdminHandlers) HandleReplayDeadLetter(c echo.Context) error {
+	taskID := c.Param("taskID")
+	ctx := c.Request().Context()
+
+	deadLetter, err := h.DeadLetters.GetDeadLetter(ctx, taskID)
+	if err != nil {
+		return fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	if err := h.DeadLetters.ReplayDeadLetter(ctx, taskID, hasTaskStatus(deadLetter.Task)); err != nil {
+		return fmt.Errorf("failed to replay dead letter: %w", err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+// hasTaskStatus tells whether task reports on a status URL. Index tasks are
+// internal and have none.
+func hasTaskStatus(task queue.Task) bool {
+	return !(task.Queue == messagesQueue && task.Kind == messageIndexTask)
+}
+
+func (h *AdminHandlers) HandleDiscardDeadLetter(c echo.Context) error {
+	err := h.DeadLetters.DiscardDeadLetter(c.Request().Context(), c.Param("taskID"))
+	if err != nil {
+		return fmt.Errorf("failed to discard dead letter: %w", err)
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+// HandleGetJobs lists the background jobs with their schedule and last run,
+// whichever instance made it.
+func (h *AdminHandlers) HandleGetJobs(c echo.Context) error {
+	statuses, err := h.Jobs.Jobs(c.Request().Context())
+	if err != nil {
+		return fmt.Errorf("failed to get jobs: %w", err)
+	}
+
+	jobResponses := []jobResponse{}
+	for _, status := range statuses {
+		jobResponses = append(jobResponses, toJobResponse(status))
+	}
+	response := &response[[]jobResponse]{Data: jobResponses}
+	return c.JSON(http.StatusOK, response)
+}
+
+// HandleRunJob starts a run of a job right away, even a disabled one. A job
+// already running, here or on another instance, is not started twice.
+func (h *AdminHandlers) HandleRunJob(c echo.Context) error {
+	if err := h.Jobs.Trigger(c.Param("name")); err != nil {
+		return fmt.Errorf("failed to trigger job: %w", err)
+	}
+
+	return c.NoContent(http.StatusAccepted)
+}
+
+func toJobResponse(status jobs.Status) jobResponse {
+	job := jobResponse{
+		Name:      status.Name,
+		Schedule:  status.Schedule,
+		Enabled:   status.Enabled,
+		Running:   status.Running,
+		NextRunAt: status.NextRun,
+	}
+	if status.LastRun != nil {
+		job.LastRun = &jobRunResponse{
+			StartedAt:  status.LastRun.StartedAt,
+			DurationMs: status.LastRun.Duration.Milliseconds(),
+			Error:      status.LastRun.Error,
+		}
+	}
+	return job
+}
+
+func toDeadLetterResponse(deadLetter queue.DeadLetter) deadLetterResponse {
+	return deadLetterResponse{
+		TaskID:       deadLetter.ID,
+		Queue:        deadLetter.Queue,
+		Kind:         deadLetter.Kind,
+		PartitionKey: deadLetter.PartitionKey,
+		Payload:      deadLetter.Payload,
+		Attempts:     deadLetter.Attempts,
+		Error:        deadLetter.Error,
+		CreatedAt:    deadLetter.CreatedAt,
+		FailedAt:     deadLetter.FailedAt,
+	}
+}
@@ -0,0 +1,220 @@
+package handlers
+
+import (
+	"chat-system/internal/config"
+	"chat-system/internal/jobs"
+	"chat-system/internal/models"
+	"chat-system/internal/queue"
+	"context"
+	"database/sql/driver"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+// flakyChats fails chat creation with a transient error a number of times.
+type flakyChats struct {
+	ChatRepository
+	failures atomic.Int64
+}
+
+func (f *flakyChats) InsertChat(appId int64, subject string) (int64, error) {
+	if f.failures.Add(-1) >= 0 {
+		return 0, driver.ErrBadConn
+	}
+	return f.ChatRepository.InsertChat(appId, subject)
+}
+
+func newFlakyServer(t *testing.T, maxAttempts int, failures int64) (*testServer, *flakyChats) {
+	cfg := fastRetries()
+	cfg.Tasks.MaxAttempts = maxAttempts
+	flaky := &flakyChats{}
+	flaky.failures.Store(failures)
+	s := newTestServerWithChats(t, cfg, func(chats ChatRepository) ChatRepository {
+		flaky.ChatRepository = chats
+		return flaky
+	})
+	return s, flaky
+}
+
+func TestAdminRoutesRequireKey(t *testing.T) {
+	s := newTestServer(t)
+
+	expectError(t, s.do(http.MethodGet, "/admin/queues", nil, echo.HeaderAuthorization, ""), http.StatusUnauthorized, "unauthorized")
+	expectError(t, s.do(http.MethodPost, "/admin/jobs/unknown/run", nil, echo.HeaderAuthorization, "Bearer wrong-key"), http.StatusUnauthorized, "unauthorized")
+	expectStatus(t, s.do(http.MethodGet, "/admin/queues", nil), http.StatusOK)
+}
+
+func TestAdminRoutesDisabledWithoutKey(t *testing.T) {
+	// No route is reached, so the handlers need no dependencies
+	e := NewServer(&ApplicationHandlers{}, &ChatHandlers{}, &MessageHandlers{}, &ReindexHandlers{}, &AdminHandlers{}, "")
+
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/admin/queues", nil))
+	expectError(t, rec, http.StatusNotFound, "not_found")
+}
+
+// brokenDeadLetters fails every read, as a dead letter store whose database
+// is down.
+type brokenDeadLetters struct {
+	queue.DeadLetterStore
+}
+
+func (brokenDeadLetters) ListDeadLetters(ctx context.Context, queueName string, limit int) ([]queue.DeadLetter, error) {
+	return nil, driver.ErrBadConn
+}
+
+func TestAdminErrorsCarryRequestID(t *testing.T) {
+	e := NewServer(&ApplicationHandlers{}, &ChatHandlers{}, &MessageHandlers{}, &ReindexHandlers{}, &AdminHandlers{DeadLetters: brokenDeadLetters{}}, testAdminKey)
+
+	req := httptest.NewRequest(http.MethodGet, "/admin/dead-letters", nil)
+	req.Header.Set(echo.HeaderAuthorization, "Bearer "+testAdminKey)
+	rec := httptest.NewRecorder()
+	e.ServeHTTP(rec, req)
+	expectError(t, rec, http.StatusInternalServerError, "internal_server_error")
+}
+
+func TestGetQueueDepths(t *testing.T) {
+	s := newTestServer(t)
+
+	rec := s.do(http.MethodGet, "/admin/queues", nil)
+	expectStatus(t, rec, http.StatusOK)
+	depths := decode[response[[]queueDepthResponse]](t, rec).Data
+	if len(depths) != 3 || depths[0].Queue != chatsQueue || depths[1].Queue != messagesQueue || depths[2].Queue != reindexQueue || depths[0].Capacity != int64(config.Default().Queue.Capacity) {
+		t.Fatalf("unexpected queue depths %+v", depths)
+	}
+}
+
+func TestTransientFailureIsRetried(t *testing.T) {
+	s, _ := newFlakyServer(t, 3, 2)
+	token := s.createApplication("app")
+
+	if number := s.createChat(token, "chat"); number != 1 {
+		t.Fatalf("expected chat number 1, got %d", number)
+	}
+}
+
+func TestDeadLetters(t *testing.T) {
+	s, flaky := newFlakyServer(t, 1, 1)
+	token := s.createApplication("app")
+
+	statusURL := statusPath(t, s.do(http.MethodPost, "/applications/"+token+"/chats", map[string]string{"subject": "chat"}))
+	if status := waitForTask[ChatTaskStatus](s, statusURL); status.Status != models.TaskFailed {
+		t.Fatalf("expected the creation to fail, got %+v", status)
+	}
+	taskID := strings.TrimPrefix(statusURL, "/chats/status/")
+
+	rec := s.do(http.MethodGet, "/admin/dead-letters?queue="+chatsQueue, nil)
+	expectStatus(t, rec, http.StatusOK)
+	if deadLetters := decode[response[[]deadLetterResponse]](t, rec).Data; len(deadLetters) != 1 || deadLetters[0].TaskID != taskID {
+		t.Fatalf("unexpected dead letters %+v", deadLetters)
+	}
+	rec = s.do(http.MethodGet, "/admin/dead-letters?queue="+messagesQueue, nil)
+	expectStatus(t, rec, http.StatusOK)
+	if deadLetters := decode[response[[]deadLetterResponse]](t, rec).Data; len(deadLetters) != 0 {
+		t.Fatalf("expected no message dead letters, got %+v", deadLetters)
+	}
+	expectError(t, s.do(http.MethodGet, "/admin/dead-letters?limit=0", nil), http.StatusBadRequest, "bad_request")
+
+	rec = s.do(http.MethodGet, "/admin/dead-letters/"+taskID, nil)
+	expectStatus(t, rec, http.StatusOK)
+	if deadLetter := decode[response[deadLetterResponse]](t, rec).Data; deadLetter.Kind != chatCreateTask || deadLetter.Attempts != 1 || deadLetter.Error == "" {
+		t.Fatalf("unexpected dead letter %+v", deadLetter)
+	}
+	expectError(t, s.do(http.MethodGet, "/admin/dead-letters/unknown", nil), http.StatusNotFound, "not_found")
+
+	// The database is back: replaying runs the creation again under the
+	// original status URL
+	if flaky.failures.Load() > 0 {
+		t.Fatal("expected every failure to be used up")
+	}
+	expectStatus(t, s.do(http.MethodPost, "/admin/dead-letters/"+taskID+"/replay", nil), http.StatusAccepted)
+	if status := waitForTask[ChatTaskStatus](s, statusURL); status.Status != models.TaskCompleted || status.Number != 1 {
+		t.Fatalf("expected the replay to complete, got %+v", status)
+	}
+	expectError(t, s.do(http.MethodPost, "/admin/dead-letters/"+taskID+"/replay", nil), http.StatusNotFound, "not_found")
+}
+
+func TestDiscardDeadLetter(t *testing.T) {
+	s, _ := newFlakyServer(t, 1, 1)
+	token := s.createApplication("app")
+
+	statusURL := statusPath(t, s.do(http.MethodPost, "/applications/"+token+"/chats", map[string]string{"subject": "chat"}))
+	waitForTask[ChatTaskStatus](s, statusURL)
+	taskID := strings.TrimPrefix(statusURL, "/chats/status/")
+
+	expectStatus(t, s.do(http.MethodDelete, "/admin/dead-letters/"+taskID, nil), http.StatusNoContent)
+	expectError(t, s.do(http.MethodGet, "/admin/dead-letters/"+taskID, nil), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodDelete, "/admin/dead-letters/"+taskID, nil), http.StatusNotFound, "not_found")
+}
+
+func TestJobs(t *testing.T) {
+	s := newTestServer(t)
+	release := make(chan struct{})
+	runs := atomic.Int64{}
+	err := s.jobs.Register(jobs.Job{Name: "sweep", Schedule: "@every 1h", Run: func(ctx context.Context) error {
+		runs.Add(1)
+		<-release
+		return nil
+	}})
+	if err != nil {
+		t.Fatalf("registering job: %v", err)
+	}
+	err = s.jobs.Register(jobs.Job{Name: "broken", Schedule: jobs.Disabled, Run: func(ctx context.Context) error {
+		return errors.New("broken on purpose")
+	}})
+	if err != nil {
+		t.Fatalf("registering job: %v", err)
+	}
+	if err := s.jobs.Register(jobs.Job{Name: "sweep", Schedule: "@every 1h"}); err == nil {
+		t.Fatal("expected registering a job twice to fail")
+	}
+	if err := s.jobs.Register(jobs.Job{Name: "invalid", Schedule: "whenever"}); err == nil {
+		t.Fatal("expected an invalid schedule to fail")
+	}
+
+	expectStatus(t, s.do(http.MethodPost, "/admin/jobs/sweep/run", nil), http.StatusAccepted)
+	// The run holds the lock of the job until it is released
+	expectError(t, s.do(http.MethodPost, "/admin/jobs/sweep/run", nil), http.StatusConflict, "conflict")
+	expectError(t, s.do(http.MethodPost, "/admin/jobs/unknown/run", nil), http.StatusNotFound, "not_found")
+	if job := s.job("sweep"); !job.Running || !job.Enabled || job.LastRun != nil {
+		t.Fatalf("expected sweep to be running for the first time, got %+v", job)
+	}
+	close(release)
+
+	// Disabled jobs can still be triggered
+	expectStatus(t, s.do(http.MethodPost, "/admin/jobs/broken/run", nil), http.StatusAccepted)
+	deadline := time.Now().Add(taskTimeout)
+	for s.job("sweep").Running || s.job("sweep").LastRun == nil || s.job("broken").LastRun == nil {
+		if time.Now().After(deadline) {
+			t.Fatal("jobs did not record their runs")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if job := s.job("sweep"); job.Running || job.LastRun.Error != "" || runs.Load() != 1 {
+		t.Fatalf("expected sweep to have run once, got %+v", job)
+	}
+	if job := s.job("broken"); job.Enabled || job.Schedule != jobs.Disabled || job.LastRun.Error != "broken on purpose" {
+		t.Fatalf("expected the error of broken, got %+v", job)
+	}
+}
+
+// job returns the status of the job called name, as listed by the admin route.
+func (s *testServer) job(name string) jobResponse {
+	s.t.Helper()
+	rec := s.do(http.MethodGet, "/admin/jobs", nil)
+	expectStatus(s.t, rec, http.StatusOK)
+	for _, job := range decode[response[[]jobResponse]](s.t, rec).Data {
+		if job.Name == name {
+			return job
+		}
+	}
+	s.t.Fatalf("job %s not listed", name)
+	return jobResponse{}
+}
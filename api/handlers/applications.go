@@ -1,9 +1,7 @@
 package handlers
 
 import (
-	"chat-system/internal/database"
 	"chat-system/internal/models"
-	"log"
 	"net/http"
 
 	"github.com/google/uuid"
@@ -11,31 +9,27 @@ import (
 )
 
 type ApplicationHandlers struct {
-	DBHandler *database.ApplicationsDatabaseHandler
+	DBHandler ApplicationRepository
 }
 
-func CreateApplicationHandlers() *ApplicationHandlers {
-	dbHandler := database.NewApplicationsDatabaseHandler()
-	return &ApplicationHandlers{DBHandler: dbHandler}
+func CreateApplicationHandlers(applications ApplicationRepository) *ApplicationHandlers {
+	return &ApplicationHandlers{DBHandler: applications}
 }
 
 func (h *ApplicationHandlers) HandleCreateApplication(c echo.Context) error {
 	request := new(createApplicationRequest)
 	if err := c.Bind(request); err != nil {
-		log.Printf("error binding request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 	if err := c.Validate(request); err != nil {
-		log.Printf("error validating request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 
 	token := uuid.New().String()
 
 	err := h.DBHandler.InsertApplication(request.Name, token)
 	if err != nil {
-		log.Printf("error inserting application: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	response := &response[createApplicationResponse]{Data: createApplicationResponse{Token: token}}
@@ -47,8 +41,7 @@ func (h *ApplicationHandlers) HandleGetApplicationByToken(c echo.Context) error
 	token := c.Param("token")
 	app, err := h.DBHandler.GetApplicationByToken(token)
 	if err != nil {
-		log.Printf("error getting application: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 	userApp := models.UserExposedApplication{
 		Name:       app.Name,
@@ -59,10 +52,14 @@ func (h *ApplicationHandlers) HandleGetApplicationByToken(c echo.Context) error
 	return c.JSON(http.StatusOK, response)
 }
 func (h *ApplicationHandlers) HandleGetAllApplications(c echo.Context) error {
-	allApps, err := h.DBHandler.GetAllApplications()
+	page, err := parsePageQuery(c)
 	if err != nil {
-		log.Printf("error getting applications: %v", err)
-		return echo.ErrInternalServerError
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	allApps, hasMore, err := h.DBHandler.GetAllApplications(page)
+	if err != nil {
+		return err
 	}
 	var userExposedApps []models.UserExposedApplication
 	for _, app := range allApps {
@@ -73,6 +70,9 @@ func (h *ApplicationHandlers) HandleGetAllApplications(c echo.Context) error {
 		})
 	}
 	response := &response[[]models.UserExposedApplication]{Data: userExposedApps}
+	if len(allApps) > 0 {
+		response.NextCursor, response.PrevCursor = pageCursors(page, allApps[0].Id, allApps[len(allApps)-1].Id, hasMore)
+	}
 
 	return c.JSON(http.StatusOK, response)
 }
@@ -81,17 +81,14 @@ func (h *ApplicationHandlers) HandleUpdateApplicationName(c echo.Context) error
 	token := c.Param("token")
 	request := new(updateApplicationNameRequest)
 	if err := c.Bind(request); err != nil {
-		log.Printf("error binding request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 	if err := c.Validate(request); err != nil {
-		log.Printf("error validating request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 	newApp, err := h.DBHandler.UpdateApplicationName(token, request.NewName)
 	if err != nil {
-		log.Printf("error updating application: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	response := &response[models.UserExposedApplication]{Data: models.UserExposedApplication{
@@ -101,3 +98,28 @@ func (h *ApplicationHandlers) HandleUpdateApplicationName(c echo.Context) error
 	}}
 	return c.JSON(http.StatusOK, response)
 }
+
+func (h *ApplicationHandlers) HandleDeleteApplication(c echo.Context) error {
+	token := c.Param("token")
+	err := h.DBHandler.DeleteApplication(token)
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *ApplicationHandlers) HandleRestoreApplication(c echo.Context) error {
+	token := c.Param("token")
+	restoredApp, err := h.DBHandler.RestoreApplication(token)
+	if err != nil {
+		return err
+	}
+
+	response := &response[models.UserExposedApplication]{Data: models.UserExposedApplication{
+		Name:       restoredApp.Name,
+		Token:      restoredApp.Token,
+		ChatsCount: restoredApp.ChatsCount,
+	}}
+	return c.JSON(http.StatusOK, response)
+}
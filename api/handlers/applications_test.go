@@ -0,0 +1,110 @@
+package handlers
+
+import (
+	"chat-system/internal/models"
+	"net/http"
+	"testing"
+)
+
+func TestCreateApplication(t *testing.T) {
+	s := newTestServer(t)
+
+	token := s.createApplication("app")
+	if token == "" {
+		t.Fatal("expected a token")
+	}
+
+	rec := s.do(http.MethodGet, "/applications/"+token, nil)
+	expectStatus(t, rec, http.StatusOK)
+	app := decode[response[models.UserExposedApplication]](t, rec).Data
+	if app.Name != "app" || app.Token != token {
+		t.Fatalf("unexpected application %+v", app)
+	}
+}
+
+func TestCreateApplicationValidation(t *testing.T) {
+	s := newTestServer(t)
+
+	body := expectError(t, s.do(http.MethodPost, "/applications", map[string]string{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	if len(body.Details) != 1 || body.Details[0].Field != "name" {
+		t.Fatalf("expected a detail for name, got %+v", body.Details)
+	}
+
+	expectError(t, s.do(http.MethodPost, "/applications", "not an object"), http.StatusBadRequest, "bad_request")
+}
+
+func TestGetUnknownApplication(t *testing.T) {
+	s := newTestServer(t)
+
+	body := expectError(t, s.do(http.MethodGet, "/applications/unknown", nil), http.StatusNotFound, "not_found")
+	if body.Message != "application not found" {
+		t.Fatalf("unexpected message %q", body.Message)
+	}
+}
+
+func TestGetAllApplicationsPaginates(t *testing.T) {
+	s := newTestServer(t)
+	for _, name := range []string{"a", "b", "c"} {
+		s.createApplication(name)
+	}
+
+	rec := s.do(http.MethodGet, "/applications?limit=2", nil)
+	expectStatus(t, rec, http.StatusOK)
+	first := decode[response[[]models.UserExposedApplication]](t, rec)
+	if len(first.Data) != 2 || first.Data[0].Name != "a" || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+
+	rec = s.do(http.MethodGet, "/applications?limit=2&after="+first.NextCursor, nil)
+	expectStatus(t, rec, http.StatusOK)
+	second := decode[response[[]models.UserExposedApplication]](t, rec)
+	if len(second.Data) != 1 || second.Data[0].Name != "c" || second.NextCursor != "" || second.PrevCursor == "" {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+
+	rec = s.do(http.MethodGet, "/applications?limit=2&before="+second.PrevCursor, nil)
+	expectStatus(t, rec, http.StatusOK)
+	back := decode[response[[]models.UserExposedApplication]](t, rec)
+	if len(back.Data) != 2 || back.Data[0].Name != "a" || back.Data[1].Name != "b" {
+		t.Fatalf("unexpected previous page %+v", back)
+	}
+
+	rec = s.do(http.MethodGet, "/applications?order=desc&limit=1", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if newest := decode[response[[]models.UserExposedApplication]](t, rec); newest.Data[0].Name != "c" {
+		t.Fatalf("expected newest application first, got %+v", newest.Data)
+	}
+
+	expectError(t, s.do(http.MethodGet, "/applications?limit=0", nil), http.StatusBadRequest, "bad_request")
+}
+
+func TestUpdateApplicationName(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("old")
+
+	rec := s.do(http.MethodPatch, "/applications/"+token, map[string]string{"newName": "new"})
+	expectStatus(t, rec, http.StatusOK)
+	if app := decode[response[models.UserExposedApplication]](t, rec).Data; app.Name != "new" {
+		t.Fatalf("expected the new name, got %+v", app)
+	}
+
+	expectError(t, s.do(http.MethodPatch, "/applications/"+token, map[string]string{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	expectError(t, s.do(http.MethodPatch, "/applications/unknown", map[string]string{"newName": "new"}), http.StatusNotFound, "not_found")
+}
+
+func TestDeleteAndRestoreApplication(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+
+	expectStatus(t, s.do(http.MethodDelete, "/applications/"+token, nil), http.StatusNoContent)
+	expectError(t, s.do(http.MethodGet, "/applications/"+token, nil), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodDelete, "/applications/"+token, nil), http.StatusNotFound, "not_found")
+
+	rec := s.do(http.MethodPost, "/applications/"+token+"/restore", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if app := decode[response[models.UserExposedApplication]](t, rec).Data; app.Token != token {
+		t.Fatalf("unexpected restored application %+v", app)
+	}
+	expectStatus(t, s.do(http.MethodGet, "/applications/"+token, nil), http.StatusOK)
+	expectError(t, s.do(http.MethodPost, "/applications/"+token+"/restore", nil), http.StatusNotFound, "not_found")
+}
@@ -1,25 +1,42 @@
 package handlers
 
 import (
+	"chat-system/internal/config"
 	"chat-system/internal/database"
 	"chat-system/internal/models"
-	"log"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
 type ChatHandlers struct {
-	ChatsDBHandler        *database.ChatsDatabaseHandler
-	ApplicationsDBHandler *database.ApplicationsDatabaseHandler
-	WriteQueue            chan ChatWriteRequest
-	UpdateQueue           chan ChatUpdateRequest
-	TaskStatusMap         map[string]*ChatTaskStatus
+	ChatsDBHandler        ChatRepository
+	ApplicationsDBHandler ApplicationRepository
+	Queue                 queue.Queue
+	TaskStatuses          TaskStatusStore
+	IdempotencyKeys       IdempotencyStore
+	Searcher              search.MessageSearcher
+	workers               *queue.Pool
+	config                config.Config
 }
 
+const (
+	chatsQueue     = "chats"
+	chatCreateTask = "create"
+	chatUpdateTask = "update"
+)
+
 type ChatTaskStatus struct {
-	Status string // "Pending", "Completed", "Error"
+	Status string // "pending", "running", "completed", "failed"
 	models.UserExposedChat
 	Error string
 }
@@ -36,52 +53,84 @@ type ChatUpdateRequest struct {
 	NewSubject    string
 }
 
-func CreateChatHandlers() *ChatHandlers {
-	chatsDbHandler := database.NewChatsDatabaseHandler()
-	applicationsDbHandler := database.NewApplicationsDatabaseHandler()
-
+func CreateChatHandlers(chats ChatRepository, applications ApplicationRepository, taskQueue queue.Queue, taskStatuses TaskStatusStore, idempotencyKeys IdempotencyStore, searcher search.MessageSearcher, cfg config.Config) *ChatHandlers {
 	handler := &ChatHandlers{
-		ChatsDBHandler:        chatsDbHandler,
-		ApplicationsDBHandler: applicationsDbHandler,
-		WriteQueue:            make(chan ChatWriteRequest, 1000), // Adjust buffer size as needed
-		UpdateQueue:           make(chan ChatUpdateRequest, 1000),
-		TaskStatusMap:         make(map[string]*ChatTaskStatus),
+		ChatsDBHandler:        chats,
+		ApplicationsDBHandler: applications,
+		Queue:                 taskQueue,
+		TaskStatuses:          taskStatuses,
+		IdempotencyKeys:       idempotencyKeys,
+		Searcher:              searcher,
+		config:                cfg,
 	}
 
-	// Start the background worker
-	go handler.startWorker()
+	// Start the background workers; tasks of one application run in order
+	handler.startWorkers()
 
 	return handler
 }
 
-func (h *ChatHandlers) startWorker() {
-	for {
-		select {
-		case createReq := <-h.WriteQueue:
-			status := h.TaskStatusMap[createReq.TaskID]
-			chatNum, err := h.ChatsDBHandler.InsertChat(createReq.ApplicationID, createReq.Subject)
-			if err != nil {
-				log.Printf("Error inserting chat: %v", err)
-				status.Status = "Error"
-				status.Error = "Failed to create chat"
-			} else {
-				status.Status = "Completed"
-				status.Number = chatNum
-				status.Subject = createReq.Subject
-			}
-		case updateReq := <-h.UpdateQueue:
-			status := h.TaskStatusMap[updateReq.TaskID]
-			updatedChat, err := h.ChatsDBHandler.UpdateChatSubject(updateReq.ApplicationID, updateReq.ChatNumber, updateReq.NewSubject)
-			if err != nil {
-				log.Printf("Error updating chat: %v", err)
-				status.Status = "Error"
-				status.Error = "Failed to update chat subject"
-			} else {
-				status.Status = "Completed"
-				status.Number = updatedChat.Number
-				status.Subject = updatedChat.Subject
-			}
+func (h *ChatHandlers) startWorkers() {
+	h.workers = queue.NewPool(h.Queue, chatsQueue, h.config.Tasks.ChatWorkers, taskRetryPolicy(h.config.Tasks), h.processTask, h.taskFailed)
+	h.workers.Start()
+}
+
+// StopWorkers waits for the writes being processed to finish. Writes still
+// queued are kept in the durable queue for the next start.
+func (h *ChatHandlers) StopWorkers(ctx context.Context) error {
+	err := h.workers.Stop(ctx)
+	logRemainingTasks(h.Queue, chatsQueue)
+	return err
+}
+
+func (h *ChatHandlers) processTask(task *queue.Task) error {
+	markTaskRunning(h.TaskStatuses, task.ID)
+
+	switch task.Kind {
+	case chatCreateTask:
+		var createReq ChatWriteRequest
+		if err := json.Unmarshal(task.Payload, &createReq); err != nil {
+			return fmt.Errorf("failed to decode chat create task: %w", err)
+		}
+		chatNum, err := h.ChatsDBHandler.InsertChat(createReq.ApplicationID, createReq.Subject)
+		if err != nil {
+			return err
+		}
+		completeTask(h.TaskStatuses, task.ID, models.UserExposedChat{
+			Number:  chatNum,
+			Subject: createReq.Subject,
+		})
+	case chatUpdateTask:
+		var updateReq ChatUpdateRequest
+		if err := json.Unmarshal(task.Payload, &updateReq); err != nil {
+			return fmt.Errorf("failed to decode chat update task: %w", err)
+		}
+		updatedChat, err := h.ChatsDBHandler.UpdateChatSubject(updateReq.ApplicationID, updateReq.ChatNumber, updateReq.NewSubject)
+		if err != nil {
+			return err
 		}
+		completeTask(h.TaskStatuses, task.ID, models.UserExposedChat{
+			Number:  updatedChat.Number,
+			Subject: updatedChat.Subject,
+		})
+	default:
+		return fmt.Errorf("unknown chat task kind %q", task.Kind)
+	}
+	return nil
+}
+
+func (h *ChatHandlers) taskFailed(task *queue.Task, err error, retrying bool) {
+	if retrying {
+		retryTask(h.TaskStatuses, task.ID, task.Attempts)
+		return
+	}
+	switch task.Kind {
+	case chatCreateTask:
+		failTaskWith(h.TaskStatuses, task.ID, "Failed to create chat", err)
+	case chatUpdateTask:
+		failTaskWith(h.TaskStatuses, task.ID, "Failed to update chat subject", err)
+	default:
+		failTask(h.TaskStatuses, task.ID, "Unknown task")
 	}
 }
 
@@ -89,52 +138,104 @@ func (h *ChatHandlers) HandleCreateChat(c echo.Context) error {
 	token := c.Param("token")
 	request := new(createChatRequest)
 	if err := c.Bind(request); err != nil {
-		log.Printf("error binding request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 	if err := c.Validate(request); err != nil {
-		log.Printf("error validating request: %v", err)
-		return echo.ErrBadRequest
+		return err
+	}
+	wait, err := parseWait(c, h.config.Server.SyncWaitTimeout)
+	if err != nil {
+		return err
 	}
 
 	applicationId, err := h.ApplicationsDBHandler.GetApplicationIdByToken(token)
 	if err != nil {
-		log.Printf("error getting application id: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	taskID := uuid.New().String()
 
-	h.TaskStatusMap[taskID] = &ChatTaskStatus{
-		Status: "Pending",
+	idempotent, replayedTaskID, err := checkIdempotency(c, h.IdempotencyKeys, request, taskID)
+	if err != nil {
+		return err
+	}
+	if replayedTaskID != "" {
+		markReplayed(c)
+		return h.respondToCreate(c, token, replayedTaskID, wait)
+	}
+
+	if err := h.TaskStatuses.InsertTaskStatus(taskID); err != nil {
+		idempotent.release()
+		return err
 	}
 
 	// Push the request to the queue
-	h.WriteQueue <- ChatWriteRequest{
+	err = enqueueTask(c.Request().Context(), h.Queue, chatsQueue, chatCreateTask, taskID, strconv.FormatInt(applicationId, 10), ChatWriteRequest{
 		TaskID:        taskID,
 		ApplicationID: applicationId,
 		Subject:       request.Subject,
+	})
+	if errors.Is(err, queue.ErrFull) {
+		failTask(h.TaskStatuses, taskID, "Queue is full")
+		idempotent.release()
+		return queueFull(c, h.config.Queue.RetryAfter)
+	}
+	if err != nil {
+		failTask(h.TaskStatuses, taskID, "Failed to queue chat creation")
+		idempotent.release()
+		return err
 	}
 
-	// Respond with the updated status-check URL
-	statusURL := c.Scheme() + "://" + c.Request().Host + "/chats/status/" + taskID
-	return c.JSON(http.StatusAccepted, map[string]string{
-		"status_url": statusURL,
+	return h.respondToCreate(c, token, taskID, wait)
+}
+
+// respondToCreate answers a chat creation with the status URL of its task,
+// or, when the client asked to wait, with the created chat once the task
+// completes. A task still running after wait falls back to the status URL.
+// A task that failed with a domain error, such as a missing application,
+// answers with that error, as the request would have without the queue.
+func (h *ChatHandlers) respondToCreate(c echo.Context, token string, taskID string, wait time.Duration) error {
+	if wait == 0 {
+		return acceptTask(c, "/chats/status/", taskID)
+	}
+	status, finished, err := awaitTask(c.Request().Context(), h.TaskStatuses, taskID, wait)
+	if err != nil {
+		return err
+	}
+	if !finished {
+		return acceptTask(c, "/chats/status/", taskID)
+	}
+	if status.Status == models.TaskFailed {
+		if err := database.ErrorOfKind(status.ErrorKind, status.Error); err != nil {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, status.Error)
+	}
+
+	var chat models.UserExposedChat
+	if err := json.Unmarshal(status.Result, &chat); err != nil {
+		return err
+	}
+	return respondCreated(c, chatPath(token, chat.Number), &response[createChatResponse]{
+		Data: createChatResponse{ChatNumber: chat.Number, Subject: chat.Subject},
 	})
 }
 
 func (h *ChatHandlers) HandleGetAllChatsForApplication(c echo.Context) error {
 	token := c.Param("token")
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
 	applicationId, err := h.ApplicationsDBHandler.GetApplicationIdByToken(token)
 	if err != nil {
-		log.Printf("error getting app id: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
-	chats, err := h.ChatsDBHandler.GetAllChatsForAnApp(applicationId)
+	chats, hasMore, err := h.ChatsDBHandler.GetAllChatsForAnApp(applicationId, page)
 	if err != nil {
-		log.Printf("error getting chat: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 	var userExposedChats []models.UserExposedChat
 	for _, chat := range chats {
@@ -146,6 +247,9 @@ func (h *ChatHandlers) HandleGetAllChatsForApplication(c echo.Context) error {
 	}
 
 	response := &response[[]models.UserExposedChat]{Data: userExposedChats}
+	if len(chats) > 0 {
+		response.NextCursor, response.PrevCursor = pageCursors(page, chats[0].Number, chats[len(chats)-1].Number, hasMore)
+	}
 
 	return c.JSON(http.StatusOK, response)
 }
@@ -159,14 +263,12 @@ func (h *ChatHandlers) HandleGetChat(c echo.Context) error {
 
 	applicationId, err := h.ApplicationsDBHandler.GetApplicationIdByToken(token)
 	if err != nil {
-		log.Printf("error getting app id: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	chat, err := h.ChatsDBHandler.GetChatByApplicationIdAndChatNumber(applicationId, chatNumber)
 	if err != nil {
-		log.Printf("error getting chat: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	userExposedChat := models.UserExposedChat{
@@ -189,47 +291,137 @@ func (h *ChatHandlers) HandleQueueUpdateChat(c echo.Context) error {
 
 	request := new(updateChatRequest)
 	if err := c.Bind(request); err != nil {
-		log.Printf("Error binding request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 	if err := c.Validate(request); err != nil {
-		log.Printf("Error validating request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 
 	applicationID, err := h.ApplicationsDBHandler.GetApplicationIdByToken(token)
 	if err != nil {
-		log.Printf("Error getting application ID: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	taskID := uuid.New().String()
-	h.TaskStatusMap[taskID] = &ChatTaskStatus{
-		Status: "Pending",
+	if err := h.TaskStatuses.InsertTaskStatus(taskID); err != nil {
+		return err
 	}
 
-	h.UpdateQueue <- ChatUpdateRequest{
+	err = enqueueTask(c.Request().Context(), h.Queue, chatsQueue, chatUpdateTask, taskID, strconv.FormatInt(applicationID, 10), ChatUpdateRequest{
 		TaskID:        taskID,
 		ApplicationID: applicationID,
 		ChatNumber:    chatNumber,
 		NewSubject:    request.NewSubject,
+	})
+	if errors.Is(err, queue.ErrFull) {
+		failTask(h.TaskStatuses, taskID, "Queue is full")
+		return queueFull(c, h.config.Queue.RetryAfter)
+	}
+	if err != nil {
+		failTask(h.TaskStatuses, taskID, "Failed to queue chat update")
+		return err
 	}
 
-	statusURL := c.Scheme() + "://" + c.Request().Host + "/chats/status/" + taskID
-	return c.JSON(http.StatusAccepted, map[string]string{
-		"status_url": statusURL,
-	})
+	return acceptTask(c, "/chats/status/", taskID)
+}
+
+func (h *ChatHandlers) HandleDeleteChat(c echo.Context) error {
+	token := c.Param("token")
+	chatNumber, err := parseInt64Param("chat_number", c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	applicationID, err := h.ApplicationsDBHandler.GetApplicationIdByToken(token)
+	if err != nil {
+		return err
+	}
+
+	err = h.ChatsDBHandler.DeleteChat(applicationID, chatNumber)
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
+}
+
+func (h *ChatHandlers) HandleRestoreChat(c echo.Context) error {
+	token := c.Param("token")
+	chatNumber, err := parseInt64Param("chat_number", c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	applicationID, err := h.ApplicationsDBHandler.GetApplicationIdByToken(token)
+	if err != nil {
+		return err
+	}
+
+	restoredChat, err := h.ChatsDBHandler.RestoreChat(applicationID, chatNumber)
+	if err != nil {
+		return err
+	}
+
+	response := &response[models.UserExposedChat]{Data: restoredChat.UserExposedChat}
+	return c.JSON(http.StatusOK, response)
 }
 
 func (h *ChatHandlers) HandleGetStatus(c echo.Context) error {
 	taskID := c.Param("taskID")
 
-	status, exists := h.TaskStatusMap[taskID]
-	if !exists {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "Task not found",
-		})
+	taskStatus, err := h.TaskStatuses.GetTaskStatus(taskID)
+	if err != nil {
+		return err
+	}
+
+	status := ChatTaskStatus{Status: taskStatus.Status, Error: taskStatus.Error}
+	if len(taskStatus.Result) > 0 {
+		if err := json.Unmarshal(taskStatus.Result, &status.UserExposedChat); err != nil {
+			return err
+		}
 	}
 
 	return c.JSON(http.StatusOK, status)
 }
+
+// HandleSearchChats finds the chats of an application by subject as the user
+// types, taking the last word of the query as a prefix. It pages like message
+// search.
+func (h *ChatHandlers) HandleSearchChats(c echo.Context) error {
+	from, size, err := parseSearchPage(c)
+	if err != nil {
+		return err
+	}
+
+	applicationId, err := h.ApplicationsDBHandler.GetApplicationIdByToken(c.Param("token"))
+	if err != nil {
+		return err
+	}
+	request := new(searchChatRequest)
+	if err := c.Bind(request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+
+	result, err := h.Searcher.SearchChats(c.Request().Context(), search.ChatQuery{
+		ApplicationId: applicationId,
+		Text:          request.Query,
+		From:          from,
+		Size:          size,
+		PreTag:        request.PreTag,
+		PostTag:       request.PostTag,
+	})
+	if err != nil {
+		return fmt.Errorf("failed to search chats: %w", err)
+	}
+
+	hits := make([]searchChatResponse, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		hits = append(hits, searchChatResponse{Number: hit.Number, Subject: hit.Subject, Score: hit.Score, Highlight: hit.Highlight})
+	}
+	response := &response[[]searchChatResponse]{Data: hits, Total: &result.Total}
+	response.NextCursor, response.PrevCursor = searchCursors(from, len(hits), result.Total)
+	return c.JSON(http.StatusOK, response)
+}
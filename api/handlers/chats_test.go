@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"chat-system/internal/config"
+	"chat-system/internal/database"
+	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"fmt"
+	"net/http"
+	"slices"
+	"testing"
+	"time"
+)
+
+func TestCreateChat(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+
+	if number := s.createChat(token, "first"); number != 1 {
+		t.Fatalf("expected chat number 1, got %d", number)
+	}
+	if number := s.createChat(token, "second"); number != 2 {
+		t.Fatalf("expected chat number 2, got %d", number)
+	}
+
+	expectError(t, s.do(http.MethodPost, "/applications/unknown/chats", map[string]string{"subject": "chat"}), http.StatusNotFound, "not_found")
+	body := expectError(t, s.do(http.MethodPost, "/applications/"+token+"/chats", map[string]string{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	if len(body.Details) != 1 || body.Details[0].Field != "subject" || body.Details[0].Message != "is required" {
+		t.Fatalf("expected a detail for subject, got %+v", body.Details)
+	}
+}
+
+func TestCreateChatIdempotencyKey(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	path := "/applications/" + token + "/chats"
+
+	first := s.do(http.MethodPost, path, map[string]string{"subject": "chat"}, idempotencyKeyHeader, "key-1")
+	firstStatus := statusPath(t, first)
+	waitForTask[ChatTaskStatus](s, firstStatus)
+
+	replay := s.do(http.MethodPost, path, map[string]string{"subject": "chat"}, idempotencyKeyHeader, "key-1")
+	if replayStatus := statusPath(t, replay); replayStatus != firstStatus {
+		t.Fatalf("expected the original status %s, got %s", firstStatus, replayStatus)
+	}
+	if replay.Header().Get(idempotentReplayedHeader) != "true" {
+		t.Fatal("expected the replayed header")
+	}
+
+	expectError(t, s.do(http.MethodPost, path, map[string]string{"subject": "other"}, idempotencyKeyHeader, "key-1"), http.StatusConflict, "conflict")
+
+	rec := s.do(http.MethodGet, path, nil)
+	expectStatus(t, rec, http.StatusOK)
+	if chats := decode[response[[]models.UserExposedChat]](t, rec).Data; len(chats) != 1 {
+		t.Fatalf("expected a single chat, got %+v", chats)
+	}
+}
+
+func TestCreateChatSync(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	path := "/applications/" + token + "/chats"
+
+	rec := s.do(http.MethodPost, path+"?wait=5s", map[string]string{"subject": "chat"}, idempotencyKeyHeader, "key-1")
+	expectStatus(t, rec, http.StatusCreated)
+	if created := decode[response[createChatResponse]](t, rec).Data; created.ChatNumber != 1 || created.Subject != "chat" {
+		t.Fatalf("unexpected created chat %+v", created)
+	}
+	if location := rec.Header().Get("Location"); location != "http://example.com"+chatPath(token, 1) {
+		t.Fatalf("unexpected location %q", location)
+	}
+
+	replay := s.do(http.MethodPost, path, map[string]string{"subject": "chat"}, idempotencyKeyHeader, "key-1", "Prefer", "respond-sync")
+	expectStatus(t, replay, http.StatusCreated)
+	if replay.Header().Get(idempotentReplayedHeader) != "true" || decode[response[createChatResponse]](t, replay).Data.ChatNumber != 1 {
+		t.Fatalf("expected the replayed chat 1, got %s", replay.Body.String())
+	}
+
+	expectError(t, s.do(http.MethodPost, path+"?wait=soon", map[string]string{"subject": "chat"}), http.StatusBadRequest, "bad_request")
+	expectError(t, s.do(http.MethodPost, path+"?wait=11s", map[string]string{"subject": "chat"}), http.StatusBadRequest, "bad_request")
+	expectError(t, s.do(http.MethodPost, path, map[string]string{"subject": "chat"}, "Prefer", "respond-sync, wait=soon"), http.StatusBadRequest, "bad_request")
+}
+
+// blockedChats holds chat creation until release is closed.
+type blockedChats struct {
+	ChatRepository
+	release chan struct{}
+}
+
+func (b *blockedChats) InsertChat(appId int64, subject string) (int64, error) {
+	<-b.release
+	return b.ChatRepository.InsertChat(appId, subject)
+}
+
+func TestCreateChatSyncTimeout(t *testing.T) {
+	blocked := &blockedChats{release: make(chan struct{})}
+	s := newTestServerWithChats(t, config.Default(), func(chats ChatRepository) ChatRepository {
+		blocked.ChatRepository = chats
+		return blocked
+	})
+	token := s.createApplication("app")
+
+	rec := s.do(http.MethodPost, "/applications/"+token+"/chats?wait=50ms", map[string]string{"subject": "chat"})
+	status := statusPath(t, rec)
+	close(blocked.release)
+	if chat := waitForTask[ChatTaskStatus](s, status); chat.Status != models.TaskCompleted || chat.Number != 1 {
+		t.Fatalf("expected chat 1 to be created, got %+v", chat)
+	}
+}
+
+func TestCreateChatSyncFailure(t *testing.T) {
+	s, _ := newFlakyServer(t, 1, 1)
+	token := s.createApplication("app")
+
+	body := expectError(t, s.do(http.MethodPost, "/applications/"+token+"/chats?wait=5s", map[string]string{"subject": "chat"}), http.StatusInternalServerError, "internal_server_error")
+	if body.Message != "Failed to create chat" {
+		t.Fatalf("expected the task error, got %q", body.Message)
+	}
+}
+
+// rejectedChats fails chat creation with err.
+type rejectedChats struct {
+	ChatRepository
+	err error
+}
+
+func (r *rejectedChats) InsertChat(appId int64, subject string) (int64, error) {
+	return 0, fmt.Errorf("failed to insert chat: %w", r.err)
+}
+
+func TestCreateChatSyncDomainFailure(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+		code   string
+		check  func(body apiError) bool
+	}{
+		{&database.NotFoundError{Resource: "application"}, http.StatusNotFound, "not_found", func(body apiError) bool {
+			return body.Message == "application not found"
+		}},
+		{&database.ValidationError{Field: "subject", Reason: "is taken"}, http.StatusUnprocessableEntity, "unprocessable_entity", func(body apiError) bool {
+			return len(body.Details) == 1 && body.Details[0].Field == "subject" && body.Details[0].Message == "is taken"
+		}},
+	}
+	for _, test := range tests {
+		s := newTestServerWithChats(t, config.Default(), func(chats ChatRepository) ChatRepository {
+			return &rejectedChats{ChatRepository: chats, err: test.err}
+		})
+		token := s.createApplication("app")
+
+		rec := s.do(http.MethodPost, "/applications/"+token+"/chats?wait=5s", map[string]string{"subject": "chat"})
+		if body := expectError(t, rec, test.status, test.code); !test.check(body) {
+			t.Fatalf("expected the error of the task, got %+v", body)
+		}
+	}
+}
+
+func TestGetChats(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	for _, subject := range []string{"a", "b", "c"} {
+		s.createChat(token, subject)
+	}
+
+	rec := s.do(http.MethodGet, "/applications/"+token+"/chats?limit=2&order=desc", nil)
+	expectStatus(t, rec, http.StatusOK)
+	page := decode[response[[]models.UserExposedChat]](t, rec)
+	if len(page.Data) != 2 || page.Data[0].Number != 3 || page.NextCursor == "" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+
+	rec = s.do(http.MethodGet, chatPath(token, 2), nil)
+	expectStatus(t, rec, http.StatusOK)
+	if chat := decode[response[models.UserExposedChat]](t, rec).Data; chat.Subject != "b" {
+		t.Fatalf("unexpected chat %+v", chat)
+	}
+
+	expectError(t, s.do(http.MethodGet, chatPath(token, 9), nil), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodGet, "/applications/"+token+"/chats/abc", nil), http.StatusBadRequest, "bad_request")
+	expectError(t, s.do(http.MethodGet, "/applications/unknown/chats", nil), http.StatusNotFound, "not_found")
+}
+
+func TestUpdateChatSubject(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "old")
+
+	rec := s.do(http.MethodPatch, chatPath(token, chatNumber), map[string]string{"newSubject": "new"})
+	status := waitForTask[ChatTaskStatus](s, statusPath(t, rec))
+	if status.Status != models.TaskCompleted || status.Subject != "new" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	// The chat is looked up by the worker, so a missing one fails the task
+	rec = s.do(http.MethodPatch, chatPath(token, 9), map[string]string{"newSubject": "new"})
+	if status := waitForTask[ChatTaskStatus](s, statusPath(t, rec)); status.Status != models.TaskFailed {
+		t.Fatalf("expected the update to fail, got %+v", status)
+	}
+
+	expectError(t, s.do(http.MethodPatch, chatPath(token, chatNumber), map[string]string{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	expectError(t, s.do(http.MethodPatch, chatPath("unknown", chatNumber), map[string]string{"newSubject": "new"}), http.StatusNotFound, "not_found")
+}
+
+func TestDeleteAndRestoreChat(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+
+	expectStatus(t, s.do(http.MethodDelete, chatPath(token, chatNumber), nil), http.StatusNoContent)
+	expectError(t, s.do(http.MethodGet, chatPath(token, chatNumber), nil), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodDelete, chatPath(token, chatNumber), nil), http.StatusNotFound, "not_found")
+
+	rec := s.do(http.MethodPost, chatPath(token, chatNumber)+"/restore", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if chat := decode[response[models.UserExposedChat]](t, rec).Data; chat.Number != chatNumber {
+		t.Fatalf("unexpected restored chat %+v", chat)
+	}
+	expectError(t, s.do(http.MethodPost, chatPath(token, chatNumber)+"/restore", nil), http.StatusNotFound, "not_found")
+
+	// Numbers are not reused after a delete
+	expectStatus(t, s.do(http.MethodDelete, chatPath(token, chatNumber), nil), http.StatusNoContent)
+	if number := s.createChat(token, "next"); number != chatNumber+1 {
+		t.Fatalf("expected chat number %d, got %d", chatNumber+1, number)
+	}
+}
+
+func TestCountsFollowWrites(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	first := s.createChat(token, "first")
+	second := s.createChat(token, "second")
+	s.createMessage(token, first, "hello")
+	s.createMessage(token, first, "again")
+
+	expectStatus(t, s.do(http.MethodDelete, messagePath(token, first, 1), nil), http.StatusNoContent)
+	expectStatus(t, s.do(http.MethodDelete, chatPath(token, second), nil), http.StatusNoContent)
+
+	rec := s.do(http.MethodGet, "/applications/"+token, nil)
+	expectStatus(t, rec, http.StatusOK)
+	if app := decode[response[models.UserExposedApplication]](t, rec).Data; app.ChatsCount != 1 {
+		t.Fatalf("expected 1 chat counted, got %d", app.ChatsCount)
+	}
+	rec = s.do(http.MethodGet, chatPath(token, first), nil)
+	expectStatus(t, rec, http.StatusOK)
+	if chat := decode[response[models.UserExposedChat]](t, rec).Data; chat.MessagesCount != 1 {
+		t.Fatalf("expected 1 message counted, got %d", chat.MessagesCount)
+	}
+}
+
+func TestSearchChats(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	s.createChat(token, "Project planning")
+	renamed := s.createChat(token, "Old name")
+	s.createChat(token, "Plans for the weekend")
+	deleted := s.createChat(token, "Project archive")
+	otherToken := s.createApplication("other")
+	s.createChat(otherToken, "Project elsewhere")
+	path := "/applications/" + token + "/chats/search"
+
+	rec := s.do(http.MethodPatch, chatPath(token, renamed), map[string]string{"newSubject": "Café project"})
+	if status := waitForTask[ChatTaskStatus](s, statusPath(t, rec)); status.Status != models.TaskCompleted {
+		t.Fatalf("renaming chat failed: %s", status.Error)
+	}
+	expectStatus(t, s.do(http.MethodDelete, chatPath(token, deleted), nil), http.StatusNoContent)
+
+	search := func(request map[string]string) []string {
+		t.Helper()
+		rec := s.do(http.MethodGet, path, request)
+		expectStatus(t, rec, http.StatusOK)
+		subjects := []string{}
+		for _, hit := range decode[response[[]searchChatResponse]](t, rec).Data {
+			subjects = append(subjects, hit.Subject)
+		}
+		return subjects
+	}
+	expectHits := func(request map[string]string, expected ...string) {
+		t.Helper()
+		if subjects := search(request); !slices.Equal(subjects, expected) {
+			t.Fatalf("searching %v: expected %q, got %q", request, expected, subjects)
+		}
+	}
+
+	// The last word is completed, the others must match whole
+	expectHits(map[string]string{"query": "pla"}, "Project planning", "Plans for the weekend")
+	expectHits(map[string]string{"query": "project pla"}, "Project planning")
+	expectHits(map[string]string{"query": "cafe proj"}, "Café project")
+	expectHits(map[string]string{"query": "old"})
+	expectHits(map[string]string{"query": "archive"})
+
+	rec = s.do(http.MethodGet, path+"?limit=1", map[string]string{"query": "proj", "preTag": "[", "postTag": "]"})
+	expectStatus(t, rec, http.StatusOK)
+	page := decode[response[[]searchChatResponse]](t, rec)
+	if page.Total == nil || *page.Total != 2 || page.NextCursor == "" || len(page.Data) != 1 || page.Data[0].Highlight != "[Project] planning" {
+		t.Fatalf("unexpected first page %+v", page)
+	}
+
+	expectStatus(t, s.do(http.MethodPost, chatPath(token, deleted)+"/restore", nil), http.StatusOK)
+	expectHits(map[string]string{"query": "archive"}, "Project archive")
+
+	expectError(t, s.do(http.MethodGet, path, map[string]string{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	expectError(t, s.do(http.MethodGet, "/applications/unknown/chats/search", map[string]string{"query": "project"}), http.StatusNotFound, "not_found")
+}
+
+func TestChatSearchConvergesThroughOutbox(t *testing.T) {
+	unreachable := &unreachableSearcher{}
+	s := newTestServerWithSearcher(t, fastRetries(), func(searcher search.MessageSearcher) search.MessageSearcher {
+		unreachable.MessageSearcher = searcher
+		return unreachable
+	})
+	token := s.createApplication("app")
+	renamed := s.createChat(token, "project draft")
+	deleted := s.createChat(token, "project archive")
+	searchProject := func() []string {
+		t.Helper()
+		rec := s.do(http.MethodGet, "/applications/"+token+"/chats/search", map[string]string{"query": "project"})
+		expectStatus(t, rec, http.StatusOK)
+		subjects := []string{}
+		for _, hit := range decode[response[[]searchChatResponse]](t, rec).Data {
+			subjects = append(subjects, hit.Subject)
+		}
+		slices.Sort(subjects)
+		return subjects
+	}
+
+	// Writes succeed while the index is down, which keeps serving old results
+	unreachable.down.Store(true)
+	s.createChat(token, "project launch")
+	rec := s.do(http.MethodPatch, chatPath(token, renamed), map[string]string{"newSubject": "project final"})
+	if status := waitForTask[ChatTaskStatus](s, statusPath(t, rec)); status.Status != models.TaskCompleted {
+		t.Fatalf("renaming chat failed: %s", status.Error)
+	}
+	expectStatus(t, s.do(http.MethodDelete, chatPath(token, deleted), nil), http.StatusNoContent)
+	if subjects := searchProject(); !slices.Equal(subjects, []string{"project archive", "project draft"}) {
+		t.Fatalf("expected the index as before the outage, got %q", subjects)
+	}
+
+	unreachable.down.Store(false)
+	expected := []string{"project final", "project launch"}
+	deadline := time.Now().Add(taskTimeout)
+	for subjects := searchProject(); !slices.Equal(subjects, expected); subjects = searchProject() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q once the index is back, got %q", expected, subjects)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func TestGetUnknownChatStatus(t *testing.T) {
+	s := newTestServer(t)
+
+	body := expectError(t, s.do(http.MethodGet, "/chats/status/unknown", nil), http.StatusNotFound, "not_found")
+	if body.Message != "task not found" {
+		t.Fatalf("unexpected message %q", body.Message)
+	}
+}
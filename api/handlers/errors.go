@@ -0,0 +1,132 @@
+package handlers
+
+import (
+	"chat-system/internal/database"
+	"chat-system/internal/jobs"
+	"chat-system/internal/queue"
+	"errors"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+)
+
+// errorResponse is the body of every failed request.
+type errorResponse struct {
+	Error apiError `json:"error"`
+}
+
+type apiError struct {
+	Code      string       `json:"code"`
+	Message   string       `json:"message"`
+	Details   []fieldError `json:"details,omitempty"`
+	RequestID string       `json:"requestId,omitempty"`
+}
+
+// fieldError tells which field of the request was rejected and why.
+type fieldError struct {
+	Field   string `json:"field"`
+	Message string `json:"message"`
+}
+
+// HTTPErrorHandler answers every error returned by a handler with an
+// errorResponse. Domain errors from the database, and the missing tasks and
+// jobs of the queue and the scheduler, map to 404, 409 and 422, validation
+// failures list the offending fields, and anything unexpected is logged and
+// hidden behind a 500.
+func HTTPErrorHandler(err error, c echo.Context) {
+	if c.Response().Committed {
+		return
+	}
+
+	status, body := mapError(err)
+	body.RequestID = c.Response().Header().Get(echo.HeaderXRequestID)
+	if status >= http.StatusInternalServerError {
+		log.Printf("request %s to %s failed: %v", body.RequestID, c.Path(), err)
+	}
+
+	if c.Request().Method == http.MethodHead {
+		err = c.NoContent(status)
+	} else {
+		err = c.JSON(status, errorResponse{Error: body})
+	}
+	if err != nil {
+		log.Printf("error sending error response: %v", err)
+	}
+}
+
+func mapError(err error) (int, apiError) {
+	var validationErrs validator.ValidationErrors
+	var invalid *database.ValidationError
+	var notFound *database.NotFoundError
+	var conflict *database.ConflictError
+	var httpErr *echo.HTTPError
+
+	switch {
+	case errors.As(err, &validationErrs):
+		details := make([]fieldError, 0, len(validationErrs))
+		for _, fieldErr := range validationErrs {
+			details = append(details, fieldError{Field: fieldErr.Field(), Message: validationMessage(fieldErr)})
+		}
+		return http.StatusUnprocessableEntity, apiError{Code: errorCode(http.StatusUnprocessableEntity), Message: "Request validation failed", Details: details}
+	case errors.As(err, &invalid):
+		return http.StatusUnprocessableEntity, apiError{
+			Code:    errorCode(http.StatusUnprocessableEntity),
+			Message: "Request validation failed",
+			Details: []fieldError{{Field: invalid.Field, Message: invalid.Reason}},
+		}
+	case errors.As(err, &notFound):
+		return http.StatusNotFound, apiError{Code: errorCode(http.StatusNotFound), Message: notFound.Error()}
+	case errors.As(err, &conflict):
+		return http.StatusConflict, apiError{Code: errorCode(http.StatusConflict), Message: conflict.Error()}
+	case errors.Is(err, queue.ErrNotFound):
+		return http.StatusNotFound, apiError{Code: errorCode(http.StatusNotFound), Message: "Task not found"}
+	case errors.Is(err, jobs.ErrNotFound):
+		return http.StatusNotFound, apiError{Code: errorCode(http.StatusNotFound), Message: "Job not found"}
+	case errors.Is(err, jobs.ErrRunning):
+		return http.StatusConflict, apiError{Code: errorCode(http.StatusConflict), Message: "Job is already running"}
+	case errors.Is(err, jobs.ErrStopped):
+		return http.StatusServiceUnavailable, apiError{Code: errorCode(http.StatusServiceUnavailable), Message: "Scheduler is stopped"}
+	case errors.As(err, &httpErr):
+		message, ok := httpErr.Message.(string)
+		if !ok {
+			message = http.StatusText(httpErr.Code)
+		}
+		return httpErr.Code, apiError{Code: errorCode(httpErr.Code), Message: message}
+	}
+	return http.StatusInternalServerError, apiError{Code: errorCode(http.StatusInternalServerError), Message: http.StatusText(http.StatusInternalServerError)}
+}
+
+// errorCode turns a status code into a stable machine readable code, such as
+// "not_found" for 404.
+func errorCode(status int) string {
+	text := http.StatusText(status)
+	if text == "" {
+		return "error"
+	}
+	return strings.ReplaceAll(strings.ToLower(text), " ", "_")
+}
+
+func validationMessage(fieldErr validator.FieldError) string {
+	switch fieldErr.Tag() {
+	case "required":
+		return "is required"
+	case "max":
+		if fieldErr.Kind() == reflect.Slice {
+			return "must have at most " + fieldErr.Param() + " items"
+		}
+		return "must be at most " + fieldErr.Param() + " characters long"
+	case "min":
+		return "must be at least " + fieldErr.Param() + " characters long"
+	case "oneof":
+		return "must be one of " + strings.ReplaceAll(fieldErr.Param(), " ", ", ")
+	case "excluded_if":
+		// The parameter names a struct field followed by its value
+		field, value, _ := strings.Cut(fieldErr.Param(), " ")
+		return "must not be set when " + strings.ToLower(field) + " is " + value
+	}
+	return "failed the " + fieldErr.Tag() + " rule"
+}
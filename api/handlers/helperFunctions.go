@@ -1,9 +1,17 @@
 package handlers
 
 import (
+	"chat-system/internal/config"
+	"chat-system/internal/database"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
 	"fmt"
 	"log"
+	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/labstack/echo/v4"
 )
@@ -17,3 +25,77 @@ func parseInt64Param(paramName string, c echo.Context) (int64, error) {
 	}
 	return value, nil
 }
+
+// lookupChatId finds the id of a chat from the token of its application and
+// its number.
+func lookupChatId(applications ApplicationRepository, chats ChatRepository, token string, chatNumber int64) (int64, error) {
+	applicationId, err := applications.GetApplicationIdByToken(token)
+	if err != nil {
+		return 0, err
+	}
+	return chats.GetChatIdByAppIdAndChatNumber(applicationId, chatNumber)
+}
+
+// NewTaskQueue wraps the durable queue shared by the write handlers so that it
+// holds at most cfg.Capacity pending tasks per queue. Enqueuing gives up after
+// cfg.EnqueueTimeout when the queue stays full.
+func NewTaskQueue(q queue.Queue, cfg config.Queue) *queue.BoundedQueue {
+	return queue.NewBoundedQueue(q, int64(cfg.Capacity), cfg.EnqueueTimeout)
+}
+
+// NewSearchRelay publishes the search outbox cfg.Search.RelayBatchSize entries
+// at a time, retrying failed batches with the jittered delays of failed tasks.
+func NewSearchRelay(outbox search.Outbox, searcher search.MessageSearcher, cfg config.Config) *search.Relay {
+	return search.NewRelay(outbox, searcher, cfg.Search.RelayBatchSize, taskRetryPolicy(cfg.Tasks).Backoff)
+}
+
+// queueFull asks the client to retry after retryAfter when a write could not
+// be queued because the queue is saturated.
+func queueFull(c echo.Context, retryAfter time.Duration) error {
+	c.Response().Header().Set("Retry-After", strconv.Itoa(int(retryAfter.Seconds())))
+	return echo.NewHTTPError(http.StatusServiceUnavailable, "Too many pending writes, please retry later")
+}
+
+// acceptTask answers a queued write with the URL where its status can be polled.
+func acceptTask(c echo.Context, statusPath string, taskID string) error {
+	return c.JSON(http.StatusAccepted, map[string]string{
+		"status_url": absoluteURL(c, statusPath+taskID),
+	})
+}
+
+func enqueueTask(ctx context.Context, q queue.Queue, queueName string, kind string, taskID string, partitionKey string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("failed to encode %s task: %w", kind, err)
+	}
+	return q.Enqueue(ctx, queue.Task{
+		ID:           taskID,
+		Queue:        queueName,
+		Kind:         kind,
+		PartitionKey: partitionKey,
+		Payload:      data,
+	})
+}
+
+// logRemainingTasks reports how many tasks of a queue are left for the next start.
+func logRemainingTasks(q queue.Queue, queueName string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	depth, err := q.Depth(ctx, queueName)
+	if err != nil {
+		log.Printf("error counting remaining tasks in %s queue: %v", queueName, err)
+		return
+	}
+	log.Printf("%d tasks remain persisted in %s queue", depth, queueName)
+}
+
+// taskRetryPolicy retries writes that failed on a transient database or
+// search error, as configured by cfg.
+func taskRetryPolicy(cfg config.Tasks) queue.RetryPolicy {
+	return queue.RetryPolicy{
+		MaxAttempts: cfg.MaxAttempts,
+		BaseDelay:   cfg.RetryBaseDelay,
+		MaxDelay:    cfg.RetryMaxDelay,
+		Retryable:   database.IsTransient,
+	}
+}
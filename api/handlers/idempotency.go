@@ -0,0 +1,116 @@
+package handlers
+
+import (
+	"chat-system/internal/models"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	idempotencyKeyHeader      = "Idempotency-Key"
+	idempotentReplayedHeader  = "Idempotent-Replayed"
+	maxIdempotencyKeyLength   = 255
+	idempotencyConflictReason = "Idempotency-Key was already used with a different payload"
+)
+
+var errIdempotencyConflict = errors.New("idempotency key reused with a different payload")
+
+// IdempotencyStore remembers which task a client-supplied Idempotency-Key
+// started, so that a retried create request is not queued a second time.
+type IdempotencyStore interface {
+	ReserveIdempotencyKey(scope string, key string, requestHash string, taskId string) (models.IdempotencyKey, error)
+	DeleteIdempotencyKey(scope string, key string) error
+	DeleteExpiredIdempotencyKeys() (int64, error)
+}
+
+// idempotentRequest is the Idempotency-Key of a create request, if any.
+type idempotentRequest struct {
+	store IdempotencyStore
+	scope string
+	key   string
+}
+
+func newIdempotentRequest(store IdempotencyStore, c echo.Context) (*idempotentRequest, error) {
+	key := c.Request().Header.Get(idempotencyKeyHeader)
+	if key == "" {
+		return nil, nil
+	}
+	if len(key) > maxIdempotencyKeyLength {
+		return nil, fmt.Errorf("%s must be at most %d characters", idempotencyKeyHeader, maxIdempotencyKeyLength)
+	}
+	return &idempotentRequest{
+		store: store,
+		scope: c.Request().Method + " " + c.Request().URL.Path,
+		key:   key,
+	}, nil
+}
+
+// reserve binds the key to taskID and returns taskID, or returns the task of
+// the earlier request that used the same key and payload.
+func (r *idempotentRequest) reserve(payload interface{}, taskID string) (string, error) {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return "", fmt.Errorf("failed to encode request: %w", err)
+	}
+	hash := sha256.Sum256(data)
+	requestHash := hex.EncodeToString(hash[:])
+
+	idempotencyKey, err := r.store.ReserveIdempotencyKey(r.scope, r.key, requestHash, taskID)
+	if err != nil {
+		return "", err
+	}
+	if idempotencyKey.RequestHash != requestHash {
+		return "", errIdempotencyConflict
+	}
+	return idempotencyKey.TaskId, nil
+}
+
+// release frees the key when the request it was reserved for failed, so that
+// the client can retry with it. It is a no-op for requests without a key.
+func (r *idempotentRequest) release() {
+	if r == nil {
+		return
+	}
+	if err := r.store.DeleteIdempotencyKey(r.scope, r.key); err != nil {
+		log.Printf("error releasing idempotency key: %v", err)
+	}
+}
+
+// checkIdempotency reserves the Idempotency-Key of a create request for
+// taskID. When the key was already used with the same payload, the task of
+// the original request is returned as replayedTaskID and nothing should be
+// queued. The returned request is nil when the client sent no key.
+func checkIdempotency(c echo.Context, store IdempotencyStore, payload interface{}, taskID string) (idempotent *idempotentRequest, replayedTaskID string, err error) {
+	idempotent, err = newIdempotentRequest(store, c)
+	if err != nil {
+		return nil, "", echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	if idempotent == nil {
+		return nil, "", nil
+	}
+
+	reservedTaskID, err := idempotent.reserve(payload, taskID)
+	if errors.Is(err, errIdempotencyConflict) {
+		return nil, "", echo.NewHTTPError(http.StatusConflict, idempotencyConflictReason)
+	}
+	if err != nil {
+		return nil, "", fmt.Errorf("failed to reserve idempotency key: %w", err)
+	}
+	if reservedTaskID != taskID {
+		return idempotent, reservedTaskID, nil
+	}
+	return idempotent, "", nil
+}
+
+// markReplayed tells the client that a retried request is answered with the
+// task started by the original one.
+func markReplayed(c echo.Context) {
+	c.Response().Header().Set(idempotentReplayedHeader, "true")
+}
@@ -1,27 +1,44 @@
 package handlers
 
 import (
-	"bytes"
+	"chat-system/internal/config"
 	"chat-system/internal/database"
 	"chat-system/internal/models"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
 	"context"
 	"encoding/json"
-	"log"
+	"errors"
+	"fmt"
 	"net/http"
+	"strconv"
+	"time"
 
 	"github.com/google/uuid"
 	"github.com/labstack/echo/v4"
 )
 
 type MessageHandlers struct {
-	MessagesDBHandler     *database.MessagesDatabaseHandler
-	ChatsDBHandler        *database.ChatsDatabaseHandler
-	ApplicationsDBHandler *database.ApplicationsDatabaseHandler
-	WriteQueue            chan MessageWriteRequest
-	UpdateQueue           chan MessageUpdateRequest
-	TaskStatusMap         map[string]*MessageTaskStatus
+	MessagesDBHandler     MessageRepository
+	ChatsDBHandler        ChatRepository
+	ApplicationsDBHandler ApplicationRepository
+	Queue                 queue.Queue
+	TaskStatuses          TaskStatusStore
+	IdempotencyKeys       IdempotencyStore
+	Searcher              search.MessageSearcher
+	workers               *queue.Pool
+	config                config.Config
 }
 
+const (
+	messagesQueue     = "messages"
+	messageCreateTask = "create"
+	messageUpdateTask = "update"
+	// messageIndexTask retries indexing a message that is already stored.
+	// The search outbox took over; only tasks queued before it remain.
+	messageIndexTask = "index"
+)
+
 type MessageWriteRequest struct {
 	TaskID      string
 	ChatID      int64
@@ -34,59 +51,106 @@ type MessageUpdateRequest struct {
 	NewBody       string
 }
 
+type MessageIndexRequest struct {
+	ChatID        int64
+	MessageNumber int64
+}
+
 type MessageTaskStatus struct {
-	Status string // "Pending", "Completed", "Error"
+	Status string // "pending", "running", "completed", "failed"
 	models.UserExposedMessage
 	Error string
 }
 
-func CreateMessageHandlers() *MessageHandlers {
-	messagesDbHandler := database.NewMessagesDatabaseHandler()
-	chatsDbHandler := database.NewChatsDatabaseHandler()
-	applicationsDbHandler := database.NewApplicationsDatabaseHandler()
-
+func CreateMessageHandlers(messages MessageRepository, chats ChatRepository, applications ApplicationRepository, taskQueue queue.Queue, taskStatuses TaskStatusStore, idempotencyKeys IdempotencyStore, searcher search.MessageSearcher, cfg config.Config) *MessageHandlers {
 	handler := &MessageHandlers{
-		MessagesDBHandler:     messagesDbHandler,
-		ChatsDBHandler:        chatsDbHandler,
-		ApplicationsDBHandler: applicationsDbHandler,
-		WriteQueue:            make(chan MessageWriteRequest, 1000),
-		UpdateQueue:           make(chan MessageUpdateRequest, 1000),
-		TaskStatusMap:         make(map[string]*MessageTaskStatus),
+		MessagesDBHandler:     messages,
+		ChatsDBHandler:        chats,
+		ApplicationsDBHandler: applications,
+		Queue:                 taskQueue,
+		TaskStatuses:          taskStatuses,
+		IdempotencyKeys:       idempotencyKeys,
+		Searcher:              searcher,
+		config:                cfg,
 	}
 
-	go handler.startWorker()
+	// Messages of one chat are processed in order, different chats concurrently
+	handler.startWorkers()
 
 	return handler
 }
 
-func (h *MessageHandlers) startWorker() {
-	for {
-		select {
-		case createReq := <-h.WriteQueue:
-			status := h.TaskStatusMap[createReq.TaskID]
-			messageNum, err := h.MessagesDBHandler.InsertMessage(createReq.ChatID, createReq.MessageBody)
-			if err != nil {
-				log.Printf("Error inserting message: %v", err)
-				status.Status = "Error"
-				status.Error = "Failed to create message"
-			} else {
-				status.Status = "Completed"
-				status.Number = messageNum
-				status.Body = createReq.MessageBody
-			}
-		case updateReq := <-h.UpdateQueue:
-			status := h.TaskStatusMap[updateReq.TaskID]
-			newMessage, err := h.MessagesDBHandler.UpdateMessageBody(updateReq.ChatID, updateReq.MessageNumber, updateReq.NewBody)
-			if err != nil {
-				log.Printf("Error updating chat: %v", err)
-				status.Status = "Error"
-				status.Error = "Failed to update chat subject"
-			} else {
-				status.Status = "Completed"
-				status.Number = newMessage.Number
-				status.Body = newMessage.Body
-			}
+func (h *MessageHandlers) startWorkers() {
+	h.workers = queue.NewPool(h.Queue, messagesQueue, h.config.Tasks.MessageWorkers, taskRetryPolicy(h.config.Tasks), h.processTask, h.taskFailed)
+	h.workers.Start()
+}
+
+// StopWorkers waits for the writes being processed to finish. Writes still
+// queued are kept in the durable queue for the next start.
+func (h *MessageHandlers) StopWorkers(ctx context.Context) error {
+	err := h.workers.Stop(ctx)
+	logRemainingTasks(h.Queue, messagesQueue)
+	return err
+}
+
+func (h *MessageHandlers) processTask(task *queue.Task) error {
+	if task.Kind == messageIndexTask {
+		var indexReq MessageIndexRequest
+		if err := json.Unmarshal(task.Payload, &indexReq); err != nil {
+			return fmt.Errorf("failed to decode message index task: %w", err)
+		}
+		return h.MessagesDBHandler.IndexMessage(indexReq.ChatID, indexReq.MessageNumber)
+	}
+
+	markTaskRunning(h.TaskStatuses, task.ID)
+
+	switch task.Kind {
+	case messageCreateTask:
+		var createReq MessageWriteRequest
+		if err := json.Unmarshal(task.Payload, &createReq); err != nil {
+			return fmt.Errorf("failed to decode message create task: %w", err)
 		}
+		messageNum, err := h.MessagesDBHandler.InsertMessage(createReq.ChatID, createReq.MessageBody)
+		if err != nil {
+			return err
+		}
+		completeTask(h.TaskStatuses, task.ID, models.UserExposedMessage{
+			Number: messageNum,
+			Body:   createReq.MessageBody,
+		})
+	case messageUpdateTask:
+		var updateReq MessageUpdateRequest
+		if err := json.Unmarshal(task.Payload, &updateReq); err != nil {
+			return fmt.Errorf("failed to decode message update task: %w", err)
+		}
+		newMessage, err := h.MessagesDBHandler.UpdateMessageBody(updateReq.ChatID, updateReq.MessageNumber, updateReq.NewBody)
+		if err != nil {
+			return err
+		}
+		completeTask(h.TaskStatuses, task.ID, models.UserExposedMessage{
+			Number: newMessage.Number,
+			Body:   newMessage.Body,
+		})
+	default:
+		return fmt.Errorf("unknown message task kind %q", task.Kind)
+	}
+	return nil
+}
+
+// taskFailed reports a failed attempt of a task on its status: retrying, or
+// failed for good once the retry policy gives up.
+func (h *MessageHandlers) taskFailed(task *queue.Task, err error, retrying bool) {
+	switch {
+	case task.Kind == messageIndexTask:
+		// Index tasks are internal and have no status to report
+	case retrying:
+		retryTask(h.TaskStatuses, task.ID, task.Attempts)
+	case task.Kind == messageCreateTask:
+		failTaskWith(h.TaskStatuses, task.ID, "Failed to create message", err)
+	case task.Kind == messageUpdateTask:
+		failTaskWith(h.TaskStatuses, task.ID, "Failed to update message body", err)
+	default:
+		failTask(h.TaskStatuses, task.ID, "Unknown task")
 	}
 }
 
@@ -94,54 +158,105 @@ func (h *MessageHandlers) HandleCreateMessage(c echo.Context) error {
 	token := c.Param("token")
 	chatNumber, err := parseInt64Param("chat_number", c)
 	if err != nil {
-		return echo.ErrBadRequest
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	request := new(createMessageRequest)
 	if err := c.Bind(request); err != nil {
-		log.Printf("error binding request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 	if err := c.Validate(request); err != nil {
-		log.Printf("error validating request: %v", err)
-		return echo.ErrBadRequest
+		return err
+	}
+	wait, err := parseWait(c, h.config.Server.SyncWaitTimeout)
+	if err != nil {
+		return err
 	}
 
 	chatID, err := h.getChatIdFromAppTokenAndChatNumber(token, chatNumber)
 	if err != nil {
-		log.Printf("error getting chat id: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	// Generate a unique task ID
 	taskID := uuid.New().String()
 
-	h.TaskStatusMap[taskID] = &MessageTaskStatus{
-		Status: "Pending",
+	idempotent, replayedTaskID, err := checkIdempotency(c, h.IdempotencyKeys, request, taskID)
+	if err != nil {
+		return err
+	}
+	if replayedTaskID != "" {
+		markReplayed(c)
+		return h.respondToCreate(c, token, chatNumber, replayedTaskID, wait)
+	}
+
+	if err := h.TaskStatuses.InsertTaskStatus(taskID); err != nil {
+		idempotent.release()
+		return err
 	}
 
 	// Push the request to the queue
-	h.WriteQueue <- MessageWriteRequest{
+	err = enqueueTask(c.Request().Context(), h.Queue, messagesQueue, messageCreateTask, taskID, strconv.FormatInt(chatID, 10), MessageWriteRequest{
 		TaskID:      taskID,
 		ChatID:      chatID,
 		MessageBody: request.Body,
+	})
+	if errors.Is(err, queue.ErrFull) {
+		failTask(h.TaskStatuses, taskID, "Queue is full")
+		idempotent.release()
+		return queueFull(c, h.config.Queue.RetryAfter)
+	}
+	if err != nil {
+		failTask(h.TaskStatuses, taskID, "Failed to queue message creation")
+		idempotent.release()
+		return err
 	}
 
-	// Respond with the status-check URL
-	statusURL := c.Scheme() + "://" + c.Request().Host + "/messages/status/" + taskID
-	return c.JSON(http.StatusAccepted, map[string]string{
-		"status_url": statusURL,
+	return h.respondToCreate(c, token, chatNumber, taskID, wait)
+}
+
+// respondToCreate answers a message creation as ChatHandlers.respondToCreate
+// answers a chat creation.
+func (h *MessageHandlers) respondToCreate(c echo.Context, token string, chatNumber int64, taskID string, wait time.Duration) error {
+	if wait == 0 {
+		return acceptTask(c, "/messages/status/", taskID)
+	}
+	status, finished, err := awaitTask(c.Request().Context(), h.TaskStatuses, taskID, wait)
+	if err != nil {
+		return err
+	}
+	if !finished {
+		return acceptTask(c, "/messages/status/", taskID)
+	}
+	if status.Status == models.TaskFailed {
+		if err := database.ErrorOfKind(status.ErrorKind, status.Error); err != nil {
+			return err
+		}
+		return echo.NewHTTPError(http.StatusInternalServerError, status.Error)
+	}
+
+	var message models.UserExposedMessage
+	if err := json.Unmarshal(status.Result, &message); err != nil {
+		return err
+	}
+	return respondCreated(c, messagePath(token, chatNumber, message.Number), &response[createMessageResponse]{
+		Data: createMessageResponse{MessageNumber: message.Number, Body: message.Body},
 	})
 }
 
 func (h *MessageHandlers) HandleGetMessageStatus(c echo.Context) error {
 	taskID := c.Param("taskID")
 
-	status, exists := h.TaskStatusMap[taskID]
-	if !exists {
-		return c.JSON(http.StatusNotFound, map[string]string{
-			"error": "Task not found",
-		})
+	taskStatus, err := h.TaskStatuses.GetTaskStatus(taskID)
+	if err != nil {
+		return err
+	}
+
+	status := MessageTaskStatus{Status: taskStatus.Status, Error: taskStatus.Error}
+	if len(taskStatus.Result) > 0 {
+		if err := json.Unmarshal(taskStatus.Result, &status.UserExposedMessage); err != nil {
+			return err
+		}
 	}
 
 	return c.JSON(http.StatusOK, status)
@@ -151,18 +266,21 @@ func (h *MessageHandlers) HandleGetAllMessagesForChat(c echo.Context) error {
 	token := c.Param("token")
 	chatNumber, err := parseInt64Param("chat_number", c)
 	if err != nil {
-		return echo.ErrBadRequest
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	chatId, err := h.getChatIdFromAppTokenAndChatNumber(token, chatNumber)
 	if err != nil {
-		log.Printf("error getting chat id: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
-	messages, err := h.MessagesDBHandler.GetAllMessagesForAChat(chatId)
+	messages, hasMore, err := h.MessagesDBHandler.GetAllMessagesForAChat(chatId, page)
 	if err != nil {
-		log.Printf("error getting messages: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 	var userExposedMessages []models.UserExposedMessage
 	for _, message := range messages {
@@ -173,6 +291,9 @@ func (h *MessageHandlers) HandleGetAllMessagesForChat(c echo.Context) error {
 	}
 
 	response := &response[[]models.UserExposedMessage]{Data: userExposedMessages}
+	if len(messages) > 0 {
+		response.NextCursor, response.PrevCursor = pageCursors(page, messages[0].Number, messages[len(messages)-1].Number, hasMore)
+	}
 
 	return c.JSON(http.StatusOK, response)
 }
@@ -181,23 +302,21 @@ func (h *MessageHandlers) HandleGetMessage(c echo.Context) error {
 	token := c.Param("token")
 	chatNumber, err := parseInt64Param("chat_number", c)
 	if err != nil {
-		return echo.ErrBadRequest
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	messageNumber, err := parseInt64Param("message_number", c)
 	if err != nil {
-		return echo.ErrBadRequest
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	chatId, err := h.getChatIdFromAppTokenAndChatNumber(token, chatNumber)
 	if err != nil {
-		log.Printf("error getting chat id: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	message, err := h.MessagesDBHandler.GetMessageByChatIdAndMessageNumber(chatId, messageNumber)
 	if err != nil {
-		log.Printf("error getting message: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	userExposedMessage := models.UserExposedMessage{
@@ -214,139 +333,210 @@ func (h *MessageHandlers) HandleUpdateMessageBody(c echo.Context) error {
 	token := c.Param("token")
 	chatNumber, err := parseInt64Param("chat_number", c)
 	if err != nil {
-		return echo.ErrBadRequest
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 	messageNumber, err := parseInt64Param("message_number", c)
 	if err != nil {
-		return echo.ErrBadRequest
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
 	}
 
 	request := new(updateMessageRequest)
 	if err := c.Bind(request); err != nil {
-		log.Printf("error binding request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 	if err := c.Validate(request); err != nil {
-		log.Printf("error validating request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 
 	chatID, err := h.getChatIdFromAppTokenAndChatNumber(token, chatNumber)
 	if err != nil {
-		log.Printf("error getting chat id: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 
 	taskID := uuid.New().String()
 
-	h.TaskStatusMap[taskID] = &MessageTaskStatus{
-		Status: "Pending",
+	if err := h.TaskStatuses.InsertTaskStatus(taskID); err != nil {
+		return err
 	}
 
-	// Push the update request to the UpdateQueue
-	h.UpdateQueue <- MessageUpdateRequest{
+	// Push the update request to the queue
+	err = enqueueTask(c.Request().Context(), h.Queue, messagesQueue, messageUpdateTask, taskID, strconv.FormatInt(chatID, 10), MessageUpdateRequest{
 		TaskID:        taskID,
 		ChatID:        chatID,
 		MessageNumber: messageNumber,
 		NewBody:       request.NewBody,
+	})
+	if errors.Is(err, queue.ErrFull) {
+		failTask(h.TaskStatuses, taskID, "Queue is full")
+		return queueFull(c, h.config.Queue.RetryAfter)
+	}
+	if err != nil {
+		failTask(h.TaskStatuses, taskID, "Failed to queue message update")
+		return err
 	}
 
 	// Respond with the status-check URL
-	statusURL := c.Scheme() + "://" + c.Request().Host + "/messages/status/" + taskID
-	return c.JSON(http.StatusAccepted, map[string]string{
-		"status_url": statusURL,
-	})
+	return acceptTask(c, "/messages/status/", taskID)
+}
+
+func (h *MessageHandlers) HandleDeleteMessage(c echo.Context) error {
+	token := c.Param("token")
+	chatNumber, err := parseInt64Param("chat_number", c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	messageNumber, err := parseInt64Param("message_number", c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	chatID, err := h.getChatIdFromAppTokenAndChatNumber(token, chatNumber)
+	if err != nil {
+		return err
+	}
+
+	err = h.MessagesDBHandler.DeleteMessage(chatID, messageNumber)
+	if err != nil {
+		return err
+	}
+
+	return c.NoContent(http.StatusNoContent)
 }
 
+func (h *MessageHandlers) HandleRestoreMessage(c echo.Context) error {
+	token := c.Param("token")
+	chatNumber, err := parseInt64Param("chat_number", c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	messageNumber, err := parseInt64Param("message_number", c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	chatID, err := h.getChatIdFromAppTokenAndChatNumber(token, chatNumber)
+	if err != nil {
+		return err
+	}
+
+	restoredMessage, err := h.MessagesDBHandler.RestoreMessage(chatID, messageNumber)
+	if err != nil {
+		return err
+	}
+
+	response := &response[models.UserExposedMessage]{Data: restoredMessage.UserExposedMessage}
+	return c.JSON(http.StatusOK, response)
+}
+
+// HandleSearchMessages searches the messages of a chat and answers with the
+// page of hits picked by the limit, after and before query parameters, ordered
+// by the sort of the request.
 func (h *MessageHandlers) HandleSearchMessages(c echo.Context) error {
 	token := c.Param("token")
 	chatNumber, err := parseInt64Param("chat_number", c)
 	if err != nil {
-		return echo.ErrBadRequest
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	from, size, err := parseSearchPage(c)
+	if err != nil {
+		return err
 	}
+
 	chatId, err := h.getChatIdFromAppTokenAndChatNumber(token, chatNumber)
 	if err != nil {
-		log.Printf("error getting chat id: %v", err)
-		return echo.ErrInternalServerError
+		return err
 	}
 	request := new(searchMessageRequest)
 	if err := c.Bind(request); err != nil {
-		log.Printf("error binding request: %v", err)
-		return echo.ErrBadRequest
+		return err
 	}
 	if err := c.Validate(request); err != nil {
-		log.Printf("error validating request: %v", err)
-		return echo.ErrBadRequest
-	}
-
-	searchQuery := map[string]interface{}{
-		"query": map[string]interface{}{
-			"bool": map[string]interface{}{
-				"must": []interface{}{
-					map[string]interface{}{
-						"match": map[string]interface{}{
-							"chat_id": chatId,
-						},
-					},
-					map[string]interface{}{
-						"wildcard": map[string]interface{}{
-							"body": map[string]interface{}{
-								"value": "*" + request.Query + "*", // Matching any part of the string
-							},
-						},
-					},
-				},
-			},
-		},
-	}
-
-	reqBody, _ := json.Marshal(searchQuery)
-	res, err := database.ESClient.Search(
-		database.ESClient.Search.WithContext(context.Background()),
-		database.ESClient.Search.WithIndex("messages"),
-		database.ESClient.Search.WithBody(bytes.NewReader(reqBody)),
-		database.ESClient.Search.WithTrackTotalHits(true),
-	)
-	if err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": err.Error()})
-	}
-	defer res.Body.Close()
-
-	if res.IsError() {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": res.String()})
-	}
-
-	var result map[string]interface{}
-	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
-		return c.JSON(http.StatusInternalServerError, map[string]string{"error": "failed to parse response"})
-	}
-
-	// Extract the desired fields from the search results
-	hits := result["hits"].(map[string]interface{})["hits"].([]interface{})
-	filteredResults := []map[string]interface{}{}
-
-	for _, hit := range hits {
-		hitMap := hit.(map[string]interface{})
-		source := hitMap["_source"].(map[string]interface{})
-		filteredResults = append(filteredResults, map[string]interface{}{
-			"number": source["number"],
-			"body":   source["body"],
-		})
+		return err
 	}
 
-	return c.JSON(http.StatusOK, filteredResults)
+	query := request.query(from, size)
+	query.ChatId = chatId
+	return h.search(c, query)
 }
 
-func (h *MessageHandlers) getChatIdFromAppTokenAndChatNumber(token string, chatNumber int64) (int64, error) {
-	applicationId, err := h.ApplicationsDBHandler.GetApplicationIdByToken(token)
+// HandleSearchApplicationMessages searches the messages of every chat of an
+// application, like HandleSearchMessages. Hits can be narrowed to some chats
+// and to a range of creation dates, and counted by chat.
+func (h *MessageHandlers) HandleSearchApplicationMessages(c echo.Context) error {
+	from, size, err := parseSearchPage(c)
 	if err != nil {
-		log.Printf("error getting app id: %v", err)
-		return 0, err
+		return err
 	}
-	chatId, err := h.ChatsDBHandler.GetChatIdByAppIdAndChatNumber(applicationId, chatNumber)
+
+	applicationId, err := h.ApplicationsDBHandler.GetApplicationIdByToken(c.Param("token"))
 	if err != nil {
-		log.Printf("error getting chat id: %v", err)
-		return 0, err
+		return err
+	}
+	request := new(searchApplicationMessagesRequest)
+	if err := c.Bind(request); err != nil {
+		return err
+	}
+	if err := c.Validate(request); err != nil {
+		return err
+	}
+	if request.CreatedFrom != nil && request.CreatedTo != nil && request.CreatedTo.Before(*request.CreatedFrom) {
+		return echo.NewHTTPError(http.StatusUnprocessableEntity, "createdTo must not be before createdFrom")
+	}
+	query := request.query(from, size)
+	query.ApplicationId = applicationId
+	query.ChatNumbers = request.ChatNumbers
+	if request.CreatedFrom != nil {
+		query.CreatedFrom = *request.CreatedFrom
+	}
+	if request.CreatedTo != nil {
+		query.CreatedTo = *request.CreatedTo
 	}
-	return chatId, nil
+	query.CountChats = request.Facets
+	return h.search(c, query)
+}
+
+func (r *searchMessageRequest) query(from int, size int) search.Query {
+	return search.Query{
+		Text:      r.Query,
+		Mode:      r.Mode,
+		Fuzziness: r.Fuzziness,
+		Sort:      r.Sort,
+		From:      from,
+		Size:      size,
+		PreTag:    r.PreTag,
+		PostTag:   r.PostTag,
+	}
+}
+
+// search answers with a page of the hits of query.
+func (h *MessageHandlers) search(c echo.Context, query search.Query) error {
+	result, err := h.Searcher.SearchMessages(c.Request().Context(), query)
+	if err != nil {
+		return fmt.Errorf("failed to search messages: %w", err)
+	}
+
+	hits := make([]searchMessageResponse, 0, len(result.Hits))
+	for _, hit := range result.Hits {
+		hits = append(hits, searchMessageResponse{
+			ChatNumber: hit.ChatNumber,
+			Number:     hit.Number,
+			Body:       hit.Body,
+			CreatedAt:  hit.CreatedAt,
+			Score:      hit.Score,
+			Highlight:  hit.Highlight,
+		})
+	}
+	response := &response[[]searchMessageResponse]{Data: hits, Total: &result.Total}
+	response.NextCursor, response.PrevCursor = searchCursors(query.From, len(hits), result.Total)
+	if query.CountChats {
+		response.Facets = &searchFacetsResponse{Chats: make([]chatHitsResponse, 0, len(result.ChatCounts))}
+		for _, count := range result.ChatCounts {
+			response.Facets.Chats = append(response.Facets.Chats, chatHitsResponse{ChatNumber: count.ChatNumber, Hits: count.Count})
+		}
+	}
+	return c.JSON(http.StatusOK, response)
+}
+
+func (h *MessageHandlers) getChatIdFromAppTokenAndChatNumber(token string, chatNumber int64) (int64, error) {
+	return lookupChatId(h.ApplicationsDBHandler, h.ChatsDBHandler, token, chatNumber)
 }
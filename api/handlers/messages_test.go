@@ -0,0 +1,494 @@
+package handlers
+
+import (
+	"chat-system/internal/config"
+	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCreateMessage(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+
+	if number := s.createMessage(token, chatNumber, "hello"); number != 1 {
+		t.Fatalf("expected message number 1, got %d", number)
+	}
+	if number := s.createMessage(token, chatNumber, "again"); number != 2 {
+		t.Fatalf("expected message number 2, got %d", number)
+	}
+
+	expectError(t, s.do(http.MethodPost, chatPath(token, 9)+"/messages", map[string]string{"body": "hello"}), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodPost, chatPath(token, chatNumber)+"/messages", map[string]string{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	expectError(t, s.do(http.MethodPost, "/applications/"+token+"/chats/abc/messages", map[string]string{"body": "hello"}), http.StatusBadRequest, "bad_request")
+}
+
+func TestCreateMessageIdempotencyKey(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	path := chatPath(token, chatNumber) + "/messages"
+
+	first := statusPath(t, s.do(http.MethodPost, path, map[string]string{"body": "hello"}, idempotencyKeyHeader, "key-1"))
+	waitForTask[MessageTaskStatus](s, first)
+
+	if replay := statusPath(t, s.do(http.MethodPost, path, map[string]string{"body": "hello"}, idempotencyKeyHeader, "key-1")); replay != first {
+		t.Fatalf("expected the original status %s, got %s", first, replay)
+	}
+	expectError(t, s.do(http.MethodPost, path, map[string]string{"body": "other"}, idempotencyKeyHeader, "key-1"), http.StatusConflict, "conflict")
+}
+
+func TestCreateMessageSync(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+
+	rec := s.do(http.MethodPost, chatPath(token, chatNumber)+"/messages", map[string]string{"body": "hello"}, "Prefer", "respond-sync")
+	expectStatus(t, rec, http.StatusCreated)
+	if created := decode[response[createMessageResponse]](t, rec).Data; created.MessageNumber != 1 || created.Body != "hello" {
+		t.Fatalf("unexpected created message %+v", created)
+	}
+	if location := rec.Header().Get("Location"); location != "http://example.com"+messagePath(token, chatNumber, 1) {
+		t.Fatalf("unexpected location %q", location)
+	}
+
+	rec = s.do(http.MethodGet, messagePath(token, chatNumber, 1), nil)
+	expectStatus(t, rec, http.StatusOK)
+}
+
+func TestGetMessages(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	for _, body := range []string{"a", "b", "c"} {
+		s.createMessage(token, chatNumber, body)
+	}
+
+	rec := s.do(http.MethodGet, chatPath(token, chatNumber)+"/messages?limit=2", nil)
+	expectStatus(t, rec, http.StatusOK)
+	page := decode[response[[]models.UserExposedMessage]](t, rec)
+	if len(page.Data) != 2 || page.Data[0].Body != "a" || page.NextCursor == "" {
+		t.Fatalf("unexpected page %+v", page)
+	}
+
+	rec = s.do(http.MethodGet, chatPath(token, chatNumber)+"/messages?limit=2&after="+page.NextCursor, nil)
+	expectStatus(t, rec, http.StatusOK)
+	if next := decode[response[[]models.UserExposedMessage]](t, rec); len(next.Data) != 1 || next.Data[0].Body != "c" || next.NextCursor != "" {
+		t.Fatalf("unexpected next page %+v", next)
+	}
+
+	rec = s.do(http.MethodGet, messagePath(token, chatNumber, 2), nil)
+	expectStatus(t, rec, http.StatusOK)
+	if message := decode[response[models.UserExposedMessage]](t, rec).Data; message.Body != "b" {
+		t.Fatalf("unexpected message %+v", message)
+	}
+
+	expectError(t, s.do(http.MethodGet, messagePath(token, chatNumber, 9), nil), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodGet, chatPath(token, chatNumber)+"/messages/abc", nil), http.StatusBadRequest, "bad_request")
+	expectError(t, s.do(http.MethodGet, chatPath(token, 9)+"/messages", nil), http.StatusNotFound, "not_found")
+}
+
+func TestUpdateMessageBody(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	messageNumber := s.createMessage(token, chatNumber, "old")
+
+	rec := s.do(http.MethodPatch, messagePath(token, chatNumber, messageNumber), map[string]string{"newBody": "new"})
+	status := waitForTask[MessageTaskStatus](s, statusPath(t, rec))
+	if status.Status != models.TaskCompleted || status.Body != "new" {
+		t.Fatalf("unexpected status %+v", status)
+	}
+
+	rec = s.do(http.MethodGet, messagePath(token, chatNumber, messageNumber), nil)
+	expectStatus(t, rec, http.StatusOK)
+	if message := decode[response[models.UserExposedMessage]](t, rec).Data; message.Body != "new" {
+		t.Fatalf("expected the new body, got %+v", message)
+	}
+
+	expectError(t, s.do(http.MethodPatch, messagePath(token, chatNumber, messageNumber), map[string]string{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+}
+
+func TestDeleteAndRestoreMessage(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	messageNumber := s.createMessage(token, chatNumber, "hello")
+
+	expectStatus(t, s.do(http.MethodDelete, messagePath(token, chatNumber, messageNumber), nil), http.StatusNoContent)
+	expectError(t, s.do(http.MethodGet, messagePath(token, chatNumber, messageNumber), nil), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodDelete, messagePath(token, chatNumber, messageNumber), nil), http.StatusNotFound, "not_found")
+
+	rec := s.do(http.MethodPost, messagePath(token, chatNumber, messageNumber)+"/restore", nil)
+	expectStatus(t, rec, http.StatusOK)
+	if message := decode[response[models.UserExposedMessage]](t, rec).Data; message.Body != "hello" {
+		t.Fatalf("unexpected restored message %+v", message)
+	}
+	expectError(t, s.do(http.MethodPost, messagePath(token, chatNumber, messageNumber)+"/restore", nil), http.StatusNotFound, "not_found")
+}
+
+func TestMessagesOfDeletedChatAreHidden(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	messageNumber := s.createMessage(token, chatNumber, "hello")
+
+	expectStatus(t, s.do(http.MethodDelete, chatPath(token, chatNumber), nil), http.StatusNoContent)
+	expectError(t, s.do(http.MethodGet, messagePath(token, chatNumber, messageNumber), nil), http.StatusNotFound, "not_found")
+}
+
+func TestGetUnknownMessageStatus(t *testing.T) {
+	s := newTestServer(t)
+
+	expectError(t, s.do(http.MethodGet, "/messages/status/unknown", nil), http.StatusNotFound, "not_found")
+}
+
+func TestSearchMessages(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	otherChatNumber := s.createChat(token, "other")
+	s.createMessage(token, chatNumber, "Hello world")
+	greeting := s.createMessage(token, chatNumber, "well, hello there")
+	s.createMessage(token, chatNumber, "goodbye")
+	s.createMessage(token, chatNumber, "world, hello")
+	s.createMessage(token, chatNumber, "Café au lait")
+	s.createMessage(token, otherChatNumber, "hello from elsewhere")
+	path := chatPath(token, chatNumber) + "/messages/search"
+
+	search := func(request map[string]string) []string {
+		t.Helper()
+		rec := s.do(http.MethodGet, path, request)
+		expectStatus(t, rec, http.StatusOK)
+		bodies := []string{}
+		for _, hit := range decode[response[[]searchMessageResponse]](t, rec).Data {
+			bodies = append(bodies, hit.Body)
+		}
+		return bodies
+	}
+	expectHits := func(request map[string]string, expected ...string) {
+		t.Helper()
+		if bodies := search(request); !slices.Equal(bodies, expected) {
+			t.Fatalf("searching %v: expected %q, got %q", request, expected, bodies)
+		}
+	}
+
+	// Messages with more of the words rank first
+	expectHits(map[string]string{"query": "HELLO world"}, "Hello world", "world, hello", "well, hello there")
+	expectHits(map[string]string{"query": "hello world", "mode": "phrase"}, "Hello world")
+	expectHits(map[string]string{"query": "goo", "mode": "prefix"}, "goodbye")
+	expectHits(map[string]string{"query": "helo"})
+	expectHits(map[string]string{"query": "helo", "fuzziness": "AUTO"}, "Hello world", "world, hello", "well, hello there")
+	expectHits(map[string]string{"query": "cafe"}, "Café au lait")
+
+	expectStatus(t, s.do(http.MethodDelete, messagePath(token, chatNumber, greeting), nil), http.StatusNoContent)
+	expectHits(map[string]string{"query": "there"})
+	expectStatus(t, s.do(http.MethodPost, messagePath(token, chatNumber, greeting)+"/restore", nil), http.StatusOK)
+	expectHits(map[string]string{"query": "there"}, "well, hello there")
+
+	expectError(t, s.do(http.MethodGet, path, map[string]string{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	expectError(t, s.do(http.MethodGet, path, map[string]string{"query": "hello", "mode": "regex"}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	if err := expectError(t, s.do(http.MethodGet, path, map[string]string{"query": "hello", "mode": "phrase", "fuzziness": "1"}), http.StatusUnprocessableEntity, "unprocessable_entity"); len(err.Details) != 1 || err.Details[0].Field != "fuzziness" {
+		t.Fatalf("unexpected error details %+v", err.Details)
+	}
+	expectError(t, s.do(http.MethodGet, chatPath(token, 9)+"/messages/search", map[string]string{"query": "hello"}), http.StatusNotFound, "not_found")
+}
+
+func TestSearchMessagesPages(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	for _, body := range []string{"a note", "note, note", "another note", "no match"} {
+		s.createMessage(token, chatNumber, body)
+	}
+	path := chatPath(token, chatNumber) + "/messages/search"
+
+	searchPage := func(query string, request map[string]string) response[[]searchMessageResponse] {
+		t.Helper()
+		rec := s.do(http.MethodGet, path+query, request)
+		expectStatus(t, rec, http.StatusOK)
+		return decode[response[[]searchMessageResponse]](t, rec)
+	}
+	bodies := func(page response[[]searchMessageResponse]) []string {
+		bodies := []string{}
+		for _, hit := range page.Data {
+			bodies = append(bodies, hit.Body)
+		}
+		return bodies
+	}
+
+	// The most relevant message comes first, with its score and highlight
+	first := searchPage("?limit=2", map[string]string{"query": "note"})
+	if first.Total == nil || *first.Total != 3 || first.NextCursor == "" || first.PrevCursor != "" {
+		t.Fatalf("unexpected first page %+v", first)
+	}
+	if hit := first.Data[0]; hit.Body != "note, note" || hit.Highlight != "<em>note</em>, <em>note</em>" || hit.Score <= first.Data[1].Score {
+		t.Fatalf("unexpected first hit %+v", hit)
+	}
+	second := searchPage("?limit=2&after="+first.NextCursor, map[string]string{"query": "note"})
+	if len(second.Data) != 1 || second.NextCursor != "" || second.PrevCursor == "" {
+		t.Fatalf("unexpected second page %+v", second)
+	}
+	back := searchPage("?limit=2&before="+second.PrevCursor, map[string]string{"query": "note"})
+	if !slices.Equal(bodies(back), bodies(first)) {
+		t.Fatalf("expected to page back to %q, got %q", bodies(first), bodies(back))
+	}
+
+	if page := searchPage("", map[string]string{"query": "note", "sort": "newest"}); !slices.Equal(bodies(page), []string{"another note", "note, note", "a note"}) {
+		t.Fatalf("unexpected newest first %q", bodies(page))
+	}
+	if page := searchPage("", map[string]string{"query": "note", "sort": "oldest"}); !slices.Equal(bodies(page), []string{"a note", "note, note", "another note"}) {
+		t.Fatalf("unexpected oldest first %q", bodies(page))
+	}
+	if page := searchPage("", map[string]string{"query": "another", "preTag": "[", "postTag": "]"}); len(page.Data) != 1 || page.Data[0].Highlight != "[another] note" {
+		t.Fatalf("unexpected highlight %+v", page.Data)
+	}
+	if page := searchPage("", map[string]string{"query": "missing"}); page.Total == nil || *page.Total != 0 || len(page.Data) != 0 {
+		t.Fatalf("expected no hits, got %+v", page)
+	}
+
+	expectError(t, s.do(http.MethodGet, path+"?order=desc", map[string]string{"query": "note"}), http.StatusBadRequest, "bad_request")
+	expectError(t, s.do(http.MethodGet, path+"?after="+encodeCursor(10000), map[string]string{"query": "note"}), http.StatusBadRequest, "bad_request")
+	expectError(t, s.do(http.MethodGet, path, map[string]string{"query": "note", "sort": "random"}), http.StatusUnprocessableEntity, "unprocessable_entity")
+}
+
+func TestSearchApplicationMessages(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	first := s.createChat(token, "first")
+	second := s.createChat(token, "second")
+	deleted := s.createChat(token, "deleted")
+	s.createMessage(token, first, "hello world")
+	s.createMessage(token, second, "hello there")
+	s.createMessage(token, second, "hello again")
+	s.createMessage(token, second, "goodbye")
+	s.createMessage(token, deleted, "hello from a deleted chat")
+	otherToken := s.createApplication("other")
+	s.createMessage(otherToken, s.createChat(otherToken, "chat"), "hello from another app")
+	expectStatus(t, s.do(http.MethodDelete, chatPath(token, deleted), nil), http.StatusNoContent)
+	path := "/applications/" + token + "/messages/search"
+
+	searchPage := func(request map[string]interface{}) response[[]searchMessageResponse] {
+		t.Helper()
+		rec := s.do(http.MethodGet, path+"?limit=10", request)
+		expectStatus(t, rec, http.StatusOK)
+		return decode[response[[]searchMessageResponse]](t, rec)
+	}
+	hits := func(page response[[]searchMessageResponse]) []string {
+		hits := []string{}
+		for _, hit := range page.Data {
+			hits = append(hits, fmt.Sprintf("%d/%d %s", hit.ChatNumber, hit.Number, hit.Body))
+		}
+		return hits
+	}
+
+	// The messages of a deleted chat leave the index through the search
+	// outbox, and come back with the chat
+	waitForHits := func(expected ...string) {
+		t.Helper()
+		deadline := time.Now().Add(taskTimeout)
+		for page := searchPage(map[string]interface{}{"query": "deleted"}); !slices.Equal(hits(page), expected); page = searchPage(map[string]interface{}{"query": "deleted"}) {
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %q, got %q", expected, hits(page))
+			}
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	waitForHits()
+
+	page := searchPage(map[string]interface{}{"query": "hello", "sort": "oldest", "facets": true})
+	if expected := []string{"1/1 hello world", "2/1 hello there", "2/2 hello again"}; !slices.Equal(hits(page), expected) {
+		t.Fatalf("expected %q, got %q", expected, hits(page))
+	}
+	if page.Total == nil || *page.Total != 3 {
+		t.Fatalf("unexpected total %v", page.Total)
+	}
+	if expected := []chatHitsResponse{{ChatNumber: second, Hits: 2}, {ChatNumber: first, Hits: 1}}; page.Facets == nil || !slices.Equal(page.Facets.Chats, expected) {
+		t.Fatalf("expected facets %+v, got %+v", expected, page.Facets)
+	}
+	if page.Data[0].CreatedAt.IsZero() {
+		t.Fatalf("expected hits to carry their creation date, got %+v", page.Data[0])
+	}
+
+	page = searchPage(map[string]interface{}{"query": "hello", "sort": "oldest", "chatNumbers": []int64{first, deleted}})
+	if expected := []string{"1/1 hello world"}; !slices.Equal(hits(page), expected) || page.Facets != nil {
+		t.Fatalf("expected %q without facets, got %q and %+v", expected, hits(page), page.Facets)
+	}
+
+	now := time.Now()
+	page = searchPage(map[string]interface{}{"query": "hello", "createdFrom": now.Add(-time.Hour), "createdTo": now.Add(time.Hour)})
+	if len(page.Data) != 3 {
+		t.Fatalf("expected every hit within the last hour, got %q", hits(page))
+	}
+	page = searchPage(map[string]interface{}{"query": "hello", "createdFrom": now.Add(time.Hour), "facets": true})
+	if len(page.Data) != 0 || page.Facets == nil || len(page.Facets.Chats) != 0 {
+		t.Fatalf("expected no hits from the next hour, got %q and %+v", hits(page), page.Facets)
+	}
+
+	expectError(t, s.do(http.MethodGet, path, map[string]interface{}{"query": "hello", "createdFrom": now, "createdTo": now.Add(-time.Hour)}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	if err := expectError(t, s.do(http.MethodGet, path, map[string]interface{}{"query": "hello", "chatNumbers": make([]int64, 101)}), http.StatusUnprocessableEntity, "unprocessable_entity"); len(err.Details) != 1 || err.Details[0].Field != "chatNumbers" {
+		t.Fatalf("unexpected error details %+v", err.Details)
+	}
+	expectError(t, s.do(http.MethodGet, path, map[string]interface{}{}), http.StatusUnprocessableEntity, "unprocessable_entity")
+	expectError(t, s.do(http.MethodGet, path+"?order=desc", map[string]interface{}{"query": "hello"}), http.StatusBadRequest, "bad_request")
+	expectError(t, s.do(http.MethodGet, "/applications/unknown/messages/search", map[string]interface{}{"query": "hello"}), http.StatusNotFound, "not_found")
+
+	expectStatus(t, s.do(http.MethodPost, chatPath(token, deleted)+"/restore", nil), http.StatusOK)
+	waitForHits("3/1 hello from a deleted chat")
+}
+
+// unreachableSearcher fails every write to the search index while down is set.
+type unreachableSearcher struct {
+	search.MessageSearcher
+	down atomic.Bool
+}
+
+var errSearchUnreachable = errors.New("search index unreachable")
+
+func (u *unreachableSearcher) IndexMessage(ctx context.Context, doc search.MessageDocument) error {
+	if u.down.Load() {
+		return errSearchUnreachable
+	}
+	return u.MessageSearcher.IndexMessage(ctx, doc)
+}
+
+func (u *unreachableSearcher) DeleteMessage(ctx context.Context, doc search.MessageDocument) error {
+	if u.down.Load() {
+		return errSearchUnreachable
+	}
+	return u.MessageSearcher.DeleteMessage(ctx, doc)
+}
+
+func (u *unreachableSearcher) IndexChat(ctx context.Context, doc search.ChatDocument) error {
+	if u.down.Load() {
+		return errSearchUnreachable
+	}
+	return u.MessageSearcher.IndexChat(ctx, doc)
+}
+
+func (u *unreachableSearcher) DeleteChat(ctx context.Context, doc search.ChatDocument) error {
+	if u.down.Load() {
+		return errSearchUnreachable
+	}
+	return u.MessageSearcher.DeleteChat(ctx, doc)
+}
+
+func (u *unreachableSearcher) WriteBatch(ctx context.Context, batch search.Batch) error {
+	if u.down.Load() {
+		return errSearchUnreachable
+	}
+	return u.MessageSearcher.WriteBatch(ctx, batch)
+}
+
+func TestSearchConvergesThroughOutbox(t *testing.T) {
+	unreachable := &unreachableSearcher{}
+	s := newTestServerWithSearcher(t, fastRetries(), func(searcher search.MessageSearcher) search.MessageSearcher {
+		unreachable.MessageSearcher = searcher
+		return unreachable
+	})
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	first := s.createMessage(token, chatNumber, "hello")
+	deleted := s.createMessage(token, chatNumber, "hello to delete")
+	path := chatPath(token, chatNumber) + "/messages/search"
+	searchHello := func() []string {
+		t.Helper()
+		rec := s.do(http.MethodGet, path, map[string]string{"query": "hello", "sort": "oldest"})
+		expectStatus(t, rec, http.StatusOK)
+		bodies := []string{}
+		for _, hit := range decode[response[[]searchMessageResponse]](t, rec).Data {
+			bodies = append(bodies, hit.Body)
+		}
+		return bodies
+	}
+
+	// Writes succeed while the index is down, which keeps serving old results
+	unreachable.down.Store(true)
+	if number := s.createMessage(token, chatNumber, "hello again"); number != 3 {
+		t.Fatalf("expected message number 3, got %d", number)
+	}
+	rec := s.do(http.MethodPatch, messagePath(token, chatNumber, first), map[string]string{"newBody": "hello, edited"})
+	if status := waitForTask[MessageTaskStatus](s, statusPath(t, rec)); status.Status != models.TaskCompleted {
+		t.Fatalf("updating message failed: %s", status.Error)
+	}
+	expectStatus(t, s.do(http.MethodDelete, messagePath(token, chatNumber, deleted), nil), http.StatusNoContent)
+	if bodies := searchHello(); !slices.Equal(bodies, []string{"hello", "hello to delete"}) {
+		t.Fatalf("expected the index as before the outage, got %q", bodies)
+	}
+
+	unreachable.down.Store(false)
+	expected := []string{"hello, edited", "hello again"}
+	deadline := time.Now().Add(taskTimeout)
+	for bodies := searchHello(); !slices.Equal(bodies, expected); bodies = searchHello() {
+		if time.Now().After(deadline) {
+			t.Fatalf("expected %q once the index is back, got %q", expected, bodies)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+// recordingSearcher keeps the documents written to the search index.
+type recordingSearcher struct {
+	search.MessageSearcher
+	mu      sync.Mutex
+	indexed []search.MessageDocument
+}
+
+func (r *recordingSearcher) IndexMessage(ctx context.Context, doc search.MessageDocument) error {
+	r.mu.Lock()
+	r.indexed = append(r.indexed, doc)
+	r.mu.Unlock()
+	return r.MessageSearcher.IndexMessage(ctx, doc)
+}
+
+func TestSearchDocuments(t *testing.T) {
+	recorder := &recordingSearcher{}
+	s := newTestServerWithSearcher(t, config.Default(), func(searcher search.MessageSearcher) search.MessageSearcher {
+		recorder.MessageSearcher = searcher
+		return recorder
+	})
+	token := s.createApplication("app")
+	s.createChat(token, "first")
+	chatNumber := s.createChat(token, "second")
+	s.createMessage(token, chatNumber, "hello")
+	rec := s.do(http.MethodPatch, messagePath(token, chatNumber, 1), map[string]string{"newBody": "goodbye"})
+	waitForTask[MessageTaskStatus](s, statusPath(t, rec))
+	path := chatPath(token, chatNumber) + "/messages/search"
+
+	recorder.mu.Lock()
+	created, updated := recorder.indexed[0], recorder.indexed[1]
+	recorder.mu.Unlock()
+	if created.ChatNumber != chatNumber || created.Number != 1 || created.ApplicationId == 0 || created.CreatedAt.IsZero() || created.Version != 1 || updated.Version != 2 {
+		t.Fatalf("unexpected documents %+v and %+v", created, updated)
+	}
+
+	// The creation arriving late does not bring back the old body
+	if err := s.searcher.IndexMessage(context.Background(), created); err != nil {
+		t.Fatalf("indexing stale document: %v", err)
+	}
+	rec = s.do(http.MethodGet, path, map[string]string{"query": "goodbye"})
+	expectStatus(t, rec, http.StatusOK)
+	if hits := decode[response[[]searchMessageResponse]](t, rec).Data; len(hits) != 1 || hits[0].Number != 1 {
+		t.Fatalf("expected message 1 to be found, got %+v", hits)
+	}
+
+	// Nor does the update once the message is deleted
+	expectStatus(t, s.do(http.MethodDelete, messagePath(token, chatNumber, 1), nil), http.StatusNoContent)
+	if err := s.searcher.IndexMessage(context.Background(), updated); err != nil {
+		t.Fatalf("indexing stale document: %v", err)
+	}
+	rec = s.do(http.MethodGet, path, map[string]string{"query": "goodbye"})
+	expectStatus(t, rec, http.StatusOK)
+	if hits := decode[response[[]searchMessageResponse]](t, rec).Data; len(hits) != 0 {
+		t.Fatalf("expected the deleted message to stay out of the index, got %+v", hits)
+	}
+}
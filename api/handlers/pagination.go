@@ -0,0 +1,128 @@
+package handlers
+
+import (
+	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"strconv"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	defaultPageLimit = 50
+	maxPageLimit     = 100
+)
+
+// parsePageQuery reads the limit, after, before and order query parameters
+// of a list endpoint. Cursors are opaque to clients: they only pass back the
+// next_cursor or prev_cursor of a previous response.
+func parsePageQuery(c echo.Context) (models.PageQuery, error) {
+	page := models.PageQuery{Limit: defaultPageLimit}
+
+	if value := c.QueryParam("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 || limit > maxPageLimit {
+			return models.PageQuery{}, errors.New("limit must be between 1 and " + strconv.Itoa(maxPageLimit))
+		}
+		page.Limit = limit
+	}
+
+	switch c.QueryParam("order") {
+	case "", "asc":
+	case "desc":
+		page.Descending = true
+	default:
+		return models.PageQuery{}, errors.New("order must be asc or desc")
+	}
+
+	after, before := c.QueryParam("after"), c.QueryParam("before")
+	if after != "" && before != "" {
+		return models.PageQuery{}, errors.New("after and before cannot be combined")
+	}
+	var err error
+	if after != "" {
+		if page.After, err = decodeCursor(after); err != nil {
+			return models.PageQuery{}, errors.New("invalid after cursor")
+		}
+	}
+	if before != "" {
+		if page.Before, err = decodeCursor(before); err != nil {
+			return models.PageQuery{}, errors.New("invalid before cursor")
+		}
+	}
+
+	return page, nil
+}
+
+// pageCursors returns the cursors leading to the pages following and
+// preceding a non-empty page whose first and last rows have keys firstKey and
+// lastKey.
+func pageCursors(page models.PageQuery, firstKey int64, lastKey int64, hasMore bool) (next string, prev string) {
+	// Paging backwards, the row the before cursor points at follows this page
+	if (page.Before == 0 && hasMore) || page.Before != 0 {
+		next = encodeCursor(lastKey)
+	}
+	if (page.Before != 0 && hasMore) || page.After != 0 {
+		prev = encodeCursor(firstKey)
+	}
+	return next, prev
+}
+
+func encodeCursor(key int64) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(key, 10)))
+}
+
+func decodeCursor(cursor string) (int64, error) {
+	data, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return 0, err
+	}
+	key, err := strconv.ParseInt(string(data), 10, 64)
+	if err != nil || key < 1 {
+		return 0, errors.New("invalid cursor")
+	}
+	return key, nil
+}
+
+// parseSearchPage reads the offset and number of hits of the page asked for.
+func parseSearchPage(c echo.Context) (int, int, error) {
+	if c.QueryParam("order") != "" {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "search results are ordered by sort, not order")
+	}
+	page, err := parsePageQuery(c)
+	if err != nil {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+	from, size := searchWindow(page)
+	if from >= search.MaxResultWindow {
+		return 0, 0, echo.NewHTTPError(http.StatusBadRequest, "cannot page past the first "+strconv.Itoa(search.MaxResultWindow)+" hits")
+	}
+	return from, min(size, search.MaxResultWindow-from), nil
+}
+
+// searchWindow turns a page query into the offset and number of hits to
+// fetch. A before cursor selects the hits right before its offset.
+func searchWindow(page models.PageQuery) (int, int) {
+	if page.Before != 0 {
+		from := max(int(page.Before)-page.Limit, 0)
+		return from, int(page.Before) - from
+	}
+	return int(page.After), page.Limit
+}
+
+// searchCursors returns the cursors leading to the pages following and
+// preceding a page of search results, given its offset, its number of hits
+// and the number of hits over all pages. Cursors hold the offset of the hit
+// a page starts after or ends before.
+func searchCursors(from int, hits int, total int64) (next string, prev string) {
+	if end := from + hits; int64(end) < total && end < search.MaxResultWindow {
+		next = encodeCursor(int64(end))
+	}
+	if from > 0 {
+		prev = encodeCursor(int64(from))
+	}
+	return next, prev
+}
@@ -0,0 +1,350 @@
+package handlers
+
+import (
+	"chat-system/internal/config"
+	"chat-system/internal/database"
+	"chat-system/internal/jobs"
+	"chat-system/internal/models"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/google/uuid"
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	reindexQueue = "reindex"
+	reindexTask  = "reindex"
+	// Reindexes share one partition, so they run one at a time in the order
+	// they were requested
+	reindexPartition = "reindex"
+	// rebuildLock is held by the instance checking whether to queue a
+	// rebuild
+	rebuildLock = "rebuild-search-index"
+)
+
+// ReindexHandlers rebuild the search index from the messages and chats stored
+// in the database, for one chat, one application or everything.
+type ReindexHandlers struct {
+	MessagesDBHandler     MessageRepository
+	ChatsDBHandler        ChatRepository
+	ApplicationsDBHandler ApplicationRepository
+	Queue                 queue.Queue
+	TaskStatuses          TaskStatusStore
+	Searcher              search.MessageSearcher
+	config                config.Config
+	workers               *queue.Pool
+}
+
+type ReindexRequest struct {
+	TaskID string
+	Scope  models.IndexScope
+}
+
+// ReindexProgress counts the messages of a reindex: Total to go through, and
+// how many were indexed or, being deleted, dropped from the index so far.
+// Chats counts the chat subjects written once the messages are done.
+type ReindexProgress struct {
+	Total   int64
+	Indexed int64
+	Deleted int64
+	Chats   int64
+}
+
+type ReindexTaskStatus struct {
+	Status string // "pending", "running", "completed", "failed"
+	ReindexProgress
+	Error string
+}
+
+// batchWriter is the live index or one being rebuilt.
+type batchWriter interface {
+	WriteBatch(ctx context.Context, batch search.Batch) error
+}
+
+// CreateReindexHandlers reads cfg.Search.ReindexBatchSize messages at a time
+// on cfg.Search.ReindexWorkers workers. Reindexes share one partition, so
+// they still run one at a time whatever the number of workers.
+func CreateReindexHandlers(messages MessageRepository, chats ChatRepository, applications ApplicationRepository, taskQueue queue.Queue, taskStatuses TaskStatusStore, searcher search.MessageSearcher, cfg config.Config) *ReindexHandlers {
+	handler := &ReindexHandlers{
+		MessagesDBHandler:     messages,
+		ChatsDBHandler:        chats,
+		ApplicationsDBHandler: applications,
+		Queue:                 taskQueue,
+		TaskStatuses:          taskStatuses,
+		Searcher:              searcher,
+		config:                cfg,
+	}
+
+	handler.workers = queue.NewPool(handler.Queue, reindexQueue, cfg.Search.ReindexWorkers, taskRetryPolicy(cfg.Tasks), handler.processTask, handler.taskFailed)
+	handler.workers.Start()
+
+	return handler
+}
+
+// StopWorkers waits for the reindexes in progress to finish. Reindexes still
+// queued are kept in the durable queue for the next start.
+func (h *ReindexHandlers) StopWorkers(ctx context.Context) error {
+	err := h.workers.Stop(ctx)
+	logRemainingTasks(h.Queue, reindexQueue)
+	return err
+}
+
+func (h *ReindexHandlers) processTask(task *queue.Task) error {
+	if task.Kind != reindexTask {
+		return fmt.Errorf("unknown reindex task kind %q", task.Kind)
+	}
+	var request ReindexRequest
+	if err := json.Unmarshal(task.Payload, &request); err != nil {
+		return fmt.Errorf("failed to decode reindex task: %w", err)
+	}
+
+	markTaskRunning(h.TaskStatuses, task.ID)
+	progress, err := h.reindex(context.Background(), task.ID, request.Scope)
+	if err != nil {
+		return err
+	}
+	completeTask(h.TaskStatuses, task.ID, progress)
+	return nil
+}
+
+func (h *ReindexHandlers) taskFailed(task *queue.Task, err error, retrying bool) {
+	if retrying {
+		retryTask(h.TaskStatuses, task.ID, task.Attempts)
+		return
+	}
+	failTask(h.TaskStatuses, task.ID, "Failed to reindex messages")
+}
+
+// reindex writes the messages and chats of scope to the search index. A full
+// reindex fills a new index and swaps it in, so searches keep working
+// meanwhile, then catches up with the messages and chats changed during the
+// copy. Narrower reindexes update the live index in place.
+func (h *ReindexHandlers) reindex(ctx context.Context, taskID string, scope models.IndexScope) (ReindexProgress, error) {
+	total, err := h.MessagesDBHandler.CountMessagesForIndexing(scope)
+	if err != nil {
+		return ReindexProgress{}, err
+	}
+	progress := ReindexProgress{Total: total}
+	reportTaskProgress(h.TaskStatuses, taskID, progress)
+
+	if !scope.Full() {
+		if err := h.copyMessages(ctx, h.Searcher, scope, taskID, &progress); err != nil {
+			return progress, err
+		}
+		err := h.copyChats(ctx, h.Searcher, scope, taskID, &progress)
+		return progress, err
+	}
+
+	checkpoint, err := h.MessagesDBHandler.IndexCheckpoint()
+	if err != nil {
+		return progress, err
+	}
+	rebuild, err := h.Searcher.BeginRebuild(ctx)
+	if err != nil {
+		return progress, fmt.Errorf("%w: %w", database.ErrIndexMessage, err)
+	}
+	if err := h.copyMessages(ctx, rebuild, scope, taskID, &progress); err != nil {
+		abortRebuild(ctx, rebuild)
+		return progress, err
+	}
+	if err := h.copyChats(ctx, rebuild, scope, taskID, &progress); err != nil {
+		abortRebuild(ctx, rebuild)
+		return progress, err
+	}
+	if err := rebuild.Commit(ctx); err != nil {
+		abortRebuild(ctx, rebuild)
+		return progress, fmt.Errorf("%w: %w", database.ErrIndexMessage, err)
+	}
+
+	// Writes made during the copy went to the old index only
+	scope.UpdatedSince = checkpoint
+	caughtUp := ReindexProgress{}
+	if err := h.copyMessages(ctx, h.Searcher, scope, "", &caughtUp); err != nil {
+		return progress, err
+	}
+	if err := h.copyChats(ctx, h.Searcher, scope, "", &caughtUp); err != nil {
+		return progress, err
+	}
+	log.Printf("reindex %s caught up with %d messages and %d chats changed during the rebuild", taskID, caughtUp.Indexed+caughtUp.Deleted, caughtUp.Chats)
+	return progress, nil
+}
+
+// copyMessages writes the messages of scope to writer in batches, dropping
+// the deleted ones, and reports progress to the status of taskID if set.
+func (h *ReindexHandlers) copyMessages(ctx context.Context, writer batchWriter, scope models.IndexScope, taskID string, progress *ReindexProgress) error {
+	var afterId int64
+	for {
+		messages, err := h.MessagesDBHandler.GetMessagesForIndexing(scope, afterId, h.config.Search.ReindexBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(messages) == 0 {
+			return nil
+		}
+
+		batch := search.Batch{}
+		for _, message := range messages {
+			if !message.Searchable() {
+				batch.Delete = append(batch.Delete, database.MessageDocument(message))
+			} else {
+				batch.Index = append(batch.Index, database.MessageDocument(message))
+			}
+		}
+		if err := writer.WriteBatch(ctx, batch); err != nil {
+			return fmt.Errorf("%w: %w", database.ErrIndexMessage, err)
+		}
+
+		progress.Indexed += int64(len(batch.Index))
+		progress.Deleted += int64(len(batch.Delete))
+		if taskID != "" {
+			reportTaskProgress(h.TaskStatuses, taskID, progress)
+		}
+		afterId = messages[len(messages)-1].Id
+	}
+}
+
+// copyChats writes the subjects of the chats of scope to writer in batches,
+// dropping the deleted ones, and reports progress like copyMessages.
+func (h *ReindexHandlers) copyChats(ctx context.Context, writer batchWriter, scope models.IndexScope, taskID string, progress *ReindexProgress) error {
+	var afterId int64
+	for {
+		chats, err := h.ChatsDBHandler.GetChatsForIndexing(scope, afterId, h.config.Search.ReindexBatchSize)
+		if err != nil {
+			return err
+		}
+		if len(chats) == 0 {
+			return nil
+		}
+
+		batch := search.Batch{}
+		for _, chat := range chats {
+			if chat.DeletedAt != nil {
+				batch.DeleteChats = append(batch.DeleteChats, database.ChatDocument(chat))
+			} else {
+				batch.IndexChats = append(batch.IndexChats, database.ChatDocument(chat))
+			}
+		}
+		if err := writer.WriteBatch(ctx, batch); err != nil {
+			return fmt.Errorf("%w: %w", database.ErrIndexMessage, err)
+		}
+
+		progress.Chats += int64(len(chats))
+		if taskID != "" {
+			reportTaskProgress(h.TaskStatuses, taskID, progress)
+		}
+		afterId = chats[len(chats)-1].Id
+	}
+}
+
+func abortRebuild(ctx context.Context, rebuild search.Rebuild) {
+	if err := rebuild.Abort(ctx); err != nil {
+		log.Printf("error dropping aborted search index: %v", err)
+	}
+}
+
+func (h *ReindexHandlers) HandleReindexChat(c echo.Context) error {
+	token := c.Param("token")
+	chatNumber, err := parseInt64Param("chat_number", c)
+	if err != nil {
+		return echo.NewHTTPError(http.StatusBadRequest, err.Error())
+	}
+
+	chatId, err := lookupChatId(h.ApplicationsDBHandler, h.ChatsDBHandler, token, chatNumber)
+	if err != nil {
+		return err
+	}
+	return h.queueReindex(c, models.IndexScope{ChatId: chatId})
+}
+
+func (h *ReindexHandlers) HandleReindexApplication(c echo.Context) error {
+	applicationId, err := h.ApplicationsDBHandler.GetApplicationIdByToken(c.Param("token"))
+	if err != nil {
+		return err
+	}
+	return h.queueReindex(c, models.IndexScope{ApplicationId: applicationId})
+}
+
+// HandleReindexAll rebuilds the whole index. It is an admin route.
+func (h *ReindexHandlers) HandleReindexAll(c echo.Context) error {
+	return h.queueReindex(c, models.IndexScope{})
+}
+
+func (h *ReindexHandlers) queueReindex(c echo.Context, scope models.IndexScope) error {
+	taskID, err := h.QueueReindex(c.Request().Context(), scope)
+	if errors.Is(err, queue.ErrFull) {
+		return queueFull(c, h.config.Queue.RetryAfter)
+	}
+	if err != nil {
+		return err
+	}
+	return acceptTask(c, "/reindex/status/", taskID)
+}
+
+// QueueReindex queues a reindex of scope and returns the ID of its task.
+func (h *ReindexHandlers) QueueReindex(ctx context.Context, scope models.IndexScope) (string, error) {
+	taskID := uuid.New().String()
+	if err := h.TaskStatuses.InsertTaskStatus(taskID); err != nil {
+		return "", err
+	}
+
+	err := enqueueTask(ctx, h.Queue, reindexQueue, reindexTask, taskID, reindexPartition, ReindexRequest{
+		TaskID: taskID,
+		Scope:  scope,
+	})
+	if errors.Is(err, queue.ErrFull) {
+		failTask(h.TaskStatuses, taskID, "Queue is full")
+		return "", err
+	}
+	if err != nil {
+		failTask(h.TaskStatuses, taskID, "Failed to queue reindex")
+		return "", err
+	}
+	return taskID, nil
+}
+
+// QueueRebuild queues a full reindex unless a reindex is already queued or
+// running, and returns the ID of its task, or an empty ID when it queued
+// nothing. Instances starting together check the queue under the lock of
+// locker, so only one of them queues the rebuild.
+func (h *ReindexHandlers) QueueRebuild(ctx context.Context, locker jobs.Locker) (string, error) {
+	unlock, locked, err := locker.TryLock(ctx, rebuildLock)
+	if err != nil {
+		return "", err
+	}
+	if !locked {
+		return "", nil
+	}
+	defer unlock()
+
+	queued, err := h.Queue.Depth(ctx, reindexQueue)
+	if err != nil {
+		return "", err
+	}
+	if queued > 0 {
+		return "", nil
+	}
+	return h.QueueReindex(ctx, models.IndexScope{})
+}
+
+func (h *ReindexHandlers) HandleGetReindexStatus(c echo.Context) error {
+	taskStatus, err := h.TaskStatuses.GetTaskStatus(c.Param("taskID"))
+	if err != nil {
+		return err
+	}
+
+	status := ReindexTaskStatus{Status: taskStatus.Status, Error: taskStatus.Error}
+	if len(taskStatus.Result) > 0 {
+		if err := json.Unmarshal(taskStatus.Result, &status.ReindexProgress); err != nil {
+			return err
+		}
+	}
+
+	return c.JSON(http.StatusOK, status)
+}
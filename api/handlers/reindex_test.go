@@ -0,0 +1,122 @@
+package handlers
+
+import (
+	"chat-system/internal/database/memory"
+	"chat-system/internal/jobs"
+	"chat-system/internal/models"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"net/http"
+	"slices"
+	"testing"
+)
+
+func TestReindex(t *testing.T) {
+	s := newTestServer(t)
+	token := s.createApplication("app")
+	chatNumber := s.createChat(token, "chat")
+	otherChatNumber := s.createChat(token, "other")
+	otherToken := s.createApplication("other")
+	otherAppChatNumber := s.createChat(otherToken, "chat")
+	s.createMessage(token, chatNumber, "hello")
+	deleted := s.createMessage(token, chatNumber, "hello again")
+	s.createMessage(token, otherChatNumber, "hello there")
+	s.createMessage(otherToken, otherAppChatNumber, "hello elsewhere")
+	expectStatus(t, s.do(http.MethodDelete, messagePath(token, chatNumber, deleted), nil), http.StatusNoContent)
+
+	// Drop every chat from the index, as if it was lost
+	chatIds := []int64{}
+	for _, chat := range []struct {
+		token  string
+		number int64
+	}{{token, chatNumber}, {token, otherChatNumber}, {otherToken, otherAppChatNumber}} {
+		chatId, err := lookupChatId(s.store, s.store, chat.token, chat.number)
+		if err != nil {
+			t.Fatalf("looking up chat %d of %s: %v", chat.number, chat.token, err)
+		}
+		chatIds = append(chatIds, chatId)
+	}
+	if err := s.searcher.DeleteChats(context.Background(), chatIds); err != nil {
+		t.Fatalf("dropping chats from the index: %v", err)
+	}
+
+	expectHits := func(token string, chatNumber int64, expected ...string) {
+		t.Helper()
+		rec := s.do(http.MethodGet, chatPath(token, chatNumber)+"/messages/search", map[string]string{"query": "hello"})
+		expectStatus(t, rec, http.StatusOK)
+		bodies := []string{}
+		for _, hit := range decode[response[[]searchMessageResponse]](t, rec).Data {
+			bodies = append(bodies, hit.Body)
+		}
+		slices.Sort(bodies)
+		if !slices.Equal(bodies, expected) {
+			t.Fatalf("searching chat %d of %s: expected %q, got %q", chatNumber, token, expected, bodies)
+		}
+	}
+	expectChats := func(token string, expected ...string) {
+		t.Helper()
+		rec := s.do(http.MethodGet, "/applications/"+token+"/chats/search", map[string]string{"query": "chat"})
+		expectStatus(t, rec, http.StatusOK)
+		subjects := []string{}
+		for _, hit := range decode[response[[]searchChatResponse]](t, rec).Data {
+			subjects = append(subjects, hit.Subject)
+		}
+		if !slices.Equal(subjects, expected) {
+			t.Fatalf("searching the chats of %s: expected %q, got %q", token, expected, subjects)
+		}
+	}
+	reindex := func(path string, expected ReindexProgress) {
+		t.Helper()
+		status := waitForTask[ReindexTaskStatus](s, statusPath(t, s.do(http.MethodPost, path, nil)))
+		if status.Status != models.TaskCompleted || status.ReindexProgress != expected {
+			t.Fatalf("reindexing %s: expected %+v, got %+v", path, expected, status)
+		}
+	}
+
+	reindex(chatPath(token, chatNumber)+"/messages/index", ReindexProgress{Total: 2, Indexed: 1, Deleted: 1, Chats: 1})
+	expectHits(token, chatNumber, "hello")
+	expectHits(token, otherChatNumber)
+	expectChats(token, "chat")
+
+	reindex("/applications/"+token+"/messages/index", ReindexProgress{Total: 3, Indexed: 2, Deleted: 1, Chats: 2})
+	expectHits(token, otherChatNumber, "hello there")
+	expectHits(otherToken, otherAppChatNumber)
+	expectChats(otherToken)
+
+	reindex("/admin/reindex", ReindexProgress{Total: 4, Indexed: 3, Deleted: 1, Chats: 3})
+	expectHits(token, chatNumber, "hello")
+	expectHits(otherToken, otherAppChatNumber, "hello elsewhere")
+	expectChats(otherToken, "chat")
+
+	expectError(t, s.do(http.MethodPost, chatPath(token, 9)+"/messages/index", nil), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodPost, "/applications/unknown/messages/index", nil), http.StatusNotFound, "not_found")
+	expectError(t, s.do(http.MethodGet, "/reindex/status/unknown", nil), http.StatusNotFound, "not_found")
+}
+
+func TestQueueRebuildOnce(t *testing.T) {
+	// No workers run, so a queued rebuild stays queued
+	store := memory.NewStore(search.NewMemorySearcher())
+	taskQueue := queue.NewMemoryQueue()
+	h := &ReindexHandlers{Queue: taskQueue, TaskStatuses: store}
+	locker := jobs.NewMemoryLocker()
+	ctx := context.Background()
+
+	// Another instance is checking the queue
+	unlock, _, _ := locker.TryLock(ctx, rebuildLock)
+	if taskID, err := h.QueueRebuild(ctx, locker); err != nil || taskID != "" {
+		t.Fatalf("expected no rebuild while the lock is held, got %q, %v", taskID, err)
+	}
+	unlock()
+
+	taskID, err := h.QueueRebuild(ctx, locker)
+	if err != nil || taskID == "" {
+		t.Fatalf("expected a rebuild to be queued, got %q, %v", taskID, err)
+	}
+	if taskID, err := h.QueueRebuild(ctx, locker); err != nil || taskID != "" {
+		t.Fatalf("expected no second rebuild, got %q, %v", taskID, err)
+	}
+	if depth, _ := taskQueue.Depth(ctx, reindexQueue); depth != 1 {
+		t.Fatalf("expected one queued rebuild, got %d", depth)
+	}
+}
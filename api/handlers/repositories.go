@@ -0,0 +1,54 @@
+package handlers
+
+import (
+	"chat-system/internal/models"
+	"time"
+)
+
+// ApplicationRepository stores applications. Lookups by token ignore soft
+// deleted applications and report a database.NotFoundError when nothing
+// matches.
+type ApplicationRepository interface {
+	InsertApplication(name string, token string) error
+	GetApplicationByToken(token string) (models.Application, error)
+	GetAllApplications(page models.PageQuery) ([]models.Application, bool, error)
+	UpdateApplicationName(token string, name string) (models.Application, error)
+	GetApplicationIdByToken(token string) (int64, error)
+	DeleteApplication(token string) error
+	RestoreApplication(token string) (models.Application, error)
+}
+
+// ChatRepository stores the chats of applications, numbered from 1 within
+// each application.
+type ChatRepository interface {
+	InsertChat(appId int64, subject string) (int64, error)
+	GetChatByApplicationIdAndChatNumber(appId int64, chatNumber int64) (models.Chat, error)
+	GetAllChatsForAnApp(appId int64, page models.PageQuery) ([]models.Chat, bool, error)
+	UpdateChatSubject(appId int64, chatNumber int64, newSubject string) (models.Chat, error)
+	GetChatIdByAppIdAndChatNumber(appId int64, chatNumber int64) (int64, error)
+	// GetChatsForIndexing pages through the chats of a scope in id order,
+	// soft deleted ones included.
+	GetChatsForIndexing(scope models.IndexScope, afterId int64, limit int) ([]models.Chat, error)
+	DeleteChat(appId int64, chatNumber int64) error
+	RestoreChat(appId int64, chatNumber int64) (models.Chat, error)
+}
+
+// MessageRepository stores the messages of chats, numbered from 1 within each
+// chat. Writes add the message to the search outbox in their transaction, so
+// they succeed even when the search index cannot be reached.
+type MessageRepository interface {
+	InsertMessage(chatId int64, body string) (int64, error)
+	GetMessageByChatIdAndMessageNumber(chatId int64, messageNumber int64) (models.Message, error)
+	GetAllMessagesForAChat(chatId int64, page models.PageQuery) ([]models.Message, bool, error)
+	UpdateMessageBody(chatId int64, messageNumber int64, newBody string) (models.Message, error)
+	DeleteMessage(chatId int64, messageNumber int64) error
+	RestoreMessage(chatId int64, messageNumber int64) (models.Message, error)
+	IndexMessage(chatId int64, messageNumber int64) error
+	// GetMessagesForIndexing pages through the messages of a scope in id
+	// order, soft deleted ones included.
+	GetMessagesForIndexing(scope models.IndexScope, afterId int64, limit int) ([]models.IndexedMessage, error)
+	CountMessagesForIndexing(scope models.IndexScope) (int64, error)
+	// IndexCheckpoint returns the current time as seen by the store, which
+	// IndexScope.UpdatedSince is compared with.
+	IndexCheckpoint() (time.Time, error)
+}
@@ -1,8 +1,20 @@
 package handlers
 
+import (
+	"encoding/json"
+	"time"
+)
+
 //general
 type response[T any] struct {
 	Data T `json:"data"`
+	// Set on list endpoints when there is another page in that direction
+	NextCursor string `json:"next_cursor,omitempty"`
+	PrevCursor string `json:"prev_cursor,omitempty"`
+	// Set on searches to the number of hits over all pages
+	Total *int64 `json:"total,omitempty"`
+	// Set on application searches asking for facets
+	Facets *searchFacetsResponse `json:"facets,omitempty"`
 }
 
 //applications
@@ -23,19 +35,33 @@ type createChatRequest struct {
 }
 
 type createChatResponse struct {
-	ChatNumber int64 `json:"chatNumber" validate:"required"`
+	ChatNumber int64  `json:"chatNumber" validate:"required"`
+	Subject    string `json:"subject"`
 }
 
 type updateChatRequest struct {
 	NewSubject string `json:"newSubject" validate:"required"`
 }
 
+type searchChatRequest struct {
+	Query   string `json:"query" validate:"required"`
+	PreTag  string `json:"preTag" validate:"max=32"`
+	PostTag string `json:"postTag" validate:"max=32"`
+}
+type searchChatResponse struct {
+	Number    int64   `json:"number"`
+	Subject   string  `json:"subject"`
+	Score     float64 `json:"score"`
+	Highlight string  `json:"highlight"`
+}
+
 //messages
 type createMessageRequest struct {
 	Body string `json:"body" validate:"required"`
 }
 type createMessageResponse struct {
-	MessageNumber int64 `json:"messageNumber" validate:"required"`
+	MessageNumber int64  `json:"messageNumber" validate:"required"`
+	Body          string `json:"body"`
 }
 
 type updateMessageRequest struct {
@@ -43,5 +69,66 @@ type updateMessageRequest struct {
 }
 
 type searchMessageRequest struct {
-	Query string `json:"query" validate:"required"`
+	Query     string `json:"query" validate:"required"`
+	Mode      string `json:"mode" validate:"omitempty,oneof=match phrase prefix"`
+	Fuzziness string `json:"fuzziness" validate:"omitempty,oneof=AUTO 0 1 2,excluded_if=Mode phrase"`
+	Sort      string `json:"sort" validate:"omitempty,oneof=relevance newest oldest"`
+	PreTag    string `json:"preTag" validate:"max=32"`
+	PostTag   string `json:"postTag" validate:"max=32"`
+}
+type searchApplicationMessagesRequest struct {
+	searchMessageRequest
+	ChatNumbers []int64    `json:"chatNumbers" validate:"max=100"`
+	CreatedFrom *time.Time `json:"createdFrom"`
+	CreatedTo   *time.Time `json:"createdTo"`
+	Facets      bool       `json:"facets"`
+}
+type searchMessageResponse struct {
+	ChatNumber int64     `json:"chatNumber"`
+	Number     int64     `json:"number"`
+	Body       string    `json:"body"`
+	CreatedAt  time.Time `json:"createdAt"`
+	Score      float64   `json:"score"`
+	Highlight  string    `json:"highlight"`
+}
+type searchFacetsResponse struct {
+	Chats []chatHitsResponse `json:"chats"`
+}
+type chatHitsResponse struct {
+	ChatNumber int64 `json:"chatNumber"`
+	Hits       int64 `json:"hits"`
+}
+
+//admin
+type deadLetterResponse struct {
+	TaskID       string          `json:"taskId"`
+	Queue        string          `json:"queue"`
+	Kind         string          `json:"kind"`
+	PartitionKey string          `json:"partitionKey"`
+	Payload      json.RawMessage `json:"payload"`
+	Attempts     int             `json:"attempts"`
+	Error        string          `json:"error"`
+	CreatedAt    time.Time       `json:"createdAt"`
+	FailedAt     time.Time       `json:"failedAt"`
+}
+
+type queueDepthResponse struct {
+	Queue    string `json:"queue"`
+	Depth    int64  `json:"depth"`
+	Capacity int64  `json:"capacity"`
+}
+
+type jobResponse struct {
+	Name      string          `json:"name"`
+	Schedule  string          `json:"schedule"`
+	Enabled   bool            `json:"enabled"`
+	Running   bool            `json:"running"`
+	NextRunAt *time.Time      `json:"nextRunAt,omitempty"`
+	LastRun   *jobRunResponse `json:"lastRun,omitempty"`
+}
+
+type jobRunResponse struct {
+	StartedAt  time.Time `json:"startedAt"`
+	DurationMs int64     `json:"durationMs"`
+	Error      string    `json:"error,omitempty"`
 }
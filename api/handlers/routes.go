@@ -0,0 +1,117 @@
+package handlers
+
+import (
+	"crypto/subtle"
+	"log"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/labstack/echo/v4"
+	"github.com/labstack/echo/v4/middleware"
+)
+
+type CustomValidator struct {
+	validator *validator.Validate
+}
+
+func (cv *CustomValidator) Validate(i interface{}) error {
+	return cv.validator.Struct(i)
+}
+
+// NewValidator reports invalid fields by their JSON names.
+func NewValidator() *CustomValidator {
+	v := validator.New()
+	v.RegisterTagNameFunc(func(field reflect.StructField) string {
+		name := strings.SplitN(field.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		return name
+	})
+	return &CustomValidator{validator: v}
+}
+
+// adminAuth only lets through requests bearing adminKey, as in
+// "Authorization: Bearer <key>".
+func adminAuth(adminKey string) echo.MiddlewareFunc {
+	return middleware.KeyAuthWithConfig(middleware.KeyAuthConfig{
+		Validator: func(key string, c echo.Context) (bool, error) {
+			return subtle.ConstantTimeCompare([]byte(key), []byte(adminKey)) == 1, nil
+		},
+		// A missing key is refused the same way as a wrong one
+		ErrorHandler: func(err error, c echo.Context) error {
+			return echo.NewHTTPError(http.StatusUnauthorized, "A valid admin API key is required")
+		},
+	})
+}
+
+// NewServer returns an Echo instance with the error handling, middleware and
+// routes of the API. The /admin routes require adminKey, and are not mounted
+// at all when it is empty.
+func NewServer(appHandlers *ApplicationHandlers, chatHandlers *ChatHandlers, messageHandlers *MessageHandlers, reindexHandlers *ReindexHandlers, adminHandlers *AdminHandlers, adminKey string) *echo.Echo {
+	e := echo.New()
+	e.HTTPErrorHandler = HTTPErrorHandler
+	e.Use(middleware.RequestID())
+	e.Use(middleware.Logger())
+	e.Validator = NewValidator()
+
+	// Root route
+	e.GET("/", func(c echo.Context) error {
+		return c.String(http.StatusOK, "Hello, please accept me :D")
+	})
+
+	// Applications routes
+	e.POST("/applications", appHandlers.HandleCreateApplication)
+	e.GET("/applications", appHandlers.HandleGetAllApplications)
+	e.GET("/applications/:token", appHandlers.HandleGetApplicationByToken)
+	e.PATCH("/applications/:token", appHandlers.HandleUpdateApplicationName)
+	e.DELETE("/applications/:token", appHandlers.HandleDeleteApplication)
+	e.POST("/applications/:token/restore", appHandlers.HandleRestoreApplication)
+
+	// Chats routes
+	e.POST("/applications/:token/chats", chatHandlers.HandleCreateChat)
+	e.GET("/applications/:token/chats", chatHandlers.HandleGetAllChatsForApplication)
+	e.GET("/applications/:token/chats/search", chatHandlers.HandleSearchChats)
+	e.GET("/applications/:token/chats/:chat_number", chatHandlers.HandleGetChat)
+	e.PATCH("/applications/:token/chats/:chat_number", chatHandlers.HandleQueueUpdateChat)
+	e.DELETE("/applications/:token/chats/:chat_number", chatHandlers.HandleDeleteChat)
+	e.POST("/applications/:token/chats/:chat_number/restore", chatHandlers.HandleRestoreChat)
+
+	// Messages routes
+	e.POST("/applications/:token/chats/:chat_number/messages", messageHandlers.HandleCreateMessage)
+	e.GET("/applications/:token/chats/:chat_number/messages", messageHandlers.HandleGetAllMessagesForChat)
+	e.GET("/applications/:token/chats/:chat_number/messages/:message_number", messageHandlers.HandleGetMessage)
+	e.PATCH("/applications/:token/chats/:chat_number/messages/:message_number", messageHandlers.HandleUpdateMessageBody)
+	e.DELETE("/applications/:token/chats/:chat_number/messages/:message_number", messageHandlers.HandleDeleteMessage)
+	e.POST("/applications/:token/chats/:chat_number/messages/:message_number/restore", messageHandlers.HandleRestoreMessage)
+
+	//message queue status routes
+	e.GET("/chats/status/:taskID", chatHandlers.HandleGetStatus)
+	e.GET("/messages/status/:taskID", messageHandlers.HandleGetMessageStatus)
+	e.GET("/reindex/status/:taskID", reindexHandlers.HandleGetReindexStatus)
+
+	//elastic search messages
+	e.GET("/applications/:token/chats/:chat_number/messages/search", messageHandlers.HandleSearchMessages)
+	e.GET("/applications/:token/messages/search", messageHandlers.HandleSearchApplicationMessages)
+	e.POST("/applications/:token/chats/:chat_number/messages/index", reindexHandlers.HandleReindexChat)
+	e.POST("/applications/:token/messages/index", reindexHandlers.HandleReindexApplication)
+
+	// Admin routes
+	if adminKey == "" {
+		log.Println("ADMIN_API_KEY is not set, the /admin routes are disabled")
+		return e
+	}
+	admin := e.Group("/admin", adminAuth(adminKey))
+	admin.GET("/queues", adminHandlers.HandleGetQueueDepths)
+	admin.GET("/dead-letters", adminHandlers.HandleListDeadLetters)
+	admin.GET("/dead-letters/:taskID", adminHandlers.HandleGetDeadLetter)
+	admin.POST("/dead-letters/:taskID/replay", adminHandlers.HandleReplayDeadLetter)
+	admin.DELETE("/dead-letters/:taskID", adminHandlers.HandleDiscardDeadLetter)
+	admin.POST("/reindex", reindexHandlers.HandleReindexAll)
+	admin.GET("/jobs", adminHandlers.HandleGetJobs)
+	admin.POST("/jobs/:name/run", adminHandlers.HandleRunJob)
+
+	return e
+}
@@ -0,0 +1,236 @@
+package handlers
+
+import (
+	"bytes"
+	"chat-system/internal/config"
+	"chat-system/internal/database/memory"
+	"chat-system/internal/jobs"
+	"chat-system/internal/models"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	taskTimeout = 5 * time.Second
+	// testAdminKey is sent with every request to the /admin routes
+	testAdminKey = "test-admin-key"
+)
+
+// testServer runs the API against the in-memory store and queue, with the
+// chat and message workers running as they do in production.
+type testServer struct {
+	t        *testing.T
+	echo     *echo.Echo
+	searcher *search.MemorySearcher
+	store    *memory.Store
+	jobs     *jobs.Scheduler
+}
+
+func newTestServer(t *testing.T) *testServer {
+	return newTestServerWrapping(t, config.Default(), nil, nil)
+}
+
+// newTestServerWithChats lets wrapChats stand in front of the chats the
+// chat workers write to, to simulate failures.
+func newTestServerWithChats(t *testing.T, cfg config.Config, wrapChats func(ChatRepository) ChatRepository) *testServer {
+	return newTestServerWrapping(t, cfg, wrapChats, nil)
+}
+
+// newTestServerWithSearcher lets wrapSearcher stand in front of the search
+// index, to simulate failures.
+func newTestServerWithSearcher(t *testing.T, cfg config.Config, wrapSearcher func(search.MessageSearcher) search.MessageSearcher) *testServer {
+	return newTestServerWrapping(t, cfg, nil, wrapSearcher)
+}
+
+// fastRetries is the default configuration with failed tasks and outbox
+// batches retried every 10ms.
+func fastRetries() config.Config {
+	cfg := config.Default()
+	cfg.Tasks.RetryBaseDelay = 10 * time.Millisecond
+	cfg.Tasks.RetryMaxDelay = 10 * time.Millisecond
+	return cfg
+}
+
+func newTestServerWrapping(t *testing.T, cfg config.Config, wrapChats func(ChatRepository) ChatRepository, wrapSearcher func(search.MessageSearcher) search.MessageSearcher) *testServer {
+	t.Helper()
+	memorySearcher := search.NewMemorySearcher()
+	var searcher search.MessageSearcher = memorySearcher
+	if wrapSearcher != nil {
+		searcher = wrapSearcher(memorySearcher)
+	}
+	store := memory.NewStore(searcher)
+	memoryQueue := queue.NewMemoryQueue()
+	memoryQueue.ResetStatusesIn(store)
+	taskQueue := NewTaskQueue(memoryQueue, cfg.Queue)
+
+	var chats ChatRepository = store
+	if wrapChats != nil {
+		chats = wrapChats(store)
+	}
+	searchRelay := NewSearchRelay(store, searcher, cfg)
+	searchRelay.Start()
+	appHandlers := CreateApplicationHandlers(store)
+	chatHandlers := CreateChatHandlers(chats, store, taskQueue, store, store, searcher, cfg)
+	messageHandlers := CreateMessageHandlers(store, store, store, taskQueue, store, store, searcher, cfg)
+	reindexHandlers := CreateReindexHandlers(store, store, store, taskQueue, store, searcher, cfg)
+	scheduler := jobs.NewScheduler(jobs.NewMemoryLocker(), jobs.NewMemoryRunStore())
+	adminHandlers := CreateAdminHandlers(taskQueue, memoryQueue, store, scheduler)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), taskTimeout)
+		defer cancel()
+		chatHandlers.StopWorkers(ctx)
+		messageHandlers.StopWorkers(ctx)
+		reindexHandlers.StopWorkers(ctx)
+		searchRelay.Stop(ctx)
+		scheduler.Stop(ctx)
+	})
+
+	return &testServer{
+		t:        t,
+		echo:     NewServer(appHandlers, chatHandlers, messageHandlers, reindexHandlers, adminHandlers, testAdminKey),
+		searcher: memorySearcher,
+		store:    store,
+		jobs:     scheduler,
+	}
+}
+
+// do sends a request with an optional JSON body and header name/value pairs.
+// Requests to the /admin routes carry testAdminKey unless headers replace it.
+func (s *testServer) do(method string, path string, body interface{}, headers ...string) *httptest.ResponseRecorder {
+	s.t.Helper()
+	var reader *bytes.Reader
+	if body != nil {
+		data, err := json.Marshal(body)
+		if err != nil {
+			s.t.Fatalf("encoding request body: %v", err)
+		}
+		reader = bytes.NewReader(data)
+	} else {
+		reader = bytes.NewReader(nil)
+	}
+
+	req := httptest.NewRequest(method, path, reader)
+	if body != nil {
+		req.Header.Set(echo.HeaderContentType, echo.MIMEApplicationJSON)
+	}
+	if strings.HasPrefix(path, "/admin/") {
+		req.Header.Set(echo.HeaderAuthorization, "Bearer "+testAdminKey)
+	}
+	for i := 0; i+1 < len(headers); i += 2 {
+		req.Header.Set(headers[i], headers[i+1])
+	}
+	rec := httptest.NewRecorder()
+	s.echo.ServeHTTP(rec, req)
+	return rec
+}
+
+func decode[T any](t *testing.T, rec *httptest.ResponseRecorder) T {
+	t.Helper()
+	var value T
+	if err := json.Unmarshal(rec.Body.Bytes(), &value); err != nil {
+		t.Fatalf("decoding %q: %v", rec.Body.String(), err)
+	}
+	return value
+}
+
+func expectStatus(t *testing.T, rec *httptest.ResponseRecorder, status int) {
+	t.Helper()
+	if rec.Code != status {
+		t.Fatalf("expected status %d, got %d: %s", status, rec.Code, rec.Body.String())
+	}
+}
+
+// expectError checks the status and the code of an error envelope.
+func expectError(t *testing.T, rec *httptest.ResponseRecorder, status int, code string) apiError {
+	t.Helper()
+	expectStatus(t, rec, status)
+	body := decode[errorResponse](t, rec).Error
+	if body.Code != code {
+		t.Fatalf("expected error code %q, got %q", code, body.Code)
+	}
+	if body.RequestID == "" || body.RequestID != rec.Header().Get(echo.HeaderXRequestID) {
+		t.Fatalf("expected request ID %q in error body, got %q", rec.Header().Get(echo.HeaderXRequestID), body.RequestID)
+	}
+	return body
+}
+
+// statusPath extracts the path of the status URL returned for a queued write.
+func statusPath(t *testing.T, rec *httptest.ResponseRecorder) string {
+	t.Helper()
+	expectStatus(t, rec, http.StatusAccepted)
+	statusURL, err := url.Parse(decode[map[string]string](t, rec)["status_url"])
+	if err != nil {
+		t.Fatalf("parsing status URL: %v", err)
+	}
+	return statusURL.Path
+}
+
+// waitForTask polls a status path until the task completes or fails.
+func waitForTask[T any](s *testServer, path string) T {
+	s.t.Helper()
+	deadline := time.Now().Add(taskTimeout)
+	for {
+		rec := s.do(http.MethodGet, path, nil)
+		expectStatus(s.t, rec, http.StatusOK)
+		var status struct{ Status string }
+		if err := json.Unmarshal(rec.Body.Bytes(), &status); err != nil {
+			s.t.Fatalf("decoding task status: %v", err)
+		}
+		if status.Status == models.TaskCompleted || status.Status == models.TaskFailed {
+			return decode[T](s.t, rec)
+		}
+		if time.Now().After(deadline) {
+			s.t.Fatalf("task %s still %s after %s", path, status.Status, taskTimeout)
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
+
+func (s *testServer) createApplication(name string) string {
+	s.t.Helper()
+	rec := s.do(http.MethodPost, "/applications", map[string]string{"name": name})
+	expectStatus(s.t, rec, http.StatusOK)
+	return decode[response[createApplicationResponse]](s.t, rec).Data.Token
+}
+
+func (s *testServer) createChat(token string, subject string) int64 {
+	s.t.Helper()
+	rec := s.do(http.MethodPost, "/applications/"+token+"/chats", map[string]string{"subject": subject})
+	status := waitForTask[ChatTaskStatus](s, statusPath(s.t, rec))
+	if status.Status != models.TaskCompleted {
+		s.t.Fatalf("creating chat failed: %s", status.Error)
+	}
+	return status.Number
+}
+
+func (s *testServer) createMessage(token string, chatNumber int64, body string) int64 {
+	s.t.Helper()
+	rec := s.do(http.MethodPost, chatPath(token, chatNumber)+"/messages", map[string]string{"body": body})
+	status := waitForTask[MessageTaskStatus](s, statusPath(s.t, rec))
+	if status.Status != models.TaskCompleted {
+		s.t.Fatalf("creating message failed: %s", status.Error)
+	}
+	return status.Number
+}
+
+func TestRootRoute(t *testing.T) {
+	s := newTestServer(t)
+
+	expectStatus(t, s.do(http.MethodGet, "/", nil), http.StatusOK)
+}
+
+func TestUnknownRouteUsesErrorEnvelope(t *testing.T) {
+	s := newTestServer(t)
+
+	expectError(t, s.do(http.MethodGet, "/nowhere", nil), http.StatusNotFound, "not_found")
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"chat-system/internal/models"
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/labstack/echo/v4"
+)
+
+const (
+	// preferSync is the Prefer header preference asking a create to wait
+	// for its task, as opposed to the standard respond-async
+	preferSync  = "respond-sync"
+	maxSyncWait = 10 * time.Second
+	// The reads of the status of an awaited task start syncPollInterval
+	// apart, which doubles after each read up to syncMaxPollInterval, so
+	// fast tasks answer quickly and slow ones cost few reads
+	syncPollInterval    = 10 * time.Millisecond
+	syncMaxPollInterval = 250 * time.Millisecond
+)
+
+// parseWait reads how long a create may wait for its task before answering:
+// the duration of the wait query parameter, such as "5s", or syncWait when the
+// Prefer header holds respond-sync, in which case a wait=<seconds> preference
+// overrides it as in RFC 7240. Zero means answering right away.
+func parseWait(c echo.Context, syncWait time.Duration) (time.Duration, error) {
+	if value := c.QueryParam("wait"); value != "" {
+		wait, err := time.ParseDuration(value)
+		if err != nil || wait < 0 || wait > maxSyncWait {
+			return 0, echo.NewHTTPError(http.StatusBadRequest, "wait must be a duration between 0s and "+maxSyncWait.String())
+		}
+		return wait, nil
+	}
+
+	sync := false
+	wait := syncWait
+	for _, header := range c.Request().Header.Values("Prefer") {
+		for _, preference := range strings.Split(header, ",") {
+			name, value, _ := strings.Cut(strings.TrimSpace(preference), "=")
+			switch strings.ToLower(name) {
+			case preferSync:
+				sync = true
+			case "wait":
+				seconds, err := strconv.Atoi(value)
+				if err != nil || seconds < 0 {
+					return 0, echo.NewHTTPError(http.StatusBadRequest, "the wait preference must be a number of seconds")
+				}
+				wait = time.Duration(seconds) * time.Second
+			}
+		}
+	}
+	if !sync {
+		return 0, nil
+	}
+	return min(wait, maxSyncWait), nil
+}
+
+// awaitTask polls the status of a task until it completes or fails, for up
+// to wait, backing off between reads. Statuses are read from the store so
+// that the task may run on any instance. It returns the last status read, and
+// whether the task finished.
+func awaitTask(ctx context.Context, store TaskStatusStore, taskID string, wait time.Duration) (models.TaskStatus, bool, error) {
+	ctx, cancel := context.WithTimeout(ctx, wait)
+	defer cancel()
+
+	interval := syncPollInterval
+	timer := time.NewTimer(interval)
+	defer timer.Stop()
+	for {
+		status, err := store.GetTaskStatus(taskID)
+		if err != nil {
+			return models.TaskStatus{}, false, err
+		}
+		if status.Status == models.TaskCompleted || status.Status == models.TaskFailed {
+			return status, true, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+				return status, false, nil
+			}
+			return status, false, ctx.Err()
+		case <-timer.C:
+		}
+		interval = min(interval*2, syncMaxPollInterval)
+		timer.Reset(interval)
+	}
+}
+
+// respondCreated answers a create that was waited for with what it created,
+// and the URL of the new resource in the Location header.
+func respondCreated(c echo.Context, path string, created interface{}) error {
+	c.Response().Header().Set(echo.HeaderLocation, absoluteURL(c, path))
+	return c.JSON(http.StatusCreated, created)
+}
+
+// absoluteURL turns a path of this API into a URL, as seen by the client.
+func absoluteURL(c echo.Context, path string) string {
+	return c.Scheme() + "://" + c.Request().Host + path
+}
+
+func chatPath(token string, chatNumber int64) string {
+	return "/applications/" + token + "/chats/" + strconv.FormatInt(chatNumber, 10)
+}
+
+func messagePath(token string, chatNumber int64, messageNumber int64) string {
+	return chatPath(token, chatNumber) + "/messages/" + strconv.FormatInt(messageNumber, 10)
+}
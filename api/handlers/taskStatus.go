@@ -0,0 +1,73 @@
+package handlers
+
+import (
+	"chat-system/internal/database"
+	"chat-system/internal/models"
+	"encoding/json"
+	"fmt"
+	"log"
+)
+
+// TaskStatusStore persists the progress of queued writes so that the status
+// URL handed to the client can be answered by any instance, even after a
+// restart. Statuses expire after a retention window and are swept by cron.
+type TaskStatusStore interface {
+	InsertTaskStatus(taskId string) error
+	SetTaskStatus(taskId string, status string, result []byte, errorMessage string, errorKind string) error
+	GetTaskStatus(taskId string) (models.TaskStatus, error)
+	DeleteExpiredTaskStatuses() (int64, error)
+}
+
+func markTaskRunning(store TaskStatusStore, taskID string) {
+	if err := store.SetTaskStatus(taskID, models.TaskRunning, nil, "", ""); err != nil {
+		log.Printf("error marking task %s as running: %v", taskID, err)
+	}
+}
+
+// reportTaskProgress records the progress of a running task as its result.
+func reportTaskProgress(store TaskStatusStore, taskID string, progress interface{}) {
+	data, err := json.Marshal(progress)
+	if err != nil {
+		log.Printf("error encoding progress of task %s: %v", taskID, err)
+		return
+	}
+	if err := store.SetTaskStatus(taskID, models.TaskRunning, data, "", ""); err != nil {
+		log.Printf("error reporting progress of task %s: %v", taskID, err)
+	}
+}
+
+func completeTask(store TaskStatusStore, taskID string, result interface{}) {
+	data, err := json.Marshal(result)
+	if err != nil {
+		log.Printf("error encoding result of task %s: %v", taskID, err)
+		data = nil
+	}
+	if err := store.SetTaskStatus(taskID, models.TaskCompleted, data, "", ""); err != nil {
+		log.Printf("error marking task %s as completed: %v", taskID, err)
+	}
+}
+
+func retryTask(store TaskStatusStore, taskID string, attempt int) {
+	errorMessage := fmt.Sprintf("Attempt %d failed, retrying", attempt)
+	if err := store.SetTaskStatus(taskID, models.TaskPending, nil, errorMessage, ""); err != nil {
+		log.Printf("error marking task %s for retry: %v", taskID, err)
+	}
+}
+
+func failTask(store TaskStatusStore, taskID string, errorMessage string) {
+	failTaskWith(store, taskID, errorMessage, nil)
+}
+
+// failTaskWith marks a task as failed because of cause. A domain error, such
+// as a missing application, is recorded with its kind and message so that a
+// create waiting for the task answers as the request would have; any other
+// cause is recorded as errorMessage.
+func failTaskWith(store TaskStatusStore, taskID string, errorMessage string, cause error) {
+	kind, domainErr := database.ErrorKind(cause)
+	if kind != "" {
+		errorMessage = domainErr.Error()
+	}
+	if err := store.SetTaskStatus(taskID, models.TaskFailed, nil, errorMessage, kind); err != nil {
+		log.Printf("error marking task %s as failed: %v", taskID, err)
+	}
+}
@@ -3,82 +3,146 @@ package main
 import (
 	"chat-system/api/cron"
 	"chat-system/api/handlers"
+	"chat-system/internal/config"
 	"chat-system/internal/database"
+	"chat-system/internal/jobs"
+	"chat-system/internal/queue"
+	"chat-system/internal/search"
+	"context"
+	"errors"
+	"log"
 	"net/http"
 	"os"
+	"os/signal"
+	"strconv"
+	"syscall"
+	"time"
 
-	"github.com/go-playground/validator/v10"
 	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
 )
 
-func placeHolderHandler(c echo.Context) error {
-	return c.String(http.StatusOK, "Not yet implemented")
-}
+func main() {
+	// Every setting is checked before anything starts
+	cfg, err := config.Load()
+	if err != nil {
+		log.Fatalf("Invalid configuration:\n%v\n", err)
+	}
+	log.Printf("Configuration:\n%s", cfg)
 
-type CustomValidator struct {
-	validator *validator.Validate
-}
+	// Database setup
+	if err := database.InitDB(cfg.Database); err != nil {
+		log.Fatalf("Failed to connect to database: %v\n", err)
+	}
+	searcher, outdated := newSearcher(cfg.Search)
+
+	taskQueue := handlers.NewTaskQueue(queue.NewMySQLQueue(), cfg.Queue)
+	applications := database.NewApplicationsDatabaseHandler(searcher)
+	chats := database.NewChatsDatabaseHandler(searcher)
+	messages := database.NewMessagesDatabaseHandler(searcher)
+	taskStatuses := database.NewTaskStatusesDatabaseHandler(cfg.Tasks.StatusRetention)
+	idempotencyKeys := database.NewIdempotencyKeysDatabaseHandler(cfg.Tasks.IdempotencyKeyTTL)
+
+	searchRelay := handlers.NewSearchRelay(database.NewSearchOutboxDatabaseHandler(), searcher, cfg)
+	searchRelay.Start()
+
+	appHandlers := handlers.CreateApplicationHandlers(applications)
+	chatHandlers := handlers.CreateChatHandlers(chats, applications, taskQueue, taskStatuses, idempotencyKeys, searcher, cfg)
+	messageHandlers := handlers.CreateMessageHandlers(messages, chats, applications, taskQueue, taskStatuses, idempotencyKeys, searcher, cfg)
+	reindexHandlers := handlers.CreateReindexHandlers(messages, chats, applications, taskQueue, taskStatuses, searcher, cfg)
+	// Each job runs on one instance at a time, under a MySQL advisory lock
+	locker := jobs.NewMySQLLocker()
+	if outdated {
+		// Migrate the documents to the current schema behind the alias, once
+		// whatever the number of instances starting
+		taskID, err := reindexHandlers.QueueRebuild(context.Background(), locker)
+		switch {
+		case err != nil:
+			log.Printf("error queuing rebuild of outdated search index: %v", err)
+		case taskID == "":
+			log.Printf("search index has an outdated document schema, a reindex is already queued")
+		default:
+			log.Printf("search index has an outdated document schema, rebuilding it in task %s", taskID)
+		}
+	}
 
-func (cv *CustomValidator) Validate(i interface{}) error {
-	return cv.validator.Struct(i)
-}
+	jobRuns := jobs.NewMySQLRunStore()
+	scheduler := jobs.NewScheduler(locker, jobRuns)
+	for _, job := range cron.NewCronJob(searcher, reindexHandlers, jobRuns, cfg).Jobs() {
+		if err := scheduler.Register(job); err != nil {
+			log.Fatalf("Failed to register job: %v\n", err)
+		}
+	}
+	scheduler.Start()
 
-func main() {
-	// Database setup
-	database.InitDB()
-	database.ESClientConnection()
-	database.ESCreateIndexIfNotExist()
+	adminHandlers := handlers.CreateAdminHandlers(taskQueue, queue.NewMySQLQueue(), taskStatuses, scheduler)
+
+	e := handlers.NewServer(appHandlers, chatHandlers, messageHandlers, reindexHandlers, adminHandlers, cfg.Server.AdminAPIKey)
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
 
-	// Start the cron job in a Goroutine
 	go func() {
-		cronJob := cron.NewCronJob()
-		cronJob.Start()
+		if err := e.Start(":" + strconv.Itoa(cfg.Server.Port)); err != nil && !errors.Is(err, http.ErrServerClosed) {
+			e.Logger.Fatal(err)
+		}
 	}()
 
-	e := echo.New()
-	e.Use(middleware.Logger())
-	v := validator.New()
-	e.Validator = &CustomValidator{validator: v}
-
-	appHandlers := handlers.CreateApplicationHandlers()
-	chatHandlers := handlers.CreateChatHandlers()
-	messageHandlers := handlers.CreateMessageHandlers()
-
-	// Root route
-	e.GET("/", func(c echo.Context) error {
-		return c.String(http.StatusOK, "Hello, please accept me :D")
-	})
-
-	// Applications routes
-	e.POST("/applications", appHandlers.HandleCreateApplication)
-	e.GET("/applications", appHandlers.HandleGetAllApplications)
-	e.GET("/applications/:token", appHandlers.HandleGetApplicationByToken)
-	e.PATCH("/applications/:token", appHandlers.HandleUpdateApplicationName)
-
-	// Chats routes
-	e.POST("/applications/:token/chats", chatHandlers.HandleCreateChat)
-	e.GET("/applications/:token/chats", chatHandlers.HandleGetAllChatsForApplication)
-	e.GET("/applications/:token/chats/:chat_number", chatHandlers.HandleGetChat)
-	e.PATCH("/applications/:token/chats/:chat_number", chatHandlers.HandleQueueUpdateChat)
-
-	// Messages routes
-	e.POST("/applications/:token/chats/:chat_number/messages", messageHandlers.HandleCreateMessage)
-	e.GET("/applications/:token/chats/:chat_number/messages", messageHandlers.HandleGetAllMessagesForChat)
-	e.GET("/applications/:token/chats/:chat_number/messages/:message_number", messageHandlers.HandleGetMessage)
-	e.PATCH("/applications/:token/chats/:chat_number/messages/:message_number", messageHandlers.HandleUpdateMessageBody)
-
-	//message queue status routes
-	e.GET("/chats/status/:taskID", chatHandlers.HandleGetStatus)
-	e.GET("/messages/status/:taskID", messageHandlers.HandleGetMessageStatus)
-
-	//elastic search messages
-	e.GET("/applications/:token/chats/:chat_number/messages/search", messageHandlers.HandleSearchMessages)
-	e.POST("/applications/:token/chats/:chat_number/messages/index", placeHolderHandler)
-	port := os.Getenv("APP_PORT")
-	if port == "" {
-		port = "8080"
+	<-ctx.Done()
+	shutdown(e, cfg.Server.ShutdownTimeout, scheduler, searchRelay, chatHandlers, messageHandlers, reindexHandlers)
+}
+
+// newSearcher picks the search backend of cfg: "elasticsearch" or "memory", an
+// in-process index that starts empty and is meant for development without a
+// cluster. It also tells whether the index holds documents of an older schema.
+func newSearcher(cfg config.Search) (search.MessageSearcher, bool) {
+	if cfg.Backend == "memory" {
+		return search.NewMemorySearcher(), false
+	}
+	database.ESClientConnection(cfg)
+	if err := database.ESCreateIndexIfNotExist(); err != nil {
+		log.Printf("error creating search index: %v", err)
+	}
+	if err := database.ESCreateChatsIndexIfNotExist(); err != nil {
+		log.Printf("error creating chats index: %v", err)
+	}
+	outdated, err := database.ESIndexSchemaOutdated()
+	if err != nil {
+		log.Printf("error checking search index schema: %v", err)
 	}
+	return search.NewElasticsearchSearcher(database.ESClient), outdated
+}
 
-	e.Logger.Fatal(e.Start(":" + port))
+// shutdown stops accepting requests, lets running jobs and in-flight
+// writes finish within timeout, then closes MySQL and Elasticsearch. Writes
+// that did not get a worker stay in the durable queue, and messages not yet
+// published to the index in the search outbox.
+func shutdown(e *echo.Echo, timeout time.Duration, scheduler *jobs.Scheduler, searchRelay *search.Relay, chatHandlers *handlers.ChatHandlers, messageHandlers *handlers.MessageHandlers, reindexHandlers *handlers.ReindexHandlers) {
+	log.Printf("Shutting down, waiting up to %s...", timeout)
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := e.Shutdown(ctx); err != nil {
+		log.Printf("error stopping HTTP server: %v", err)
+	}
+	if err := scheduler.Stop(ctx); err != nil {
+		log.Printf("error stopping job scheduler: %v", err)
+	}
+	if err := chatHandlers.StopWorkers(ctx); err != nil {
+		log.Printf("error stopping chat workers: %v", err)
+	}
+	if err := messageHandlers.StopWorkers(ctx); err != nil {
+		log.Printf("error stopping message workers: %v", err)
+	}
+	if err := reindexHandlers.StopWorkers(ctx); err != nil {
+		log.Printf("error stopping reindex workers: %v", err)
+	}
+	if err := searchRelay.Stop(ctx); err != nil {
+		log.Printf("error stopping search outbox relay: %v", err)
+	}
+
+	if err := database.CloseDB(); err != nil {
+		log.Printf("error closing database: %v", err)
+	}
+	database.ESClose()
+	log.Println("Shutdown complete.")
 }
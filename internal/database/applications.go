@@ -2,17 +2,21 @@ package database
 
 import (
 	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"context"
 	"fmt"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type ApplicationsDatabaseHandler struct {
 	database *sqlx.DB
+	searcher search.MessageSearcher
 }
 
-func NewApplicationsDatabaseHandler() *ApplicationsDatabaseHandler {
-	return &ApplicationsDatabaseHandler{database: DATABASE}
+func NewApplicationsDatabaseHandler(searcher search.MessageSearcher) *ApplicationsDatabaseHandler {
+	return &ApplicationsDatabaseHandler{database: DATABASE, searcher: searcher}
 }
 
 func (r *ApplicationsDatabaseHandler) InsertApplication(name string, token string) error {
@@ -23,7 +27,7 @@ func (r *ApplicationsDatabaseHandler) InsertApplication(name string, token strin
 
 	_, err := r.database.Exec(query, name, token)
 	if err != nil {
-		return fmt.Errorf("failed to insert application: %w", err)
+		return fmt.Errorf("failed to insert application: %w", domainError("application", err))
 	}
 
 	return nil
@@ -31,22 +35,26 @@ func (r *ApplicationsDatabaseHandler) InsertApplication(name string, token strin
 
 func (r *ApplicationsDatabaseHandler) GetApplicationByToken(token string) (models.Application, error) {
 	app := models.Application{}
-	query := "SELECT * FROM Applications WHERE token = ?"
+	query := "SELECT * FROM Applications WHERE token = ? AND deleted_at IS NULL"
 	err := r.database.Get(&app, query, token)
 	if err != nil {
-		return models.Application{}, fmt.Errorf("failed to get applications: %w", err)
+		return models.Application{}, fmt.Errorf("failed to get application: %w", domainError("application", err))
 	}
 	return app, nil
 }
 
-func (r *ApplicationsDatabaseHandler) GetAllApplications() ([]models.Application, error) {
+// GetAllApplications returns one page of applications keyed on id, and
+// whether more applications follow in the direction of the page.
+func (r *ApplicationsDatabaseHandler) GetAllApplications(page models.PageQuery) ([]models.Application, bool, error) {
 	allApplications := []models.Application{}
-	query := "SELECT * FROM Applications"
-	err := r.database.Select(&allApplications, query)
+	condition, orderLimit, args := pageClause("id", page)
+	query := "SELECT * FROM Applications WHERE deleted_at IS NULL AND " + condition + " " + orderLimit
+	err := r.database.Select(&allApplications, query, args...)
 	if err != nil {
-		return []models.Application{}, fmt.Errorf("failed to get applications: %w", err)
+		return []models.Application{}, false, fmt.Errorf("failed to get applications: %w", err)
 	}
-	return allApplications, nil
+	allApplications, hasMore := trimPage(allApplications, page)
+	return allApplications, hasMore, nil
 }
 
 func (r *ApplicationsDatabaseHandler) UpdateApplicationName(token string, name string) (models.Application, error) {
@@ -54,24 +62,27 @@ func (r *ApplicationsDatabaseHandler) UpdateApplicationName(token string, name s
 	query := `
         UPDATE Applications
         SET name = ?
-        WHERE token = ?
+        WHERE token = ? AND deleted_at IS NULL
     `
-	tx := r.database.MustBegin()
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return models.Application{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
 	defer tx.Rollback()
 
-	_, err := tx.Exec(query, name, token)
+	_, err = tx.Exec(query, name, token)
 	if err != nil {
-		return models.Application{}, fmt.Errorf("failed to update application name: %w", err)
+		return models.Application{}, fmt.Errorf("failed to update application name: %w", domainError("application", err))
 	}
 
 	fetchQuery := `
         SELECT *
         FROM Applications
-        WHERE token = ?
+        WHERE token = ? AND deleted_at IS NULL
     `
 	err = tx.Get(&updatedApplication, fetchQuery, token)
 	if err != nil {
-		return models.Application{}, fmt.Errorf("failed to fetch updated application: %w", err)
+		return models.Application{}, fmt.Errorf("failed to fetch updated application: %w", domainError("application", err))
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -83,26 +94,131 @@ func (r *ApplicationsDatabaseHandler) UpdateApplicationName(token string, name s
 
 func (r *ApplicationsDatabaseHandler) GetApplicationIdByToken(token string) (int64, error) {
 	var id int64
-	query := "SELECT id FROM Applications WHERE token = ?"
+	query := "SELECT id FROM Applications WHERE token = ? AND deleted_at IS NULL"
 	err := r.database.Get(&id, query, token)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get application id: %w", err)
+		return 0, fmt.Errorf("failed to get application id: %w", domainError("application", err))
 	}
 	return id, nil
 }
 
-func (r *ApplicationsDatabaseHandler) UpdateChatsCount() error {
-	query := `
-		UPDATE Applications a
-		SET chats_count = (
-			SELECT COUNT(*)
-			FROM Chats c
-			WHERE c.application_id = a.id
-		)
-	`
-	_, err := r.database.Exec(query)
+// DeleteApplication soft deletes an application, which hides its chats and
+// messages along with it until it is restored or purged. It returns a
+// NotFoundError when the application does not exist.
+func (r *ApplicationsDatabaseHandler) DeleteApplication(token string) error {
+	query := "UPDATE Applications SET deleted_at = NOW() WHERE token = ? AND deleted_at IS NULL"
+	result, err := r.database.Exec(query, token)
 	if err != nil {
-		return fmt.Errorf("failed to update chats_count: %w", err)
+		return fmt.Errorf("failed to delete application: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count deleted applications: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("failed to delete application: %w", &NotFoundError{Resource: "application"})
 	}
 	return nil
 }
+
+// RestoreApplication brings back a soft deleted application. It returns a
+// NotFoundError when there is no deleted application with that token.
+func (r *ApplicationsDatabaseHandler) RestoreApplication(token string) (models.Application, error) {
+	restoredApplication := models.Application{}
+
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return models.Application{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	result, err := tx.Exec("UPDATE Applications SET deleted_at = NULL WHERE token = ? AND deleted_at IS NOT NULL", token)
+	if err != nil {
+		return models.Application{}, fmt.Errorf("failed to restore application: %w", err)
+	}
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return models.Application{}, fmt.Errorf("failed to count restored applications: %w", err)
+	}
+	if restored == 0 {
+		return models.Application{}, fmt.Errorf("failed to restore application: %w", &NotFoundError{Resource: "deleted application"})
+	}
+
+	err = tx.Get(&restoredApplication, "SELECT * FROM Applications WHERE token = ?", token)
+	if err != nil {
+		return models.Application{}, fmt.Errorf("failed to fetch restored application: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Application{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return restoredApplication, nil
+}
+
+// PurgeDeletedApplications permanently removes applications deleted more
+// than retention ago, with their chats and messages, and returns how many
+// applications were purged.
+func (r *ApplicationsDatabaseHandler) PurgeDeletedApplications(retention time.Duration) (int64, error) {
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	appIds := []int64{}
+	err = tx.Select(&appIds, "SELECT id FROM Applications WHERE deleted_at < NOW() - INTERVAL ? SECOND FOR UPDATE", int64(retention.Seconds()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get deleted applications: %w", err)
+	}
+	if len(appIds) == 0 {
+		return 0, nil
+	}
+
+	query, args, err := sqlx.In("SELECT id FROM Chats WHERE application_id IN (?)", appIds)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build chats query: %w", err)
+	}
+	chatIds := []int64{}
+	err = tx.Select(&chatIds, query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to get chats: %w", err)
+	}
+
+	// Chats and messages are removed by ON DELETE CASCADE
+	query, args, err = sqlx.In("DELETE FROM Applications WHERE id IN (?)", appIds)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build delete query: %w", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge applications: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	//elastic
+	err = r.searcher.DeleteChats(context.Background(), chatIds)
+	if err != nil {
+		return int64(len(appIds)), fmt.Errorf("%w: %w", ErrIndexMessage, err)
+	}
+
+	return int64(len(appIds)), nil
+}
+
+// ReconcileChatsCount recounts the chats of the applications changed since
+// since, or whose chats were, fixes the chats_count found to drift and
+// returns the drifts.
+func (r *ApplicationsDatabaseHandler) ReconcileChatsCount(since time.Time) ([]CountDrift, error) {
+	query := `
+		SELECT a.id, COALESCE(a.chats_count, 0) AS stored, COUNT(c.id) AS actual
+		FROM Applications a
+		LEFT JOIN Chats c ON c.application_id = a.id AND c.deleted_at IS NULL
+		WHERE a.updated_at >= ? OR a.id IN (SELECT application_id FROM Chats WHERE updated_at >= ?)
+		GROUP BY a.id, a.chats_count
+		HAVING stored <> actual
+	`
+	return reconcileCounts(r.database, "chats_count", query, "UPDATE Applications SET chats_count = chats_count + ? WHERE id = ?", since)
+}
@@ -2,35 +2,47 @@ package database
 
 import (
 	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type ChatsDatabaseHandler struct {
 	database *sqlx.DB
+	searcher search.MessageSearcher
 }
 
-func NewChatsDatabaseHandler() *ChatsDatabaseHandler {
-	return &ChatsDatabaseHandler{database: DATABASE}
+func NewChatsDatabaseHandler(searcher search.MessageSearcher) *ChatsDatabaseHandler {
+	return &ChatsDatabaseHandler{database: DATABASE, searcher: searcher}
 }
 
 func (r *ChatsDatabaseHandler) InsertChat(appId int64, subject string) (int64, error) {
-	var chatNumber int64
-
-	tx := r.database.MustBegin()
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
 	defer tx.Rollback()
 
-	err := tx.Get(&chatNumber, `SELECT COALESCE(MAX(number), 0) FROM Chats WHERE application_id = ? FOR UPDATE`, appId)
+	chatNumber, err := nextNumber(tx, "Applications", "next_chat_number", "chats_count", appId, "application")
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch max chat number: %w", err)
+		return 0, err
 	}
 
-	chatNumber++
-
-	_, err = tx.Exec(`INSERT INTO Chats (application_id, subject, number) VALUES (?, ?,?)`, appId, subject, chatNumber)
+	result, err := tx.Exec(`INSERT INTO Chats (application_id, subject, number) VALUES (?, ?,?)`, appId, subject, chatNumber)
+	if err != nil {
+		return 0, fmt.Errorf("failed to insert new chat: %w", domainError("chat", err))
+	}
+	chatId, err := result.LastInsertId()
 	if err != nil {
-		return 0, fmt.Errorf("failed to insert new chat: %w", err)
+		return 0, fmt.Errorf("failed to fetch chat ID: %w", err)
+	}
+	entryId, err := addChatToOutbox(tx, chatId)
+	if err != nil {
+		return 0, err
 	}
 
 	err = tx.Commit()
@@ -38,82 +50,273 @@ func (r *ChatsDatabaseHandler) InsertChat(appId int64, subject string) (int64, e
 		return 0, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	inserted := models.Chat{Id: chatId, ApplicationId: appId, UserExposedChat: models.UserExposedChat{Subject: subject, Number: chatNumber}, Version: 1}
+	publishChat(r.database, r.searcher, entryId, inserted)
 	return chatNumber, nil
 }
 func (r *ChatsDatabaseHandler) GetChatByApplicationIdAndChatNumber(appId int64, chatNumber int64) (models.Chat, error) {
 	chat := models.Chat{}
-	query := "SELECT * FROM Chats WHERE application_id = ? AND number = ?"
+	query := "SELECT * FROM Chats WHERE application_id = ? AND number = ? AND deleted_at IS NULL"
 	err := r.database.Get(&chat, query, appId, chatNumber)
 	if err != nil {
-		return models.Chat{}, fmt.Errorf("failed to get chat: %w", err)
+		return models.Chat{}, fmt.Errorf("failed to get chat: %w", domainError("chat", err))
 	}
 	return chat, nil
 }
 
-func (r *ChatsDatabaseHandler) GetAllChatsForAnApp(appId int64) ([]models.Chat, error) {
+// GetAllChatsForAnApp returns one page of an application's chats keyed on
+// number, and whether more chats follow in the direction of the page.
+func (r *ChatsDatabaseHandler) GetAllChatsForAnApp(appId int64, page models.PageQuery) ([]models.Chat, bool, error) {
 	allChats := []models.Chat{}
-	query := "SELECT * FROM Chats WHERE application_id = ?"
-	err := r.database.Select(&allChats, query, appId)
+	condition, orderLimit, args := pageClause("number", page)
+	query := "SELECT * FROM Chats WHERE application_id = ? AND deleted_at IS NULL AND " + condition + " " + orderLimit
+	err := r.database.Select(&allChats, query, append([]interface{}{appId}, args...)...)
 	if err != nil {
-		return []models.Chat{}, fmt.Errorf("failed to get chats: %w", err)
+		return []models.Chat{}, false, fmt.Errorf("failed to get chats: %w", err)
 	}
-	return allChats, nil
+	allChats, hasMore := trimPage(allChats, page)
+	return allChats, hasMore, nil
 }
+
 func (r *ChatsDatabaseHandler) UpdateChatSubject(appId int64, chatNumber int64, newSubject string) (models.Chat, error) {
 	updatedChat := models.Chat{}
 	query := `
         UPDATE Chats
-        SET subject = ?
-        WHERE application_id = ? AND number = ?
+        SET subject = ?, version = version + 1
+        WHERE application_id = ? AND number = ? AND deleted_at IS NULL
     `
 
-	tx := r.database.MustBegin()
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return models.Chat{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
 	defer tx.Rollback()
 
-	_, err := tx.Exec(query, newSubject, appId, chatNumber)
+	_, err = tx.Exec(query, newSubject, appId, chatNumber)
 	if err != nil {
 		tx.Rollback()
-		return models.Chat{}, fmt.Errorf("failed to update chat subject: %w", err)
+		return models.Chat{}, fmt.Errorf("failed to update chat subject: %w", domainError("chat", err))
 	}
 
 	fetchQuery := `
         SELECT *
         FROM Chats
-        WHERE application_id = ? AND number = ?    `
+        WHERE application_id = ? AND number = ? AND deleted_at IS NULL`
 	err = tx.Get(&updatedChat, fetchQuery, appId, chatNumber)
 	if err != nil {
 		tx.Rollback()
-		return models.Chat{}, fmt.Errorf("failed to fetch updated chat: %w", err)
+		return models.Chat{}, fmt.Errorf("failed to fetch updated chat: %w", domainError("chat", err))
+	}
+	entryId, err := addChatToOutbox(tx, updatedChat.Id)
+	if err != nil {
+		return models.Chat{}, err
 	}
 	if err := tx.Commit(); err != nil {
 		return models.Chat{}, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
+	publishChat(r.database, r.searcher, entryId, updatedChat)
 	return updatedChat, nil
 }
 
+// DeleteChat soft deletes a chat, which hides its messages along with it,
+// and decrements the application's chats_count. It returns a NotFoundError
+// when the chat does not exist.
+func (r *ChatsDatabaseHandler) DeleteChat(appId int64, chatNumber int64) error {
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE Chats SET deleted_at = NOW(), version = version + 1 WHERE application_id = ? AND number = ? AND deleted_at IS NULL"
+	result, err := tx.Exec(query, appId, chatNumber)
+	if err != nil {
+		return fmt.Errorf("failed to delete chat: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count deleted chats: %w", err)
+	}
+	if deleted == 0 {
+		return fmt.Errorf("failed to delete chat: %w", &NotFoundError{Resource: "chat"})
+	}
+	deletedChat := models.Chat{}
+	err = tx.Get(&deletedChat, "SELECT * FROM Chats WHERE application_id = ? AND number = ?", appId, chatNumber)
+	if err != nil {
+		return fmt.Errorf("failed to fetch deleted chat: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE Applications SET chats_count = GREATEST(chats_count - 1, 0) WHERE id = ?", appId)
+	if err != nil {
+		return fmt.Errorf("failed to update chats_count: %w", err)
+	}
+	entryId, err := addChatToOutbox(tx, deletedChat.Id)
+	if err != nil {
+		return err
+	}
+	if err := addChatMessagesToOutbox(tx, deletedChat.Id); err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	publishChat(r.database, r.searcher, entryId, deletedChat)
+	return nil
+}
+
+// RestoreChat brings back a soft deleted chat and increments the
+// application's chats_count. It returns a NotFoundError when there is no
+// deleted chat with that number.
+func (r *ChatsDatabaseHandler) RestoreChat(appId int64, chatNumber int64) (models.Chat, error) {
+	restoredChat := models.Chat{}
+
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return models.Chat{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	query := "UPDATE Chats SET deleted_at = NULL, version = version + 1 WHERE application_id = ? AND number = ? AND deleted_at IS NOT NULL"
+	result, err := tx.Exec(query, appId, chatNumber)
+	if err != nil {
+		return models.Chat{}, fmt.Errorf("failed to restore chat: %w", err)
+	}
+	restored, err := result.RowsAffected()
+	if err != nil {
+		return models.Chat{}, fmt.Errorf("failed to count restored chats: %w", err)
+	}
+	if restored == 0 {
+		return models.Chat{}, fmt.Errorf("failed to restore chat: %w", &NotFoundError{Resource: "deleted chat"})
+	}
+
+	_, err = tx.Exec("UPDATE Applications SET chats_count = chats_count + 1 WHERE id = ?", appId)
+	if err != nil {
+		return models.Chat{}, fmt.Errorf("failed to update chats_count: %w", err)
+	}
+
+	err = tx.Get(&restoredChat, "SELECT * FROM Chats WHERE application_id = ? AND number = ?", appId, chatNumber)
+	if err != nil {
+		return models.Chat{}, fmt.Errorf("failed to fetch restored chat: %w", err)
+	}
+	entryId, err := addChatToOutbox(tx, restoredChat.Id)
+	if err != nil {
+		return models.Chat{}, err
+	}
+	if err := addChatMessagesToOutbox(tx, restoredChat.Id); err != nil {
+		return models.Chat{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Chat{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	publishChat(r.database, r.searcher, entryId, restoredChat)
+	return restoredChat, nil
+}
+
+// PurgeDeletedChats permanently removes chats deleted more than retention
+// ago, with their messages, and returns how many chats were purged.
+func (r *ChatsDatabaseHandler) PurgeDeletedChats(retention time.Duration) (int64, error) {
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	chatIds := []int64{}
+	err = tx.Select(&chatIds, "SELECT id FROM Chats WHERE deleted_at < NOW() - INTERVAL ? SECOND FOR UPDATE", int64(retention.Seconds()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get deleted chats: %w", err)
+	}
+	if len(chatIds) == 0 {
+		return 0, nil
+	}
+
+	// Messages are removed by ON DELETE CASCADE
+	query, args, err := sqlx.In("DELETE FROM Chats WHERE id IN (?)", chatIds)
+	if err != nil {
+		return 0, fmt.Errorf("failed to build delete query: %w", err)
+	}
+	_, err = tx.Exec(query, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge chats: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	//elastic
+	err = r.searcher.DeleteChats(context.Background(), chatIds)
+	if err != nil {
+		return int64(len(chatIds)), fmt.Errorf("%w: %w", ErrIndexMessage, err)
+	}
+
+	return int64(len(chatIds)), nil
+}
+
 func (r *ChatsDatabaseHandler) GetChatIdByAppIdAndChatNumber(appId int64, chatNumber int64) (int64, error) {
 	var id int64
-	query := "SELECT id FROM Chats WHERE application_id = ? AND number = ?"
+	query := "SELECT id FROM Chats WHERE application_id = ? AND number = ? AND deleted_at IS NULL"
 	err := r.database.Get(&id, query, appId, chatNumber)
 	if err != nil {
-		return 0, fmt.Errorf("failed to get chat id: %w", err)
+		return 0, fmt.Errorf("failed to get chat id: %w", domainError("chat", err))
 	}
 	return id, nil
 }
 
-func (r *ChatsDatabaseHandler) UpdateMessagesCount() error {
+// ReconcileMessagesCount recounts the messages of the chats changed since
+// since, or whose messages were, fixes the messages_count found to drift and
+// returns the drifts.
+func (r *ChatsDatabaseHandler) ReconcileMessagesCount(since time.Time) ([]CountDrift, error) {
 	query := `
-		UPDATE Chats c
-		SET messages_count = (
-			SELECT COUNT(*)
-			FROM Messages m
-			WHERE m.chat_id = c.id
-		)
+		SELECT c.id, COALESCE(c.messages_count, 0) AS stored, COUNT(m.id) AS actual
+		FROM Chats c
+		LEFT JOIN Messages m ON m.chat_id = c.id AND m.deleted_at IS NULL
+		WHERE c.updated_at >= ? OR c.id IN (SELECT chat_id FROM Messages WHERE updated_at >= ?)
+		GROUP BY c.id, c.messages_count
+		HAVING stored <> actual
 	`
-	_, err := r.database.Exec(query)
+	return reconcileCounts(r.database, "messages_count", query, "UPDATE Chats SET messages_count = messages_count + ? WHERE id = ?", since)
+}
+
+// GetChatsForIndexing pages through the chats of a scope in id order, soft
+// deleted ones included.
+func (r *ChatsDatabaseHandler) GetChatsForIndexing(scope models.IndexScope, afterId int64, limit int) ([]models.Chat, error) {
+	chats := []models.Chat{}
+	conditions := []string{"id > ?"}
+	args := []interface{}{afterId}
+	if scope.ChatId != 0 {
+		conditions = append(conditions, "id = ?")
+		args = append(args, scope.ChatId)
+	}
+	if scope.ApplicationId != 0 {
+		conditions = append(conditions, "application_id = ?")
+		args = append(args, scope.ApplicationId)
+	}
+	if !scope.UpdatedSince.IsZero() {
+		conditions = append(conditions, "updated_at >= ?")
+		args = append(args, scope.UpdatedSince)
+	}
+	query := "SELECT * FROM Chats WHERE " + strings.Join(conditions, " AND ") + " ORDER BY id LIMIT ?"
+	err := r.database.Select(&chats, query, append(args, limit)...)
 	if err != nil {
-		return fmt.Errorf("failed to update messages_count: %w", err)
+		return []models.Chat{}, fmt.Errorf("failed to get chats to index: %w", err)
+	}
+	return chats, nil
+}
+
+// ChatDocument is the search document of the subject of a chat, versioned
+// by the version of the chat.
+func ChatDocument(chat models.Chat) search.ChatDocument {
+	return search.ChatDocument{
+		ApplicationId: chat.ApplicationId,
+		ChatId:        chat.Id,
+		Number:        chat.Number,
+		Subject:       chat.Subject,
+		Version:       chat.Version,
 	}
-	return nil
 }
@@ -1,58 +1,117 @@
 package database
 
 import (
+	"chat-system/internal/config"
+	"chat-system/internal/search"
 	"context"
 	"fmt"
-	"os"
+	"net/http"
 
 	"github.com/elastic/go-elasticsearch/v8"
-	"github.com/elastic/go-elasticsearch/v8/esapi"
 	_ "github.com/go-sql-driver/mysql"
 	"github.com/jmoiron/sqlx"
 )
 
 var DATABASE *sqlx.DB
 var ESClient *elasticsearch.Client
+var esTransport *http.Transport
 
-const SearchIndex = "messages"
-
-func InitDB() error {
+// InitDB connects to the database of cfg and sizes its connection pool.
+func InitDB(cfg config.Database) error {
 	if DATABASE != nil {
 		return nil
 	}
-	userName := os.Getenv("DB_USER")
-	host := os.Getenv("DB_HOST")
-	passWord := os.Getenv("DB_PASSWORD")
-	name := os.Getenv("DB_NAME")
-	port := os.Getenv("DB_PORT")
-	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%s)/%s?parseTime=true", userName, passWord, host, port, name)
+	connectionString := fmt.Sprintf("%s:%s@tcp(%s:%d)/%s?parseTime=true", cfg.User, cfg.Password, cfg.Host, cfg.Port, cfg.Name)
 	database, err := sqlx.Connect("mysql", connectionString)
 	if err != nil {
 		return err
 	}
+	database.SetMaxOpenConns(cfg.MaxOpenConns)
+	database.SetMaxIdleConns(cfg.MaxIdleConns)
+	database.SetConnMaxLifetime(cfg.ConnMaxLifetime)
 	DATABASE = database
 	return nil
 }
 
-func ESClientConnection() {
-	cfg := elasticsearch.Config{
-		Addresses: []string{
-			"http://elasticsearch:9200",
-		},
+func CloseDB() error {
+	if DATABASE == nil {
+		return nil
+	}
+	return DATABASE.Close()
+}
+
+// ESClientConnection connects to the cluster at the addresses of cfg, with its
+// credentials when set.
+func ESClientConnection(cfg config.Search) {
+	esTransport = http.DefaultTransport.(*http.Transport).Clone()
+	esConfig := elasticsearch.Config{
+		Addresses: cfg.Addresses,
+		Username:  cfg.Username,
+		Password:  cfg.Password,
+		APIKey:    cfg.APIKey,
+		Transport: esTransport,
 	}
-	client, err := elasticsearch.NewClient(cfg)
+	client, err := elasticsearch.NewClient(esConfig)
 	if err != nil {
 		panic(fmt.Sprintf("Error creating Elasticsearch client: %s", err))
 	}
 	ESClient = client
 }
 
-func ESCreateIndexIfNotExist() {
-	_, err := esapi.IndicesExistsRequest{
-		Index: []string{SearchIndex},
-	}.Do(context.Background(), ESClient)
+// ESClose releases the connections held by the Elasticsearch client.
+func ESClose() {
+	if esTransport != nil {
+		esTransport.CloseIdleConnections()
+	}
+}
+
+// ESCreateIndexIfNotExist creates an index with the mapping of
+// search.MessagesIndexSettings behind the search.MessagesIndex alias. An
+// existing index or alias keeps the mapping it was created with until the
+// next full reindex.
+func ESCreateIndexIfNotExist() error {
+	res, err := ESClient.Indices.Exists([]string{search.MessagesIndex})
+	if err != nil {
+		return fmt.Errorf("failed to check the search index: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	if res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	_, err = search.CreateMessagesIndex(context.Background(), ESClient, true)
+	return err
+}
+
+// ESCreateChatsIndexIfNotExist creates an index with the mapping of
+// search.ChatsIndexSettings behind the search.ChatsIndex alias, unless an
+// index or alias of that name exists.
+func ESCreateChatsIndexIfNotExist() error {
+	res, err := ESClient.Indices.Exists([]string{search.ChatsIndex})
+	if err != nil {
+		return fmt.Errorf("failed to check the chats index: %w", err)
+	}
+	res.Body.Close()
+	if res.StatusCode == http.StatusOK {
+		return nil
+	}
+	if res.StatusCode != http.StatusNotFound {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	_, err = search.CreateChatsIndex(context.Background(), ESClient, true)
+	return err
+}
 
+// ESIndexSchemaOutdated tells whether the search index was created for an
+// older search.DocumentSchemaVersion and has to be rebuilt.
+func ESIndexSchemaOutdated() (bool, error) {
+	version, err := search.IndexSchemaVersion(context.Background(), ESClient)
 	if err != nil {
-		ESClient.Indices.Create(SearchIndex)
+		return false, err
 	}
+	return version < search.DocumentSchemaVersion, nil
 }
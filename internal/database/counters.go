@@ -0,0 +1,35 @@
+package database
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// CountDrift is a stored count that differed from the rows it counts.
+type CountDrift struct {
+	Id     int64 `db:"id"`
+	Stored int64 `db:"stored"`
+	Actual int64 `db:"actual"`
+}
+
+// reconcileCounts selects the drifts of the rows changed since since with
+// selectQuery, which takes since twice, and corrects each with updateQuery.
+// The correction is applied as a delta, so inserts and deletes committed
+// after the drift was read still count.
+func reconcileCounts(database *sqlx.DB, column string, selectQuery string, updateQuery string, since time.Time) ([]CountDrift, error) {
+	drifts := []CountDrift{}
+	err := database.Select(&drifts, selectQuery, since, since)
+	if err != nil {
+		return nil, fmt.Errorf("failed to recount %s: %w", column, err)
+	}
+
+	for _, drift := range drifts {
+		_, err := database.Exec(updateQuery, drift.Actual-drift.Stored, drift.Id)
+		if err != nil {
+			return nil, fmt.Errorf("failed to update %s: %w", column, err)
+		}
+	}
+	return drifts, nil
+}
@@ -0,0 +1,166 @@
+package database
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"net"
+	"regexp"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// ErrIndexMessage is returned when a message was stored in MySQL but could
+// not be written to the search index.
+var ErrIndexMessage = errors.New("failed to index message")
+
+// Domain errors, matched with errors.Is, that tell callers what went wrong
+// with the request rather than with the infrastructure.
+var (
+	ErrNotFound   = errors.New("not found")
+	ErrConflict   = errors.New("conflict")
+	ErrValidation = errors.New("validation failed")
+)
+
+// NotFoundError reports that a resource does not exist or is deleted.
+type NotFoundError struct {
+	Resource string
+}
+
+func (e *NotFoundError) Error() string { return e.Resource + " not found" }
+
+func (e *NotFoundError) Is(target error) bool { return target == ErrNotFound }
+
+// ConflictError reports that a write clashes with an existing resource.
+type ConflictError struct {
+	Resource string
+	Err      error
+}
+
+func (e *ConflictError) Error() string { return e.Resource + " already exists" }
+
+func (e *ConflictError) Is(target error) bool { return target == ErrConflict }
+
+func (e *ConflictError) Unwrap() error { return e.Err }
+
+// ValidationError reports a value MySQL refused to store in a column.
+type ValidationError struct {
+	Field  string
+	Reason string
+	Err    error
+}
+
+func (e *ValidationError) Error() string { return e.Field + ": " + e.Reason }
+
+func (e *ValidationError) Is(target error) bool { return target == ErrValidation }
+
+func (e *ValidationError) Unwrap() error { return e.Err }
+
+// Kinds of the domain errors, as recorded on the status of a task that
+// failed with one
+const (
+	KindNotFound   = "not_found"
+	KindConflict   = "conflict"
+	KindValidation = "validation"
+)
+
+// ErrorKind returns the kind of the domain error in the chain of err and the
+// domain error itself, or an empty kind for any other error.
+func ErrorKind(err error) (string, error) {
+	var notFound *NotFoundError
+	var conflict *ConflictError
+	var invalid *ValidationError
+	switch {
+	case errors.As(err, &notFound):
+		return KindNotFound, notFound
+	case errors.As(err, &conflict):
+		return KindConflict, conflict
+	case errors.As(err, &invalid):
+		return KindValidation, invalid
+	}
+	return "", err
+}
+
+// ErrorOfKind rebuilds a domain error of kind from its message, the inverse
+// of ErrorKind and Error. It returns nil for an empty or unknown kind.
+func ErrorOfKind(kind string, message string) error {
+	switch kind {
+	case KindNotFound:
+		return &NotFoundError{Resource: strings.TrimSuffix(message, " not found")}
+	case KindConflict:
+		return &ConflictError{Resource: strings.TrimSuffix(message, " already exists")}
+	case KindValidation:
+		field, reason, _ := strings.Cut(message, ": ")
+		return &ValidationError{Field: field, Reason: reason}
+	}
+	return nil
+}
+
+// MySQL error numbers that are worth retrying
+const (
+	mysqlLockWaitTimeout   = 1205
+	mysqlDeadlock          = 1213
+	mysqlTooManyConnection = 1040
+)
+
+// MySQL error numbers caused by the request itself
+const (
+	mysqlDuplicateEntry  = 1062
+	mysqlDataTooLong     = 1406
+	mysqlIncorrectValue  = 1366
+	mysqlOutOfRangeValue = 1264
+)
+
+var mysqlColumnName = regexp.MustCompile(`column '([^']+)'`)
+
+// domainError translates the driver errors of a query on resource into the
+// domain errors above. Any other error is returned unchanged.
+func domainError(resource string, err error) error {
+	if errors.Is(err, sql.ErrNoRows) {
+		return &NotFoundError{Resource: resource}
+	}
+	var mysqlErr *mysql.MySQLError
+	if !errors.As(err, &mysqlErr) {
+		return err
+	}
+	switch mysqlErr.Number {
+	case mysqlDuplicateEntry:
+		return &ConflictError{Resource: resource, Err: err}
+	case mysqlDataTooLong:
+		return &ValidationError{Field: mysqlColumn(mysqlErr), Reason: "value is too long", Err: err}
+	case mysqlIncorrectValue, mysqlOutOfRangeValue:
+		return &ValidationError{Field: mysqlColumn(mysqlErr), Reason: "value is invalid", Err: err}
+	}
+	return err
+}
+
+// mysqlColumn extracts the column name MySQL quotes in its error message.
+func mysqlColumn(mysqlErr *mysql.MySQLError) string {
+	if match := mysqlColumnName.FindStringSubmatch(mysqlErr.Message); match != nil {
+		return match[1]
+	}
+	return "value"
+}
+
+// IsTransient reports whether err is likely to go away if the operation is
+// attempted again: lock contention, a lost connection or an unreachable
+// search index.
+func IsTransient(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.Is(err, ErrIndexMessage) || errors.Is(err, driver.ErrBadConn) || errors.Is(err, mysql.ErrInvalidConn) {
+		return true
+	}
+	var mysqlErr *mysql.MySQLError
+	if errors.As(err, &mysqlErr) {
+		switch mysqlErr.Number {
+		case mysqlLockWaitTimeout, mysqlDeadlock, mysqlTooManyConnection:
+			return true
+		}
+		return false
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr)
+}
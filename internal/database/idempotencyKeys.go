@@ -0,0 +1,77 @@
+package database
+
+import (
+	"chat-system/internal/models"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type IdempotencyKeysDatabaseHandler struct {
+	database *sqlx.DB
+	ttl      time.Duration
+}
+
+// NewIdempotencyKeysDatabaseHandler remembers every key for ttl.
+func NewIdempotencyKeysDatabaseHandler(ttl time.Duration) *IdempotencyKeysDatabaseHandler {
+	return &IdempotencyKeysDatabaseHandler{database: DATABASE, ttl: ttl}
+}
+
+// ReserveIdempotencyKey claims key for taskId within scope. If the key is
+// already held by an earlier request, that request's record is returned
+// instead and nothing is written.
+func (r *IdempotencyKeysDatabaseHandler) ReserveIdempotencyKey(scope string, key string, requestHash string, taskId string) (models.IdempotencyKey, error) {
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return models.IdempotencyKey{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	// A key past its window is free to be used again
+	_, err = tx.Exec("DELETE FROM IdempotencyKeys WHERE scope = ? AND idempotency_key = ? AND expires_at <= NOW()", scope, key)
+	if err != nil {
+		return models.IdempotencyKey{}, fmt.Errorf("failed to delete expired idempotency key: %w", err)
+	}
+
+	insertQuery := `
+        INSERT IGNORE INTO IdempotencyKeys (scope, idempotency_key, request_hash, task_id, expires_at)
+        VALUES (?, ?, ?, ?, NOW() + INTERVAL ? SECOND)
+    `
+	_, err = tx.Exec(insertQuery, scope, key, requestHash, taskId, int64(r.ttl.Seconds()))
+	if err != nil {
+		return models.IdempotencyKey{}, fmt.Errorf("failed to insert idempotency key: %w", err)
+	}
+
+	idempotencyKey := models.IdempotencyKey{}
+	err = tx.Get(&idempotencyKey, "SELECT * FROM IdempotencyKeys WHERE scope = ? AND idempotency_key = ?", scope, key)
+	if err != nil {
+		return models.IdempotencyKey{}, fmt.Errorf("failed to fetch idempotency key: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.IdempotencyKey{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return idempotencyKey, nil
+}
+
+func (r *IdempotencyKeysDatabaseHandler) DeleteIdempotencyKey(scope string, key string) error {
+	_, err := r.database.Exec("DELETE FROM IdempotencyKeys WHERE scope = ? AND idempotency_key = ?", scope, key)
+	if err != nil {
+		return fmt.Errorf("failed to delete idempotency key: %w", err)
+	}
+	return nil
+}
+
+func (r *IdempotencyKeysDatabaseHandler) DeleteExpiredIdempotencyKeys() (int64, error) {
+	result, err := r.database.Exec("DELETE FROM IdempotencyKeys WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired idempotency keys: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted idempotency keys: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,120 @@
+package memory
+
+import (
+	"chat-system/internal/database"
+	"chat-system/internal/models"
+	"time"
+)
+
+func (s *Store) InsertApplication(name string, token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, app := range s.applications {
+		if app.Token == token {
+			return &database.ConflictError{Resource: "application"}
+		}
+	}
+	now := time.Now()
+	s.applications = append(s.applications, &models.Application{
+		Id:                     s.nextId(),
+		UserExposedApplication: models.UserExposedApplication{Name: name, Token: token},
+		NextChatNumber:         1,
+		CreatedAt:              now,
+		UpdatedAt:              now,
+	})
+	return nil
+}
+
+func (s *Store) GetApplicationByToken(token string) (models.Application, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app := s.application(token, false)
+	if app == nil {
+		return models.Application{}, &database.NotFoundError{Resource: "application"}
+	}
+	return *app, nil
+}
+
+func (s *Store) GetAllApplications(page models.PageQuery) ([]models.Application, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	apps := []models.Application{}
+	for _, app := range s.applications {
+		if app.DeletedAt == nil {
+			apps = append(apps, *app)
+		}
+	}
+	apps, hasMore := paginate(apps, func(app models.Application) int64 { return app.Id }, page)
+	return apps, hasMore, nil
+}
+
+func (s *Store) UpdateApplicationName(token string, name string) (models.Application, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app := s.application(token, false)
+	if app == nil {
+		return models.Application{}, &database.NotFoundError{Resource: "application"}
+	}
+	app.Name = name
+	app.UpdatedAt = time.Now()
+	return *app, nil
+}
+
+func (s *Store) GetApplicationIdByToken(token string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app := s.application(token, false)
+	if app == nil {
+		return 0, &database.NotFoundError{Resource: "application"}
+	}
+	return app.Id, nil
+}
+
+func (s *Store) DeleteApplication(token string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app := s.application(token, false)
+	if app == nil {
+		return &database.NotFoundError{Resource: "application"}
+	}
+	app.DeletedAt = deletedNow()
+	return nil
+}
+
+func (s *Store) RestoreApplication(token string) (models.Application, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app := s.application(token, true)
+	if app == nil {
+		return models.Application{}, &database.NotFoundError{Resource: "deleted application"}
+	}
+	app.DeletedAt = nil
+	return *app, nil
+}
+
+// application finds an application that is deleted or not. The caller holds mu.
+func (s *Store) application(token string, deleted bool) *models.Application {
+	for _, app := range s.applications {
+		if app.Token == token && (app.DeletedAt != nil) == deleted {
+			return app
+		}
+	}
+	return nil
+}
+
+// applicationById returns the application a chat belongs to. The caller holds mu.
+func (s *Store) applicationById(id int64) *models.Application {
+	for _, app := range s.applications {
+		if app.Id == id {
+			return app
+		}
+	}
+	return nil
+}
@@ -0,0 +1,163 @@
+package memory
+
+import (
+	"chat-system/internal/database"
+	"chat-system/internal/models"
+	"time"
+)
+
+func (s *Store) InsertChat(appId int64, subject string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	app := s.applicationById(appId)
+	if app == nil || app.DeletedAt != nil {
+		return 0, &database.NotFoundError{Resource: "application"}
+	}
+	chatNumber := app.NextChatNumber
+	app.NextChatNumber++
+	app.ChatsCount++
+
+	now := time.Now()
+	chat := &models.Chat{
+		Id:                s.nextId(),
+		ApplicationId:     appId,
+		UserExposedChat:   models.UserExposedChat{Subject: subject, Number: chatNumber},
+		NextMessageNumber: 1,
+		CreatedAt:         now,
+		UpdatedAt:         now,
+		Version:           1,
+	}
+	s.chats = append(s.chats, chat)
+	s.publishChat(chat)
+	return chatNumber, nil
+}
+
+func (s *Store) GetChatByApplicationIdAndChatNumber(appId int64, chatNumber int64) (models.Chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chat(appId, chatNumber, false)
+	if chat == nil {
+		return models.Chat{}, &database.NotFoundError{Resource: "chat"}
+	}
+	return *chat, nil
+}
+
+func (s *Store) GetAllChatsForAnApp(appId int64, page models.PageQuery) ([]models.Chat, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chats := []models.Chat{}
+	for _, chat := range s.chats {
+		if chat.ApplicationId == appId && chat.DeletedAt == nil {
+			chats = append(chats, *chat)
+		}
+	}
+	chats, hasMore := paginate(chats, func(chat models.Chat) int64 { return chat.Number }, page)
+	return chats, hasMore, nil
+}
+
+func (s *Store) UpdateChatSubject(appId int64, chatNumber int64, newSubject string) (models.Chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chat(appId, chatNumber, false)
+	if chat == nil {
+		return models.Chat{}, &database.NotFoundError{Resource: "chat"}
+	}
+	chat.Subject = newSubject
+	chat.UpdatedAt = time.Now()
+	chat.Version++
+	s.publishChat(chat)
+	return *chat, nil
+}
+
+func (s *Store) GetChatIdByAppIdAndChatNumber(appId int64, chatNumber int64) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chat(appId, chatNumber, false)
+	if chat == nil {
+		return 0, &database.NotFoundError{Resource: "chat"}
+	}
+	return chat.Id, nil
+}
+
+func (s *Store) DeleteChat(appId int64, chatNumber int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chat(appId, chatNumber, false)
+	if chat == nil {
+		return &database.NotFoundError{Resource: "chat"}
+	}
+	chat.DeletedAt = deletedNow()
+	chat.UpdatedAt = time.Now()
+	chat.Version++
+	if app := s.applicationById(appId); app != nil && app.ChatsCount > 0 {
+		app.ChatsCount--
+	}
+	s.addChatMessagesToOutbox(chat.Id)
+	s.publishChat(chat)
+	return nil
+}
+
+func (s *Store) RestoreChat(appId int64, chatNumber int64) (models.Chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chat(appId, chatNumber, true)
+	if chat == nil {
+		return models.Chat{}, &database.NotFoundError{Resource: "deleted chat"}
+	}
+	chat.DeletedAt = nil
+	chat.UpdatedAt = time.Now()
+	chat.Version++
+	if app := s.applicationById(appId); app != nil {
+		app.ChatsCount++
+	}
+	s.addChatMessagesToOutbox(chat.Id)
+	s.publishChat(chat)
+	return *chat, nil
+}
+
+func (s *Store) GetChatsForIndexing(scope models.IndexScope, afterId int64, limit int) ([]models.Chat, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chats := []models.Chat{}
+	for _, chat := range s.chats {
+		if chat.Id <= afterId || (scope.ChatId != 0 && chat.Id != scope.ChatId) || (scope.ApplicationId != 0 && chat.ApplicationId != scope.ApplicationId) {
+			continue
+		}
+		if chat.UpdatedAt.Before(scope.UpdatedSince) {
+			continue
+		}
+		chats = append(chats, *chat)
+		if len(chats) == limit {
+			break
+		}
+	}
+	return chats, nil
+}
+
+// chat finds a chat that is deleted or not. The caller holds mu.
+func (s *Store) chat(appId int64, chatNumber int64, deleted bool) *models.Chat {
+	for _, chat := range s.chats {
+		if chat.ApplicationId == appId && chat.Number == chatNumber && (chat.DeletedAt != nil) == deleted {
+			return chat
+		}
+	}
+	return nil
+}
+
+// chatById returns the chat a message belongs to. The caller holds mu.
+func (s *Store) chatById(id int64) *models.Chat {
+	for _, chat := range s.chats {
+		if chat.Id == id {
+			return chat
+		}
+	}
+	return nil
+}
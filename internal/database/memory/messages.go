@@ -0,0 +1,203 @@
+package memory
+
+import (
+	"chat-system/internal/database"
+	"chat-system/internal/models"
+	"context"
+	"fmt"
+	"time"
+)
+
+func (s *Store) InsertMessage(chatId int64, body string) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	chat := s.chatById(chatId)
+	if chat == nil || chat.DeletedAt != nil {
+		return 0, &database.NotFoundError{Resource: "chat"}
+	}
+	messageNumber := chat.NextMessageNumber
+	chat.NextMessageNumber++
+	chat.MessagesCount++
+
+	now := time.Now()
+	message := &models.Message{
+		Id:                 s.nextId(),
+		ChatId:             chatId,
+		UserExposedMessage: models.UserExposedMessage{Number: messageNumber, Body: body},
+		CreatedAt:          now,
+		UpdatedAt:          now,
+		Version:            1,
+	}
+	s.messages = append(s.messages, message)
+	s.publish(message)
+	return messageNumber, nil
+}
+
+func (s *Store) GetMessageByChatIdAndMessageNumber(chatId int64, messageNumber int64) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.message(chatId, messageNumber, false)
+	if message == nil {
+		return models.Message{}, &database.NotFoundError{Resource: "message"}
+	}
+	return *message, nil
+}
+
+func (s *Store) GetAllMessagesForAChat(chatId int64, page models.PageQuery) ([]models.Message, bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := []models.Message{}
+	for _, message := range s.messages {
+		if message.ChatId == chatId && message.DeletedAt == nil {
+			messages = append(messages, *message)
+		}
+	}
+	messages, hasMore := paginate(messages, func(message models.Message) int64 { return message.Number }, page)
+	return messages, hasMore, nil
+}
+
+func (s *Store) UpdateMessageBody(chatId int64, messageNumber int64, newBody string) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.message(chatId, messageNumber, false)
+	if message == nil {
+		return models.Message{}, &database.NotFoundError{Resource: "message"}
+	}
+	message.Body = newBody
+	message.UpdatedAt = time.Now()
+	message.Version++
+	s.publish(message)
+	return *message, nil
+}
+
+func (s *Store) DeleteMessage(chatId int64, messageNumber int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.message(chatId, messageNumber, false)
+	if message == nil {
+		return &database.NotFoundError{Resource: "message"}
+	}
+	message.DeletedAt = deletedNow()
+	message.UpdatedAt = *message.DeletedAt
+	message.Version++
+	if chat := s.chatById(chatId); chat != nil && chat.MessagesCount > 0 {
+		chat.MessagesCount--
+	}
+	s.publish(message)
+	return nil
+}
+
+func (s *Store) RestoreMessage(chatId int64, messageNumber int64) (models.Message, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.message(chatId, messageNumber, true)
+	if message == nil {
+		return models.Message{}, &database.NotFoundError{Resource: "deleted message"}
+	}
+	message.DeletedAt = nil
+	message.UpdatedAt = time.Now()
+	message.Version++
+	if chat := s.chatById(chatId); chat != nil {
+		chat.MessagesCount++
+	}
+	s.publish(message)
+	return *message, nil
+}
+
+func (s *Store) IndexMessage(chatId int64, messageNumber int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	message := s.message(chatId, messageNumber, false)
+	if message == nil {
+		return &database.NotFoundError{Resource: "message"}
+	}
+	return s.index(message)
+}
+
+func (s *Store) GetMessagesForIndexing(scope models.IndexScope, afterId int64, limit int) ([]models.IndexedMessage, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	messages := []models.IndexedMessage{}
+	for _, message := range s.messages {
+		if len(messages) == limit {
+			break
+		}
+		if message.Id > afterId && s.inIndexScope(message, scope) {
+			messages = append(messages, s.indexed(message))
+		}
+	}
+	return messages, nil
+}
+
+func (s *Store) CountMessagesForIndexing(scope models.IndexScope) (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var count int64
+	for _, message := range s.messages {
+		if s.inIndexScope(message, scope) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (s *Store) IndexCheckpoint() (time.Time, error) {
+	return time.Now(), nil
+}
+
+// inIndexScope tells whether a message belongs to scope. Messages are kept in
+// id order. The caller holds mu.
+func (s *Store) inIndexScope(message *models.Message, scope models.IndexScope) bool {
+	if scope.ChatId != 0 && message.ChatId != scope.ChatId {
+		return false
+	}
+	if scope.ApplicationId != 0 {
+		if chat := s.chatById(message.ChatId); chat == nil || chat.ApplicationId != scope.ApplicationId {
+			return false
+		}
+	}
+	if !message.UpdatedAt.Before(scope.UpdatedSince) {
+		return true
+	}
+	chat := s.chatById(message.ChatId)
+	return chat != nil && !chat.UpdatedAt.Before(scope.UpdatedSince)
+}
+
+// indexed adds the details of its chat to a message. The caller holds mu.
+func (s *Store) indexed(message *models.Message) models.IndexedMessage {
+	indexed := models.IndexedMessage{Message: *message}
+	if chat := s.chatById(message.ChatId); chat != nil {
+		indexed.ApplicationId = chat.ApplicationId
+		indexed.ChatNumber = chat.Number
+		indexed.ChatDeletedAt = chat.DeletedAt
+	}
+	return indexed
+}
+
+// index writes a message to the search index. The caller holds mu.
+func (s *Store) index(message *models.Message) error {
+	err := s.searcher.IndexMessage(context.Background(), database.MessageDocument(s.indexed(message)))
+	if err != nil {
+		return fmt.Errorf("%w: %w", database.ErrIndexMessage, err)
+	}
+	return nil
+}
+
+// message finds a message that is deleted or not. The caller holds mu.
+func (s *Store) message(chatId int64, messageNumber int64, deleted bool) *models.Message {
+	for _, message := range s.messages {
+		if message.ChatId == chatId && message.Number == messageNumber && (message.DeletedAt != nil) == deleted {
+			return message
+		}
+	}
+	return nil
+}
@@ -0,0 +1,138 @@
+package memory
+
+import (
+	"chat-system/internal/database"
+	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"context"
+	"log"
+	"slices"
+	"time"
+)
+
+// outboxEntry is about the document of a chat when messageId is 0.
+type outboxEntry struct {
+	id          int64
+	chatId      int64
+	messageId   int64
+	attempts    int
+	availableAt time.Time
+}
+
+func (s *Store) ClaimOutboxEntries(ctx context.Context, limit int, lease time.Duration) ([]search.OutboxEntry, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := map[int64]models.IndexedMessage{}
+	for _, message := range s.messages {
+		stored[message.Id] = s.indexed(message)
+	}
+	storedChats := map[int64]models.Chat{}
+	for _, chat := range s.chats {
+		storedChats[chat.Id] = *chat
+	}
+
+	now := time.Now()
+	entries := []search.OutboxEntry{}
+	for _, entry := range s.outbox {
+		if len(entries) == limit {
+			break
+		}
+		if entry.availableAt.After(now) {
+			continue
+		}
+		entry.attempts++
+		entry.availableAt = now.Add(lease)
+		if entry.messageId == 0 {
+			entries = append(entries, database.ChatOutboxEntry(entry.id, entry.attempts, entry.chatId, storedChats))
+		} else {
+			entries = append(entries, database.OutboxEntry(entry.id, entry.attempts, entry.chatId, entry.messageId, stored))
+		}
+	}
+	return entries, nil
+}
+
+func (s *Store) DeleteOutboxEntries(ctx context.Context, ids []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.outbox = slices.DeleteFunc(s.outbox, func(entry *outboxEntry) bool {
+		return slices.Contains(ids, entry.id)
+	})
+	return nil
+}
+
+func (s *Store) RetryOutboxEntries(ctx context.Context, ids []int64, delay time.Duration, lastError string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, entry := range s.outbox {
+		if slices.Contains(ids, entry.id) {
+			entry.availableAt = time.Now().Add(delay)
+		}
+	}
+	return nil
+}
+
+// publish adds a changed message to the outbox, then writes it to the search
+// index and clears the entry, leaving it to the relay when that fails. The
+// caller holds mu.
+func (s *Store) publish(message *models.Message) {
+	s.addToOutbox(message.ChatId, message.Id)
+
+	ctx := context.Background()
+	var err error
+	indexed := s.indexed(message)
+	doc := database.MessageDocument(indexed)
+	if !indexed.Searchable() {
+		err = s.searcher.DeleteMessage(ctx, doc)
+	} else {
+		err = s.searcher.IndexMessage(ctx, doc)
+	}
+	if err != nil {
+		log.Printf("error indexing message %d, leaving it to the search outbox: %v", message.Id, err)
+		return
+	}
+	s.outbox = s.outbox[:len(s.outbox)-1]
+}
+
+// publishChat is publish for the subject of a chat. The caller holds mu.
+func (s *Store) publishChat(chat *models.Chat) {
+	s.addToOutbox(chat.Id, 0)
+
+	ctx := context.Background()
+	var err error
+	if chat.DeletedAt != nil {
+		err = s.searcher.DeleteChat(ctx, database.ChatDocument(*chat))
+	} else {
+		err = s.searcher.IndexChat(ctx, database.ChatDocument(*chat))
+	}
+	if err != nil {
+		log.Printf("error indexing chat %d, leaving it to the search outbox: %v", chat.Id, err)
+		return
+	}
+	s.outbox = s.outbox[:len(s.outbox)-1]
+}
+
+// addChatMessagesToOutbox adds entries for the live messages of a deleted or
+// restored chat, left to the relay. The caller holds mu.
+func (s *Store) addChatMessagesToOutbox(chatId int64) {
+	for _, message := range s.messages {
+		if message.ChatId == chatId && message.DeletedAt == nil {
+			s.addToOutbox(chatId, message.Id)
+			s.outbox[len(s.outbox)-1].availableAt = time.Now()
+		}
+	}
+}
+
+// addToOutbox adds an entry, left to the writer for search.OutboxGrace. The
+// caller holds mu.
+func (s *Store) addToOutbox(chatId int64, messageId int64) {
+	s.lastOutboxId++
+	s.outbox = append(s.outbox, &outboxEntry{
+		id:          s.lastOutboxId,
+		chatId:      chatId,
+		messageId:   messageId,
+		availableAt: time.Now().Add(search.OutboxGrace),
+	})
+}
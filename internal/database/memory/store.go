@@ -0,0 +1,107 @@
+// Package memory keeps applications, chats, messages, the search outbox, task
+// statuses and idempotency keys in process memory. It mirrors the behaviour of the MySQL
+// handlers in package database, including soft deletes and the domain errors
+// they return, so the API can be exercised without a database.
+package memory
+
+import (
+	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultTaskStatusRetention = 24 * time.Hour
+	defaultIdempotencyKeyTTL   = 24 * time.Hour
+)
+
+// Store is safe for concurrent use. Every method returns copies, so callers
+// cannot change stored rows behind its back.
+type Store struct {
+	mu sync.Mutex
+
+	applications []*models.Application
+	chats        []*models.Chat
+	messages     []*models.Message
+	lastId       int64
+
+	outbox       []*outboxEntry
+	lastOutboxId int64
+
+	taskStatuses        map[string]models.TaskStatus
+	taskStatusRetention time.Duration
+
+	idempotencyKeys   map[idempotencyScope]models.IdempotencyKey
+	idempotencyKeyTTL time.Duration
+
+	searcher search.MessageSearcher
+}
+
+type idempotencyScope struct {
+	scope string
+	key   string
+}
+
+// NewStore returns an empty store that keeps messages searchable through
+// searcher, as the MySQL handlers do.
+func NewStore(searcher search.MessageSearcher) *Store {
+	return &Store{
+		searcher:            searcher,
+		taskStatuses:        map[string]models.TaskStatus{},
+		taskStatusRetention: defaultTaskStatusRetention,
+		idempotencyKeys:     map[idempotencyScope]models.IdempotencyKey{},
+		idempotencyKeyTTL:   defaultIdempotencyKeyTTL,
+	}
+}
+
+// nextId hands out ids the way AUTO_INCREMENT does. The caller holds mu.
+func (s *Store) nextId() int64 {
+	s.lastId++
+	return s.lastId
+}
+
+// paginate applies a page query to rows, mirroring the pageClause and
+// trimPage pair of the MySQL handlers.
+func paginate[T any](rows []T, key func(T) int64, page models.PageQuery) ([]T, bool) {
+	descending := page.Descending
+	if page.Before != 0 {
+		descending = !descending
+	}
+
+	selected := []T{}
+	for _, row := range rows {
+		k := key(row)
+		switch {
+		case page.After != 0 && !page.Descending && k <= page.After:
+		case page.After != 0 && page.Descending && k >= page.After:
+		case page.Before != 0 && !page.Descending && k >= page.Before:
+		case page.Before != 0 && page.Descending && k <= page.Before:
+		default:
+			selected = append(selected, row)
+		}
+	}
+	sort.Slice(selected, func(i, j int) bool {
+		if descending {
+			return key(selected[i]) > key(selected[j])
+		}
+		return key(selected[i]) < key(selected[j])
+	})
+
+	hasMore := len(selected) > page.Limit
+	if hasMore {
+		selected = selected[:page.Limit]
+	}
+	if page.Before != 0 {
+		for i, j := 0, len(selected)-1; i < j; i, j = i+1, j-1 {
+			selected[i], selected[j] = selected[j], selected[i]
+		}
+	}
+	return selected, hasMore
+}
+
+func deletedNow() *time.Time {
+	now := time.Now()
+	return &now
+}
@@ -0,0 +1,140 @@
+package memory
+
+import (
+	"chat-system/internal/database"
+	"chat-system/internal/models"
+	"time"
+)
+
+func (s *Store) InsertTaskStatus(taskId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	createdAt := now
+	if previous, ok := s.taskStatuses[taskId]; ok {
+		createdAt = previous.CreatedAt
+	}
+	s.taskStatuses[taskId] = models.TaskStatus{
+		TaskId:    taskId,
+		Status:    models.TaskPending,
+		CreatedAt: createdAt,
+		UpdatedAt: now,
+		ExpiresAt: now.Add(s.taskStatusRetention),
+	}
+	return nil
+}
+
+// ResetTaskStatus puts the status of a replayed task back to pending, as
+// MySQLQueue does when it replays a dead letter. A missing status stays
+// missing.
+func (s *Store) ResetTaskStatus(taskId string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taskStatus, ok := s.taskStatuses[taskId]
+	if !ok {
+		return nil
+	}
+	taskStatus.Status = models.TaskPending
+	taskStatus.Result = nil
+	taskStatus.Error = ""
+	taskStatus.ErrorKind = ""
+	taskStatus.UpdatedAt = time.Now()
+	s.taskStatuses[taskId] = taskStatus
+	return nil
+}
+
+// SetTaskStatus keeps completed and failed statuses final, like the MySQL handler.
+func (s *Store) SetTaskStatus(taskId string, status string, result []byte, errorMessage string, errorKind string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	taskStatus, ok := s.taskStatuses[taskId]
+	if !ok {
+		taskStatus = models.TaskStatus{TaskId: taskId, CreatedAt: now}
+	} else if taskStatus.Status == models.TaskCompleted || taskStatus.Status == models.TaskFailed {
+		return nil
+	}
+	taskStatus.Status = status
+	taskStatus.Result = append([]byte(nil), result...)
+	taskStatus.Error = errorMessage
+	taskStatus.ErrorKind = errorKind
+	taskStatus.UpdatedAt = now
+	taskStatus.ExpiresAt = now.Add(s.taskStatusRetention)
+	s.taskStatuses[taskId] = taskStatus
+	return nil
+}
+
+func (s *Store) GetTaskStatus(taskId string) (models.TaskStatus, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	taskStatus, ok := s.taskStatuses[taskId]
+	if !ok || !taskStatus.ExpiresAt.After(time.Now()) {
+		return models.TaskStatus{}, &database.NotFoundError{Resource: "task"}
+	}
+	return taskStatus, nil
+}
+
+func (s *Store) DeleteExpiredTaskStatuses() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for taskId, taskStatus := range s.taskStatuses {
+		if !taskStatus.ExpiresAt.After(now) {
+			delete(s.taskStatuses, taskId)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
+
+// ReserveIdempotencyKey claims key for taskId within scope, or returns the
+// record of the earlier request holding it.
+func (s *Store) ReserveIdempotencyKey(scope string, key string, requestHash string, taskId string) (models.IdempotencyKey, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	id := idempotencyScope{scope: scope, key: key}
+	if existing, ok := s.idempotencyKeys[id]; ok && existing.ExpiresAt.After(now) {
+		return existing, nil
+	}
+	idempotencyKey := models.IdempotencyKey{
+		Scope:       scope,
+		Key:         key,
+		RequestHash: requestHash,
+		TaskId:      taskId,
+		CreatedAt:   now,
+		ExpiresAt:   now.Add(s.idempotencyKeyTTL),
+	}
+	s.idempotencyKeys[id] = idempotencyKey
+	return idempotencyKey, nil
+}
+
+func (s *Store) DeleteIdempotencyKey(scope string, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	delete(s.idempotencyKeys, idempotencyScope{scope: scope, key: key})
+	return nil
+}
+
+func (s *Store) DeleteExpiredIdempotencyKeys() (int64, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var deleted int64
+	now := time.Now()
+	for id, idempotencyKey := range s.idempotencyKeys {
+		if !idempotencyKey.ExpiresAt.After(now) {
+			delete(s.idempotencyKeys, id)
+			deleted++
+		}
+	}
+	return deleted, nil
+}
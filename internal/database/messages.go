@@ -1,38 +1,40 @@
 package database
 
 import (
-	"bytes"
 	"chat-system/internal/models"
-	"encoding/json"
+	"chat-system/internal/search"
+	"context"
 	"fmt"
+	"strings"
+	"time"
 
 	"github.com/jmoiron/sqlx"
 )
 
 type MessagesDatabaseHandler struct {
 	database *sqlx.DB
+	searcher search.MessageSearcher
 }
 
-func NewMessagesDatabaseHandler() *MessagesDatabaseHandler {
-	return &MessagesDatabaseHandler{database: DATABASE}
+func NewMessagesDatabaseHandler(searcher search.MessageSearcher) *MessagesDatabaseHandler {
+	return &MessagesDatabaseHandler{database: DATABASE, searcher: searcher}
 }
 
 func (r *MessagesDatabaseHandler) InsertMessage(chatId int64, body string) (int64, error) {
-	var messageNumber int64
-
-	tx := r.database.MustBegin()
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return 0, fmt.Errorf("failed to begin transaction: %w", err)
+	}
 	defer tx.Rollback()
 
-	err := tx.Get(&messageNumber, `SELECT COALESCE(MAX(number), 0) FROM Messages WHERE chat_id = ? FOR UPDATE`, chatId)
+	messageNumber, err := nextNumber(tx, "Chats", "next_message_number", "messages_count", chatId, "chat")
 	if err != nil {
-		return 0, fmt.Errorf("failed to fetch max message number: %w", err)
+		return 0, err
 	}
 
-	messageNumber++
-
 	result, err := tx.Exec(`INSERT INTO Messages (chat_id, body, number) VALUES (?, ?,?)`, chatId, body, messageNumber)
 	if err != nil {
-		return 0, fmt.Errorf("failed to insert new message: %w", err)
+		return 0, fmt.Errorf("failed to insert new message: %w", domainError("message", err))
 	}
 
 	messageId, err := result.LastInsertId()
@@ -40,65 +42,80 @@ func (r *MessagesDatabaseHandler) InsertMessage(chatId int64, body string) (int6
 		return 0, fmt.Errorf("failed to fetch last insert ID: %w", err)
 	}
 
+	inserted, err := getIndexedMessage(tx, messageId)
+	if err != nil {
+		return 0, err
+	}
+
+	entryId, err := addToOutbox(tx, chatId, messageId)
+	if err != nil {
+		return 0, err
+	}
+
 	err = tx.Commit()
 	if err != nil {
 		return 0, fmt.Errorf("failed to commit transaction: %w", err)
 	}
 
 	//elastic
-	err = r.indexMessage(chatId, messageNumber, messageId, body)
-	if err != nil {
-		return 0, fmt.Errorf("failed to index message: %w", err)
-	}
+	publishMessage(r.database, r.searcher, entryId, inserted)
 
 	return messageNumber, nil
 }
 func (r *MessagesDatabaseHandler) GetMessageByChatIdAndMessageNumber(chatId int64, messageNumber int64) (models.Message, error) {
 	message := models.Message{}
-	query := "SELECT * FROM Messages WHERE chat_id = ? AND number = ?"
+	query := "SELECT * FROM Messages WHERE chat_id = ? AND number = ? AND deleted_at IS NULL"
 	err := r.database.Get(&message, query, chatId, messageNumber)
 	if err != nil {
-		return models.Message{}, fmt.Errorf("failed to get message: %w", err)
+		return models.Message{}, fmt.Errorf("failed to get message: %w", domainError("message", err))
 	}
 	return message, nil
 }
 
-func (r *MessagesDatabaseHandler) GetAllMessagesForAChat(chatId int64) ([]models.Message, error) {
+// GetAllMessagesForAChat returns one page of a chat's messages keyed on
+// number, and whether more messages follow in the direction of the page.
+func (r *MessagesDatabaseHandler) GetAllMessagesForAChat(chatId int64, page models.PageQuery) ([]models.Message, bool, error) {
 	allMessages := []models.Message{}
-	query := "SELECT * FROM Messages WHERE chat_id = ?"
-	err := r.database.Select(&allMessages, query, chatId)
+	condition, orderLimit, args := pageClause("number", page)
+	query := "SELECT * FROM Messages WHERE chat_id = ? AND deleted_at IS NULL AND " + condition + " " + orderLimit
+	err := r.database.Select(&allMessages, query, append([]interface{}{chatId}, args...)...)
 	if err != nil {
-		return []models.Message{}, fmt.Errorf("failed to get messages: %w", err)
+		return []models.Message{}, false, fmt.Errorf("failed to get messages: %w", err)
 	}
-	return allMessages, nil
+	allMessages, hasMore := trimPage(allMessages, page)
+	return allMessages, hasMore, nil
 }
 
 func (r *MessagesDatabaseHandler) UpdateMessageBody(chatId int64, messageNumber int64, newBody string) (models.Message, error) {
-	updatedMessage := models.Message{}
+	updatedMessage := models.IndexedMessage{}
 	query := `
         UPDATE Messages
-        SET body = ?
-        WHERE chat_id = ? AND number = ?
+        SET body = ?, version = version + 1
+        WHERE chat_id = ? AND number = ? AND deleted_at IS NULL
     `
 
-	tx := r.database.MustBegin()
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return models.Message{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
 	defer tx.Rollback()
 
-	_, err := tx.Exec(query, newBody, chatId, messageNumber)
+	_, err = tx.Exec(query, newBody, chatId, messageNumber)
 	if err != nil {
 		tx.Rollback()
-		return models.Message{}, fmt.Errorf("failed to update message subject: %w", err)
+		return models.Message{}, fmt.Errorf("failed to update message body: %w", domainError("message", err))
 	}
 
-	fetchQuery := `
-        SELECT *
-        FROM Messages
-        WHERE chat_id = ? AND number = ?    
-		`
+	fetchQuery := indexedMessagesQuery + " WHERE m.chat_id = ? AND m.number = ? AND m.deleted_at IS NULL"
 	err = tx.Get(&updatedMessage, fetchQuery, chatId, messageNumber)
 	if err != nil {
 		tx.Rollback()
-		return models.Message{}, fmt.Errorf("failed to fetch updated chat: %w", err)
+		return models.Message{}, fmt.Errorf("failed to fetch updated message: %w", domainError("message", err))
+	}
+
+	entryId, err := addToOutbox(tx, chatId, updatedMessage.Id)
+	if err != nil {
+		return models.Message{}, err
 	}
 
 	if err := tx.Commit(); err != nil {
@@ -106,31 +123,212 @@ func (r *MessagesDatabaseHandler) UpdateMessageBody(chatId int64, messageNumber
 	}
 
 	//elastic
-	err = r.indexMessage(updatedMessage.ChatId, updatedMessage.Number, updatedMessage.Id, updatedMessage.Body)
+	publishMessage(r.database, r.searcher, entryId, updatedMessage)
+
+	return updatedMessage.Message, nil
+}
+
+// DeleteMessage soft deletes a message, decrements its chat's messages_count
+// and drops it from the search index through the outbox. It returns a
+// NotFoundError when the message does not exist.
+func (r *MessagesDatabaseHandler) DeleteMessage(chatId int64, messageNumber int64) error {
+	tx, err := r.database.Beginx()
 	if err != nil {
-		return models.Message{}, fmt.Errorf("failed to index message: %w", err)
+		return fmt.Errorf("failed to begin transaction: %w", err)
 	}
+	defer tx.Rollback()
 
-	return updatedMessage, nil
-}
-func (r *MessagesDatabaseHandler) indexMessage(chatId int64, messageNumber int64, messageId int64, body string) error {
-	doc := map[string]interface{}{
-		"chat_id":    chatId,
-		"message_id": messageId,
-		"body":       body,
-		"number":     messageId,
+	var messageId int64
+	err = tx.Get(&messageId, "SELECT id FROM Messages WHERE chat_id = ? AND number = ? AND deleted_at IS NULL FOR UPDATE", chatId, messageNumber)
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", domainError("message", err))
 	}
 
-	data, _ := json.Marshal(doc)
+	_, err = tx.Exec("UPDATE Messages SET deleted_at = NOW(), version = version + 1 WHERE id = ?", messageId)
+	if err != nil {
+		return fmt.Errorf("failed to delete message: %w", err)
+	}
 
-	res, err := ESClient.Index(
-		"messages", // Index name
-		bytes.NewReader(data),
-		ESClient.Index.WithDocumentID(fmt.Sprintf("%d-%d", chatId, messageId)), // Unique ID
-	)
+	_, err = tx.Exec("UPDATE Chats SET messages_count = GREATEST(messages_count - 1, 0) WHERE id = ?", chatId)
+	if err != nil {
+		return fmt.Errorf("failed to update messages_count: %w", err)
+	}
+
+	deletedMessage, err := getIndexedMessage(tx, messageId)
 	if err != nil {
 		return err
 	}
-	defer res.Body.Close()
+
+	entryId, err := addToOutbox(tx, chatId, messageId)
+	if err != nil {
+		return err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	//elastic
+	publishMessage(r.database, r.searcher, entryId, deletedMessage)
+
 	return nil
 }
+
+// RestoreMessage brings back a soft deleted message, increments its chat's
+// messages_count and indexes it again through the outbox. It returns a
+// NotFoundError when there is no deleted message with that number.
+func (r *MessagesDatabaseHandler) RestoreMessage(chatId int64, messageNumber int64) (models.Message, error) {
+	restoredMessage := models.Message{}
+
+	tx, err := r.database.Beginx()
+	if err != nil {
+		return models.Message{}, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	err = tx.Get(&restoredMessage, "SELECT * FROM Messages WHERE chat_id = ? AND number = ? AND deleted_at IS NOT NULL FOR UPDATE", chatId, messageNumber)
+	if err != nil {
+		return models.Message{}, fmt.Errorf("failed to get deleted message: %w", domainError("deleted message", err))
+	}
+
+	_, err = tx.Exec("UPDATE Messages SET deleted_at = NULL, version = version + 1 WHERE id = ?", restoredMessage.Id)
+	if err != nil {
+		return models.Message{}, fmt.Errorf("failed to restore message: %w", err)
+	}
+
+	_, err = tx.Exec("UPDATE Chats SET messages_count = messages_count + 1 WHERE id = ?", chatId)
+	if err != nil {
+		return models.Message{}, fmt.Errorf("failed to update messages_count: %w", err)
+	}
+
+	indexedMessage, err := getIndexedMessage(tx, restoredMessage.Id)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	entryId, err := addToOutbox(tx, chatId, restoredMessage.Id)
+	if err != nil {
+		return models.Message{}, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return models.Message{}, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	//elastic
+	publishMessage(r.database, r.searcher, entryId, indexedMessage)
+
+	return indexedMessage.Message, nil
+}
+
+// PurgeDeletedMessages permanently removes messages deleted more than
+// retention ago and returns how many were purged. They already left the
+// search index when they were deleted.
+func (r *MessagesDatabaseHandler) PurgeDeletedMessages(retention time.Duration) (int64, error) {
+	result, err := r.database.Exec("DELETE FROM Messages WHERE deleted_at < NOW() - INTERVAL ? SECOND", int64(retention.Seconds()))
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge messages: %w", err)
+	}
+	purged, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count purged messages: %w", err)
+	}
+	return purged, nil
+}
+
+// IndexMessage writes the current state of a stored message to the search index.
+func (r *MessagesDatabaseHandler) IndexMessage(chatId int64, messageNumber int64) error {
+	message := models.IndexedMessage{}
+	query := indexedMessagesQuery + " WHERE m.chat_id = ? AND m.number = ? AND m.deleted_at IS NULL"
+	err := r.database.Get(&message, query, chatId, messageNumber)
+	if err != nil {
+		return fmt.Errorf("failed to get message: %w", domainError("message", err))
+	}
+	err = r.searcher.IndexMessage(context.Background(), MessageDocument(message))
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrIndexMessage, err)
+	}
+	return nil
+}
+
+// GetMessagesForIndexing returns up to limit messages of scope with ids above
+// afterId, in id order. Soft deleted messages are included for the caller to
+// drop them from the index.
+func (r *MessagesDatabaseHandler) GetMessagesForIndexing(scope models.IndexScope, afterId int64, limit int) ([]models.IndexedMessage, error) {
+	messages := []models.IndexedMessage{}
+	condition, args := indexScopeClause(scope)
+	query := indexedMessagesQuery + " WHERE m.id > ? AND " + condition + " ORDER BY m.id LIMIT ?"
+	err := r.database.Select(&messages, query, append(append([]interface{}{afterId}, args...), limit)...)
+	if err != nil {
+		return []models.IndexedMessage{}, fmt.Errorf("failed to get messages to index: %w", err)
+	}
+	return messages, nil
+}
+
+func (r *MessagesDatabaseHandler) CountMessagesForIndexing(scope models.IndexScope) (int64, error) {
+	var count int64
+	condition, args := indexScopeClause(scope)
+	err := r.database.Get(&count, "SELECT COUNT(*) FROM Messages m WHERE "+condition, args...)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count messages to index: %w", err)
+	}
+	return count, nil
+}
+
+// IndexCheckpoint returns the clock of the database, for a later reindex to
+// pick up the messages changed from then on.
+func (r *MessagesDatabaseHandler) IndexCheckpoint() (time.Time, error) {
+	var now time.Time
+	if err := r.database.Get(&now, "SELECT NOW()"); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the database clock: %w", err)
+	}
+	return now, nil
+}
+
+// indexScopeClause selects the messages of scope from Messages m.
+func indexScopeClause(scope models.IndexScope) (string, []interface{}) {
+	conditions := []string{"TRUE"}
+	args := []interface{}{}
+	if scope.ChatId != 0 {
+		conditions = append(conditions, "m.chat_id = ?")
+		args = append(args, scope.ChatId)
+	}
+	if scope.ApplicationId != 0 {
+		conditions = append(conditions, "m.chat_id IN (SELECT id FROM Chats WHERE application_id = ?)")
+		args = append(args, scope.ApplicationId)
+	}
+	if !scope.UpdatedSince.IsZero() {
+		conditions = append(conditions, "(m.updated_at >= ? OR m.chat_id IN (SELECT id FROM Chats WHERE updated_at >= ?))")
+		args = append(args, scope.UpdatedSince, scope.UpdatedSince)
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+// indexedMessagesQuery selects messages, as m, along with the details of
+// their chats that their search documents hold.
+const indexedMessagesQuery = "SELECT m.*, c.application_id, c.number AS chat_number, c.deleted_at AS chat_deleted_at FROM Messages m JOIN Chats c ON c.id = m.chat_id"
+
+func getIndexedMessage(tx *sqlx.Tx, messageId int64) (models.IndexedMessage, error) {
+	message := models.IndexedMessage{}
+	err := tx.Get(&message, indexedMessagesQuery+" WHERE m.id = ?", messageId)
+	if err != nil {
+		return models.IndexedMessage{}, fmt.Errorf("failed to fetch message to index: %w", err)
+	}
+	return message, nil
+}
+
+// MessageDocument is the search document of a message, versioned by the
+// version of the message.
+func MessageDocument(message models.IndexedMessage) search.MessageDocument {
+	return search.MessageDocument{
+		ApplicationId: message.ApplicationId,
+		ChatId:        message.ChatId,
+		ChatNumber:    message.ChatNumber,
+		MessageId:     message.Id,
+		Number:        message.Number,
+		Body:          message.Body,
+		CreatedAt:     message.CreatedAt,
+		UpdatedAt:     message.UpdatedAt,
+		Version:       message.Version,
+	}
+}
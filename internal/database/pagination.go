@@ -0,0 +1,51 @@
+package database
+
+import (
+	"chat-system/internal/models"
+	"fmt"
+)
+
+// pageClause turns a page query into a condition on keyColumn, an ORDER BY
+// and a LIMIT that fetches one extra row to tell whether more rows follow.
+// Paging backwards with Before scans in the opposite order; reversePage puts
+// the rows back in the requested order afterwards.
+func pageClause(keyColumn string, page models.PageQuery) (condition string, orderLimit string, args []interface{}) {
+	descending := page.Descending
+	if page.Before != 0 {
+		descending = !descending
+	}
+
+	condition = "1 = 1"
+	switch {
+	case page.After != 0 && !page.Descending:
+		condition, args = keyColumn+" > ?", []interface{}{page.After}
+	case page.After != 0:
+		condition, args = keyColumn+" < ?", []interface{}{page.After}
+	case page.Before != 0 && !page.Descending:
+		condition, args = keyColumn+" < ?", []interface{}{page.Before}
+	case page.Before != 0:
+		condition, args = keyColumn+" > ?", []interface{}{page.Before}
+	}
+
+	direction := "ASC"
+	if descending {
+		direction = "DESC"
+	}
+	orderLimit = fmt.Sprintf("ORDER BY %s %s LIMIT %d", keyColumn, direction, page.Limit+1)
+	return condition, orderLimit, args
+}
+
+// trimPage drops the extra row fetched by pageClause, reports whether it was
+// there and restores the requested order when paging backwards.
+func trimPage[T any](rows []T, page models.PageQuery) ([]T, bool) {
+	hasMore := len(rows) > page.Limit
+	if hasMore {
+		rows = rows[:page.Limit]
+	}
+	if page.Before != 0 {
+		for i, j := 0, len(rows)-1; i < j; i, j = i+1, j-1 {
+			rows[i], rows[j] = rows[j], rows[i]
+		}
+	}
+	return rows, hasMore
+}
@@ -0,0 +1,275 @@
+package database
+
+import (
+	"chat-system/internal/models"
+	"chat-system/internal/search"
+	"context"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// SearchOutboxDatabaseHandler is the search.Outbox of the messages and chats
+// stored in MySQL.
+type SearchOutboxDatabaseHandler struct {
+	database *sqlx.DB
+}
+
+func NewSearchOutboxDatabaseHandler() *SearchOutboxDatabaseHandler {
+	return &SearchOutboxDatabaseHandler{database: DATABASE}
+}
+
+func (r *SearchOutboxDatabaseHandler) ClaimOutboxEntries(ctx context.Context, limit int, lease time.Duration) ([]search.OutboxEntry, error) {
+	tx, err := r.database.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	rows := []struct {
+		Id        int64 `db:"id"`
+		ChatId    int64 `db:"chat_id"`
+		MessageId int64 `db:"message_id"`
+		Attempts  int   `db:"attempts"`
+	}{}
+	selectQuery := `
+        SELECT id, chat_id, COALESCE(message_id, 0) AS message_id, attempts
+        FROM SearchOutbox
+        WHERE available_at <= NOW(6)
+        ORDER BY id
+        LIMIT ?
+        FOR UPDATE SKIP LOCKED
+    `
+	err = tx.SelectContext(ctx, &rows, selectQuery, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to select outbox entries: %w", err)
+	}
+	if len(rows) == 0 {
+		return nil, nil
+	}
+
+	ids := make([]int64, 0, len(rows))
+	messageIds := []int64{}
+	chatIds := []int64{}
+	for _, row := range rows {
+		ids = append(ids, row.Id)
+		if row.MessageId == 0 {
+			chatIds = append(chatIds, row.ChatId)
+		} else {
+			messageIds = append(messageIds, row.MessageId)
+		}
+	}
+	query, args, err := sqlx.In("UPDATE SearchOutbox SET attempts = attempts + 1, available_at = NOW(6) + INTERVAL ? MICROSECOND WHERE id IN (?)", lease.Microseconds(), ids)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build claim query: %w", err)
+	}
+	_, err = tx.ExecContext(ctx, query, args...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim outbox entries: %w", err)
+	}
+
+	// The messages and chats are read as they are now, so entries published
+	// late or twice never bring back an older version
+	messages := []models.IndexedMessage{}
+	if len(messageIds) > 0 {
+		query, args, err = sqlx.In(indexedMessagesQuery+" WHERE m.id IN (?)", messageIds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build messages query: %w", err)
+		}
+		err = tx.SelectContext(ctx, &messages, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get outbox messages: %w", err)
+		}
+	}
+	chats := []models.Chat{}
+	if len(chatIds) > 0 {
+		query, args, err = sqlx.In("SELECT * FROM Chats WHERE id IN (?)", chatIds)
+		if err != nil {
+			return nil, fmt.Errorf("failed to build chats query: %w", err)
+		}
+		err = tx.SelectContext(ctx, &chats, query, args...)
+		if err != nil {
+			return nil, fmt.Errorf("failed to get outbox chats: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	stored := map[int64]models.IndexedMessage{}
+	for _, message := range messages {
+		stored[message.Id] = message
+	}
+	storedChats := map[int64]models.Chat{}
+	for _, chat := range chats {
+		storedChats[chat.Id] = chat
+	}
+	entries := make([]search.OutboxEntry, 0, len(rows))
+	for _, row := range rows {
+		if row.MessageId == 0 {
+			entries = append(entries, ChatOutboxEntry(row.Id, row.Attempts+1, row.ChatId, storedChats))
+		} else {
+			entries = append(entries, OutboxEntry(row.Id, row.Attempts+1, row.ChatId, row.MessageId, stored))
+		}
+	}
+	return entries, nil
+}
+
+func (r *SearchOutboxDatabaseHandler) DeleteOutboxEntries(ctx context.Context, ids []int64) error {
+	query, args, err := sqlx.In("DELETE FROM SearchOutbox WHERE id IN (?)", ids)
+	if err != nil {
+		return fmt.Errorf("failed to build delete query: %w", err)
+	}
+	_, err = r.database.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to delete outbox entries: %w", err)
+	}
+	return nil
+}
+
+func (r *SearchOutboxDatabaseHandler) RetryOutboxEntries(ctx context.Context, ids []int64, delay time.Duration, lastError string) error {
+	query, args, err := sqlx.In("UPDATE SearchOutbox SET last_error = ?, available_at = NOW(6) + INTERVAL ? MICROSECOND WHERE id IN (?)", lastError, delay.Microseconds(), ids)
+	if err != nil {
+		return fmt.Errorf("failed to build retry query: %w", err)
+	}
+	_, err = r.database.ExecContext(ctx, query, args...)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule outbox entries: %w", err)
+	}
+	return nil
+}
+
+// OutboxEntry builds the entry of a message from stored, the messages of a
+// batch of entries by id. Messages missing from stored were purged.
+func OutboxEntry(id int64, attempts int, chatId int64, messageId int64, stored map[int64]models.IndexedMessage) search.OutboxEntry {
+	message, ok := stored[messageId]
+	if !ok {
+		return search.OutboxEntry{
+			Id:       id,
+			Attempts: attempts,
+			Document: search.MessageDocument{ChatId: chatId, MessageId: messageId},
+			Deleted:  true,
+		}
+	}
+	return search.OutboxEntry{
+		Id:       id,
+		Attempts: attempts,
+		Document: MessageDocument(message),
+		Deleted:  !message.Searchable(),
+	}
+}
+
+// ChatOutboxEntry builds the entry of the document of a chat from stored, the
+// chats of a batch of entries by id. Chats missing from stored were purged.
+func ChatOutboxEntry(id int64, attempts int, chatId int64, stored map[int64]models.Chat) search.OutboxEntry {
+	chat, ok := stored[chatId]
+	if !ok {
+		return search.OutboxEntry{
+			Id:       id,
+			Attempts: attempts,
+			Chat:     &search.ChatDocument{ChatId: chatId},
+			Deleted:  true,
+		}
+	}
+	doc := ChatDocument(chat)
+	return search.OutboxEntry{
+		Id:       id,
+		Attempts: attempts,
+		Chat:     &doc,
+		Deleted:  chat.DeletedAt != nil,
+	}
+}
+
+// addToOutbox records, in the transaction changing a message, that its search
+// document is out of date. The entry is left to the caller for
+// search.OutboxGrace.
+func addToOutbox(tx *sqlx.Tx, chatId int64, messageId int64) (int64, error) {
+	return insertOutboxEntry(tx, chatId, &messageId)
+}
+
+// addChatToOutbox records, in the transaction changing a chat, that the
+// search document of its subject is out of date, like addToOutbox.
+func addChatToOutbox(tx *sqlx.Tx, chatId int64) (int64, error) {
+	return insertOutboxEntry(tx, chatId, nil)
+}
+
+// addChatMessagesToOutbox records, in the transaction deleting or restoring
+// a chat, that the search documents of its live messages are out of date:
+// they leave the index with the chat and come back with it. The entries are
+// left to the relay.
+func addChatMessagesToOutbox(tx *sqlx.Tx, chatId int64) error {
+	query := `
+        INSERT INTO SearchOutbox (chat_id, message_id, available_at)
+        SELECT chat_id, id, NOW(6) FROM Messages WHERE chat_id = ? AND deleted_at IS NULL
+    `
+	if _, err := tx.Exec(query, chatId); err != nil {
+		return fmt.Errorf("failed to add chat messages to search outbox: %w", err)
+	}
+	return nil
+}
+
+func insertOutboxEntry(tx *sqlx.Tx, chatId int64, messageId *int64) (int64, error) {
+	query := `
+        INSERT INTO SearchOutbox (chat_id, message_id, available_at)
+        VALUES (?, ?, NOW(6) + INTERVAL ? MICROSECOND)
+    `
+	result, err := tx.Exec(query, chatId, messageId, search.OutboxGrace.Microseconds())
+	if err != nil {
+		return 0, fmt.Errorf("failed to add to search outbox: %w", err)
+	}
+	entryId, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch outbox entry ID: %w", err)
+	}
+	return entryId, nil
+}
+
+// publishTimeout bounds the write to the search index that a request makes
+// after its commit, so that a hung node cannot hold the request
+const publishTimeout = 2 * time.Second
+
+// publishMessage writes a committed message to the search index and clears
+// its outbox entry. When the index cannot be reached within publishTimeout
+// the entry is left for the relay, and the write still succeeds.
+func publishMessage(database *sqlx.DB, searcher search.MessageSearcher, entryId int64, message models.IndexedMessage) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	var err error
+	if !message.Searchable() {
+		err = searcher.DeleteMessage(ctx, MessageDocument(message))
+	} else {
+		err = searcher.IndexMessage(ctx, MessageDocument(message))
+	}
+	if err != nil {
+		log.Printf("error indexing message %d, leaving it to the search outbox: %v", message.Id, err)
+		return
+	}
+	clearOutboxEntry(database, entryId)
+}
+
+// publishChat writes the subject of a committed chat to the search index and
+// clears its outbox entry, like publishMessage.
+func publishChat(database *sqlx.DB, searcher search.MessageSearcher, entryId int64, chat models.Chat) {
+	ctx, cancel := context.WithTimeout(context.Background(), publishTimeout)
+	defer cancel()
+	var err error
+	if chat.DeletedAt != nil {
+		err = searcher.DeleteChat(ctx, ChatDocument(chat))
+	} else {
+		err = searcher.IndexChat(ctx, ChatDocument(chat))
+	}
+	if err != nil {
+		log.Printf("error indexing chat %d, leaving it to the search outbox: %v", chat.Id, err)
+		return
+	}
+	clearOutboxEntry(database, entryId)
+}
+
+func clearOutboxEntry(database *sqlx.DB, entryId int64) {
+	if _, err := database.ExecContext(context.Background(), "DELETE FROM SearchOutbox WHERE id = ?", entryId); err != nil {
+		log.Printf("error clearing search outbox entry %d: %v", entryId, err)
+	}
+}
@@ -0,0 +1,36 @@
+package database
+
+import (
+	"fmt"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// nextNumber allocates the number held by the counter column of row id of
+// table, the parent of the rows being numbered, and bumps the counter, all in
+// one statement: LAST_INSERT_ID(expr) hands the value it read back to the
+// client. The same statement counts the new row in countColumn. The row stays
+// locked until tx ends, so inserts under one parent queue on a single row for
+// the length of their transaction instead of locking the range of numbered
+// rows, and a rolled back insert gives its number back and uncounts itself,
+// which keeps the numbers free of gaps. A parent that does not exist or is
+// soft deleted is a NotFoundError.
+func nextNumber(tx *sqlx.Tx, table string, column string, countColumn string, id int64, parent string) (int64, error) {
+	query := fmt.Sprintf("UPDATE %s SET %s = LAST_INSERT_ID(%s) + 1, %s = %s + 1 WHERE id = ? AND deleted_at IS NULL", table, column, column, countColumn, countColumn)
+	result, err := tx.Exec(query, id)
+	if err != nil {
+		return 0, fmt.Errorf("failed to allocate number: %w", err)
+	}
+	updated, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count updated counters: %w", err)
+	}
+	if updated == 0 {
+		return 0, fmt.Errorf("failed to allocate number: %w", &NotFoundError{Resource: parent})
+	}
+	number, err := result.LastInsertId()
+	if err != nil {
+		return 0, fmt.Errorf("failed to fetch allocated number: %w", err)
+	}
+	return number, nil
+}
@@ -0,0 +1,114 @@
+package database
+
+import (
+	"chat-system/internal/config"
+	"chat-system/internal/search"
+	"fmt"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// benchmarkConcurrency is the number of writers per CPU of the benchmarks,
+// far more than the workers of a single instance.
+const benchmarkConcurrency = 16
+
+// openBenchmarkDatabase connects to the migrated MySQL database of the DB_*
+// variables, as the server does, and skips the benchmark when DB_HOST is
+// unset.
+func openBenchmarkDatabase(b *testing.B) {
+	b.Helper()
+	if os.Getenv("DB_HOST") == "" {
+		b.Skip("DB_HOST is not set, skipping MySQL benchmark")
+	}
+	cfg, err := config.Load()
+	if err != nil {
+		b.Fatalf("loading configuration: %v", err)
+	}
+	cfg.Database.MaxOpenConns = benchmarkConcurrency * 4
+	cfg.Database.MaxIdleConns = benchmarkConcurrency * 4
+	if err := InitDB(cfg.Database); err != nil {
+		b.Fatalf("connecting to MySQL: %v", err)
+	}
+}
+
+// createBenchmarkChats creates an application with count chats and returns
+// the ids of the chats.
+func createBenchmarkChats(b *testing.B, searcher search.MessageSearcher, count int) []int64 {
+	b.Helper()
+	applications := NewApplicationsDatabaseHandler(searcher)
+	chats := NewChatsDatabaseHandler(searcher)
+
+	token := fmt.Sprintf("benchmark-%d", time.Now().UnixNano())
+	if err := applications.InsertApplication("benchmark", token); err != nil {
+		b.Fatalf("creating application: %v", err)
+	}
+	appId, err := applications.GetApplicationIdByToken(token)
+	if err != nil {
+		b.Fatalf("looking up application: %v", err)
+	}
+	chatIds := make([]int64, 0, count)
+	for i := 0; i < count; i++ {
+		chatNumber, err := chats.InsertChat(appId, "benchmark")
+		if err != nil {
+			b.Fatalf("creating chat: %v", err)
+		}
+		chatId, err := chats.GetChatIdByAppIdAndChatNumber(appId, chatNumber)
+		if err != nil {
+			b.Fatalf("looking up chat: %v", err)
+		}
+		chatIds = append(chatIds, chatId)
+	}
+	return chatIds
+}
+
+// benchmarkInsertMessages inserts messages from many concurrent writers
+// spread over the chats, and reports the messages inserted per second and
+// the 50th and 99th percentiles of the time an insert takes, which grow with
+// the time writers wait on the row of their chat.
+func benchmarkInsertMessages(b *testing.B, chatCount int) {
+	openBenchmarkDatabase(b)
+	searcher := search.NewMemorySearcher()
+	chatIds := createBenchmarkChats(b, searcher, chatCount)
+	messages := NewMessagesDatabaseHandler(searcher)
+
+	var writer atomic.Int64
+	var mu sync.Mutex
+	latencies := make([]time.Duration, 0, b.N)
+	b.SetParallelism(benchmarkConcurrency)
+	b.ResetTimer()
+	b.RunParallel(func(pb *testing.PB) {
+		chatId := chatIds[int(writer.Add(1))%len(chatIds)]
+		own := []time.Duration{}
+		for pb.Next() {
+			start := time.Now()
+			if _, err := messages.InsertMessage(chatId, "benchmark message"); err != nil {
+				b.Errorf("inserting message: %v", err)
+				return
+			}
+			own = append(own, time.Since(start))
+		}
+		mu.Lock()
+		latencies = append(latencies, own...)
+		mu.Unlock()
+	})
+	b.ReportMetric(float64(b.N)/b.Elapsed().Seconds(), "messages/s")
+	if len(latencies) > 0 {
+		slices.Sort(latencies)
+		b.ReportMetric(float64(latencies[len(latencies)/2].Microseconds())/1000, "p50-ms")
+		b.ReportMetric(float64(latencies[len(latencies)*99/100].Microseconds())/1000, "p99-ms")
+	}
+}
+
+// BenchmarkInsertMessageOneChat has every writer insert into the same chat,
+// the worst case for number allocation.
+func BenchmarkInsertMessageOneChat(b *testing.B) {
+	benchmarkInsertMessages(b, 1)
+}
+
+func BenchmarkInsertMessageManyChats(b *testing.B) {
+	benchmarkInsertMessages(b, 64)
+}
@@ -0,0 +1,85 @@
+package database
+
+import (
+	"chat-system/internal/models"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type TaskStatusesDatabaseHandler struct {
+	database  *sqlx.DB
+	retention time.Duration
+}
+
+// NewTaskStatusesDatabaseHandler keeps every status for retention after its
+// last change.
+func NewTaskStatusesDatabaseHandler(retention time.Duration) *TaskStatusesDatabaseHandler {
+	return &TaskStatusesDatabaseHandler{database: DATABASE, retention: retention}
+}
+
+// InsertTaskStatus starts tracking a task as pending, resetting any previous
+// status when the task is being replayed.
+func (r *TaskStatusesDatabaseHandler) InsertTaskStatus(taskId string) error {
+	query := `
+        INSERT INTO TaskStatuses (task_id, status, error, expires_at)
+        VALUES (?, ?, '', NOW() + INTERVAL ? SECOND)
+        ON DUPLICATE KEY UPDATE
+            status = VALUES(status),
+            result = NULL,
+            error = '',
+            error_kind = '',
+            expires_at = VALUES(expires_at)
+    `
+	_, err := r.database.Exec(query, taskId, models.TaskPending, int64(r.retention.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to insert task status: %w", err)
+	}
+	return nil
+}
+
+// SetTaskStatus records the new state of a task, creating the row when the
+// task was accepted by an instance that never persisted it. Completed and
+// failed are final: a worker replaying a task after its lease expired cannot
+// move the status backwards. errorKind is the kind of the domain error, if
+// any, that errorMessage is the message of.
+func (r *TaskStatusesDatabaseHandler) SetTaskStatus(taskId string, status string, result []byte, errorMessage string, errorKind string) error {
+	query := `
+        INSERT INTO TaskStatuses (task_id, status, result, error, error_kind, expires_at)
+        VALUES (?, ?, ?, ?, ?, NOW() + INTERVAL ? SECOND)
+        ON DUPLICATE KEY UPDATE
+            result = IF(status IN ('completed', 'failed'), result, VALUES(result)),
+            error = IF(status IN ('completed', 'failed'), error, VALUES(error)),
+            error_kind = IF(status IN ('completed', 'failed'), error_kind, VALUES(error_kind)),
+            expires_at = IF(status IN ('completed', 'failed'), expires_at, VALUES(expires_at)),
+            status = IF(status IN ('completed', 'failed'), status, VALUES(status))
+    `
+	_, err := r.database.Exec(query, taskId, status, result, errorMessage, errorKind, int64(r.retention.Seconds()))
+	if err != nil {
+		return fmt.Errorf("failed to set task status: %w", err)
+	}
+	return nil
+}
+
+func (r *TaskStatusesDatabaseHandler) GetTaskStatus(taskId string) (models.TaskStatus, error) {
+	taskStatus := models.TaskStatus{}
+	query := "SELECT * FROM TaskStatuses WHERE task_id = ? AND expires_at > NOW()"
+	err := r.database.Get(&taskStatus, query, taskId)
+	if err != nil {
+		return models.TaskStatus{}, fmt.Errorf("failed to get task status: %w", domainError("task", err))
+	}
+	return taskStatus, nil
+}
+
+func (r *TaskStatusesDatabaseHandler) DeleteExpiredTaskStatuses() (int64, error) {
+	result, err := r.database.Exec("DELETE FROM TaskStatuses WHERE expires_at <= NOW()")
+	if err != nil {
+		return 0, fmt.Errorf("failed to delete expired task statuses: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count deleted task statuses: %w", err)
+	}
+	return deleted, nil
+}
@@ -0,0 +1,306 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+var (
+	// ErrNotFound is returned when no job has the name asked for.
+	ErrNotFound = errors.New("job not found")
+	// ErrRunning is returned by Trigger when the job is already running, on
+	// this instance or another.
+	ErrRunning = errors.New("job is already running")
+	// ErrStopped is returned by Trigger once the scheduler is stopped.
+	ErrStopped = errors.New("scheduler is stopped")
+)
+
+// Disabled is the schedule of a job that only runs when triggered.
+const Disabled = "off"
+
+// Job is a piece of background work run on a cron schedule, such as
+// "@every 10m" or "0 3 * * *", or Disabled.
+type Job struct {
+	Name     string
+	Schedule string
+	Run      func(ctx context.Context) error
+}
+
+// Run is the outcome of the last run of a job, on any instance.
+type Run struct {
+	Job       string
+	StartedAt time.Time
+	Duration  time.Duration
+	// Error is empty when the run succeeded
+	Error string
+}
+
+// Status describes a registered job.
+type Status struct {
+	Name     string
+	Schedule string
+	Enabled  bool
+	// Running tells whether the job is running on this instance
+	Running bool
+	// NextRun is unset for disabled jobs and before the scheduler starts
+	NextRun *time.Time
+	LastRun *Run
+}
+
+// Locker hands out locks shared by every instance, so that a job runs on one
+// instance at a time.
+type Locker interface {
+	// TryLock takes the lock called name without waiting. It returns false
+	// when someone else holds it, and otherwise the function releasing it.
+	TryLock(ctx context.Context, name string) (func(), bool, error)
+}
+
+// RunStore keeps the last run of each job, so that any instance can report
+// runs made by the others.
+type RunStore interface {
+	RecordJobRun(ctx context.Context, run Run) error
+	GetJobRuns(ctx context.Context) ([]Run, error)
+}
+
+// CheckpointStore keeps where a job left off, so that its next run resumes
+// from there on any instance, even after a restart.
+type CheckpointStore interface {
+	// Now returns the clock checkpoints are taken on, which the rows a job
+	// walks through are stamped with
+	Now(ctx context.Context) (time.Time, error)
+	// GetJobCheckpoint returns the zero time when the job has no checkpoint
+	GetJobCheckpoint(ctx context.Context, job string) (time.Time, error)
+	SetJobCheckpoint(ctx context.Context, job string, checkpoint time.Time) error
+}
+
+// Scheduler is the registry of the background jobs. It runs each on its
+// schedule, or when triggered, under a lock from its Locker, and records the
+// runs in its RunStore. A run due while the job is still running elsewhere is
+// skipped.
+type Scheduler struct {
+	locker Locker
+	runs   RunStore
+	cron   *cron.Cron
+	// ctx is handed to the runs and cancelled when Stop gives up on them
+	ctx     context.Context
+	cancel  context.CancelFunc
+	running sync.WaitGroup
+
+	mu   sync.Mutex
+	jobs []*registeredJob
+	// stopped refuses new runs once Stop waits for the running ones
+	stopped bool
+}
+
+type registeredJob struct {
+	Job
+	entry   cron.EntryID
+	running bool
+}
+
+func NewScheduler(locker Locker, runs RunStore) *Scheduler {
+	ctx, cancel := context.WithCancel(context.Background())
+	return &Scheduler{
+		locker: locker,
+		runs:   runs,
+		cron:   cron.New(),
+		ctx:    ctx,
+		cancel: cancel,
+	}
+}
+
+// Register adds a job to the registry. It is scheduled unless its schedule is
+// Disabled, and can be triggered either way.
+func (s *Scheduler) Register(job Job) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, registered := range s.jobs {
+		if registered.Name == job.Name {
+			return fmt.Errorf("job %s is already registered", job.Name)
+		}
+	}
+	registered := &registeredJob{Job: job}
+	if job.Schedule != Disabled {
+		entry, err := s.cron.AddFunc(job.Schedule, func() { s.runScheduled(registered) })
+		if err != nil {
+			return fmt.Errorf("invalid schedule %q of job %s: %w", job.Schedule, job.Name, err)
+		}
+		registered.entry = entry
+	}
+	s.jobs = append(s.jobs, registered)
+	return nil
+}
+
+func (s *Scheduler) Start() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.cron.Start()
+	log.Printf("started scheduler with %d jobs", len(s.jobs))
+}
+
+// Stop prevents further runs and waits for running jobs until ctx is done,
+// at which point their context is cancelled.
+func (s *Scheduler) Stop(ctx context.Context) error {
+	s.mu.Lock()
+	s.stopped = true
+	s.mu.Unlock()
+
+	scheduled := s.cron.Stop()
+	done := make(chan struct{})
+	go func() {
+		<-scheduled.Done()
+		s.running.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		s.cancel()
+		log.Println("scheduler stopped")
+		return nil
+	case <-ctx.Done():
+		s.cancel()
+		log.Println("gave up waiting for running jobs")
+		return ctx.Err()
+	}
+}
+
+// Trigger starts a run of the job called name right away, and returns once it
+// holds the lock of the job.
+func (s *Scheduler) Trigger(name string) error {
+	job := s.job(name)
+	if job == nil {
+		return ErrNotFound
+	}
+	unlock, err := s.lock(job)
+	if err != nil {
+		return err
+	}
+	go s.run(job, unlock)
+	return nil
+}
+
+// Jobs reports the status of every job, in the order they were registered.
+func (s *Scheduler) Jobs(ctx context.Context) ([]Status, error) {
+	runs, err := s.runs.GetJobRuns(ctx)
+	if err != nil {
+		return nil, err
+	}
+	lastRuns := map[string]Run{}
+	for _, run := range runs {
+		lastRuns[run.Job] = run
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	statuses := make([]Status, 0, len(s.jobs))
+	for _, job := range s.jobs {
+		status := Status{
+			Name:     job.Name,
+			Schedule: job.Schedule,
+			Enabled:  job.Schedule != Disabled,
+			Running:  job.running,
+		}
+		if next := s.cron.Entry(job.entry).Next; status.Enabled && !next.IsZero() {
+			status.NextRun = &next
+		}
+		if run, ok := lastRuns[job.Name]; ok {
+			status.LastRun = &run
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+func (s *Scheduler) job(name string) *registeredJob {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	for _, job := range s.jobs {
+		if job.Name == name {
+			return job
+		}
+	}
+	return nil
+}
+
+func (s *Scheduler) runScheduled(job *registeredJob) {
+	unlock, err := s.lock(job)
+	if errors.Is(err, ErrRunning) {
+		log.Printf("skipping job %s, it is still running", job.Name)
+		return
+	}
+	if errors.Is(err, ErrStopped) {
+		return
+	}
+	if err != nil {
+		log.Printf("error starting job %s: %v", job.Name, err)
+		return
+	}
+	s.run(job, unlock)
+}
+
+// lock takes the lock of job, and returns the function releasing it. The run
+// is counted in s.running under mu, so that Stop, which sets stopped under
+// mu before it waits, either waits for it or refuses it.
+func (s *Scheduler) lock(job *registeredJob) (func(), error) {
+	s.mu.Lock()
+	if s.stopped {
+		s.mu.Unlock()
+		return nil, ErrStopped
+	}
+	if job.running {
+		s.mu.Unlock()
+		return nil, ErrRunning
+	}
+	job.running = true
+	s.running.Add(1)
+	s.mu.Unlock()
+
+	unlock, locked, err := s.locker.TryLock(s.ctx, job.Name)
+	if err != nil || !locked {
+		s.setRunning(job, false)
+		s.running.Done()
+		if err != nil {
+			return nil, fmt.Errorf("failed to lock job %s: %w", job.Name, err)
+		}
+		return nil, ErrRunning
+	}
+	return func() {
+		unlock()
+		s.setRunning(job, false)
+		s.running.Done()
+	}, nil
+}
+
+func (s *Scheduler) setRunning(job *registeredJob, running bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	job.running = running
+}
+
+// run runs job, which holds its lock, and records the run.
+func (s *Scheduler) run(job *registeredJob, unlock func()) {
+	defer unlock()
+
+	log.Printf("job %s started", job.Name)
+	run := Run{Job: job.Name, StartedAt: time.Now()}
+	err := job.Run(s.ctx)
+	run.Duration = time.Since(run.StartedAt)
+	if err != nil {
+		run.Error = err.Error()
+		log.Printf("job %s failed after %s: %v", job.Name, run.Duration, err)
+	} else {
+		log.Printf("job %s completed in %s", job.Name, run.Duration)
+	}
+
+	if err := s.runs.RecordJobRun(context.Background(), run); err != nil {
+		log.Printf("error recording run of job %s: %v", job.Name, err)
+	}
+}
@@ -0,0 +1,191 @@
+package jobs
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func newTestScheduler(t *testing.T, locker Locker) (*Scheduler, *MemoryRunStore) {
+	t.Helper()
+	runs := NewMemoryRunStore()
+	s := NewScheduler(locker, runs)
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		s.Stop(ctx)
+	})
+	return s, runs
+}
+
+func register(t *testing.T, s *Scheduler, job Job) {
+	t.Helper()
+	if err := s.Register(job); err != nil {
+		t.Fatalf("registering %s: %v", job.Name, err)
+	}
+}
+
+// jobStatus returns the status of the job called name.
+func jobStatus(t *testing.T, s *Scheduler, name string) Status {
+	t.Helper()
+	statuses, err := s.Jobs(context.Background())
+	if err != nil {
+		t.Fatalf("listing jobs: %v", err)
+	}
+	for _, status := range statuses {
+		if status.Name == name {
+			return status
+		}
+	}
+	t.Fatalf("expected job %s to be registered, got %+v", name, statuses)
+	return Status{}
+}
+
+// waitForRun waits until the last run of the job called name is recorded.
+func waitForRun(t *testing.T, s *Scheduler, name string) Run {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		status := jobStatus(t, s, name)
+		if status.LastRun != nil && !status.Running {
+			return *status.LastRun
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected job %s to run, got %+v", name, status)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestRegisterRejectsDuplicatesAndBadSchedules(t *testing.T) {
+	s, _ := newTestScheduler(t, NewMemoryLocker())
+	run := func(ctx context.Context) error { return nil }
+	register(t, s, Job{Name: "a", Schedule: "@every 1h", Run: run})
+
+	if err := s.Register(Job{Name: "a", Schedule: Disabled, Run: run}); err == nil {
+		t.Fatal("expected a second job a to be rejected")
+	}
+	if err := s.Register(Job{Name: "b", Schedule: "every hour", Run: run}); err == nil {
+		t.Fatal("expected an invalid schedule to be rejected")
+	}
+}
+
+func TestDisabledJobIsOnlyTriggered(t *testing.T) {
+	s, _ := newTestScheduler(t, NewMemoryLocker())
+	ran := make(chan struct{}, 1)
+	register(t, s, Job{Name: "disabled", Schedule: Disabled, Run: func(ctx context.Context) error {
+		ran <- struct{}{}
+		return nil
+	}})
+	register(t, s, Job{Name: "scheduled", Schedule: "@every 1h", Run: func(ctx context.Context) error { return nil }})
+	s.Start()
+
+	if status := jobStatus(t, s, "disabled"); status.Enabled || status.NextRun != nil || status.LastRun != nil {
+		t.Fatalf("expected the disabled job to be unscheduled, got %+v", status)
+	}
+	if status := jobStatus(t, s, "scheduled"); !status.Enabled || status.NextRun == nil {
+		t.Fatalf("expected the scheduled job to have a next run, got %+v", status)
+	}
+
+	if err := s.Trigger("disabled"); err != nil {
+		t.Fatalf("triggering the disabled job: %v", err)
+	}
+	<-ran
+	if run := waitForRun(t, s, "disabled"); run.Error != "" {
+		t.Fatalf("expected a successful run, got %+v", run)
+	}
+}
+
+func TestTriggerReportsRun(t *testing.T) {
+	s, _ := newTestScheduler(t, NewMemoryLocker())
+	register(t, s, Job{Name: "failing", Schedule: Disabled, Run: func(ctx context.Context) error {
+		time.Sleep(20 * time.Millisecond)
+		return errors.New("broken")
+	}})
+
+	if err := s.Trigger("unknown"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected an unknown job to be not found, got %v", err)
+	}
+
+	before := time.Now()
+	if err := s.Trigger("failing"); err != nil {
+		t.Fatalf("triggering the job: %v", err)
+	}
+	run := waitForRun(t, s, "failing")
+	if run.Job != "failing" || run.StartedAt.Before(before) || run.Duration < 20*time.Millisecond || run.Error != "broken" {
+		t.Fatalf("unexpected run %+v", run)
+	}
+}
+
+func TestRunIsSkippedWhileLocked(t *testing.T) {
+	locker := NewMemoryLocker()
+	s, _ := newTestScheduler(t, locker)
+	runs := 0
+	register(t, s, Job{Name: "job", Schedule: Disabled, Run: func(ctx context.Context) error {
+		runs++
+		return nil
+	}})
+
+	// Another instance holds the lock of the job
+	unlock, locked, err := locker.TryLock(context.Background(), "job")
+	if err != nil || !locked {
+		t.Fatalf("expected to take the lock, got %t, %v", locked, err)
+	}
+	if err := s.Trigger("job"); !errors.Is(err, ErrRunning) {
+		t.Fatalf("expected the trigger to be refused, got %v", err)
+	}
+	s.runScheduled(s.job("job"))
+	if status := jobStatus(t, s, "job"); runs != 0 || status.Running || status.LastRun != nil {
+		t.Fatalf("expected no run while the lock is held, got %d runs, %+v", runs, status)
+	}
+
+	unlock()
+	s.runScheduled(s.job("job"))
+	if runs != 1 {
+		t.Fatalf("expected the job to run once the lock is free, got %d runs", runs)
+	}
+}
+
+func TestTriggerWhileRunning(t *testing.T) {
+	s, _ := newTestScheduler(t, NewMemoryLocker())
+	release := make(chan struct{})
+	register(t, s, Job{Name: "slow", Schedule: Disabled, Run: func(ctx context.Context) error {
+		<-release
+		return nil
+	}})
+
+	if err := s.Trigger("slow"); err != nil {
+		t.Fatalf("triggering the job: %v", err)
+	}
+	if status := jobStatus(t, s, "slow"); !status.Running {
+		t.Fatalf("expected the job to be running, got %+v", status)
+	}
+	if err := s.Trigger("slow"); !errors.Is(err, ErrRunning) {
+		t.Fatalf("expected a second trigger to be refused, got %v", err)
+	}
+	close(release)
+	waitForRun(t, s, "slow")
+}
+
+func TestStopWaitsForRunsAndRefusesTriggers(t *testing.T) {
+	s, _ := newTestScheduler(t, NewMemoryLocker())
+	release := make(chan struct{})
+	finished := false
+	register(t, s, Job{Name: "slow", Schedule: Disabled, Run: func(ctx context.Context) error {
+		<-release
+		finished = true
+		return nil
+	}})
+	if err := s.Trigger("slow"); err != nil {
+		t.Fatalf("triggering the job: %v", err)
+	}
+
+	time.AfterFunc(20*time.Millisecond, func() { close(release) })
+	if err := s.Stop(context.Background()); err != nil || !finished {
+		t.Fatalf("expected Stop to wait for the run, got %t, %v", finished, err)
+	}
+	if err := s.Trigger("slow"); !errors.Is(err, ErrStopped) {
+		t.Fatalf("expected triggers to be refused after Stop, got %v", err)
+	}
+}
@@ -0,0 +1,82 @@
+package jobs
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryLocker is a Locker of a single instance, for tests and local
+// experiments.
+type MemoryLocker struct {
+	mu   sync.Mutex
+	held map[string]bool
+}
+
+func NewMemoryLocker() *MemoryLocker {
+	return &MemoryLocker{held: map[string]bool{}}
+}
+
+func (l *MemoryLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.held[name] {
+		return nil, false, nil
+	}
+	l.held[name] = true
+	return func() {
+		l.mu.Lock()
+		defer l.mu.Unlock()
+		delete(l.held, name)
+	}, true, nil
+}
+
+// MemoryRunStore is a RunStore and CheckpointStore kept in process memory,
+// lost on restart.
+type MemoryRunStore struct {
+	mu          sync.Mutex
+	runs        map[string]Run
+	checkpoints map[string]time.Time
+}
+
+func NewMemoryRunStore() *MemoryRunStore {
+	return &MemoryRunStore{runs: map[string]Run{}, checkpoints: map[string]time.Time{}}
+}
+
+func (r *MemoryRunStore) RecordJobRun(ctx context.Context, run Run) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.runs[run.Job] = run
+	return nil
+}
+
+func (r *MemoryRunStore) GetJobRuns(ctx context.Context) ([]Run, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	runs := make([]Run, 0, len(r.runs))
+	for _, run := range r.runs {
+		runs = append(runs, run)
+	}
+	sort.Slice(runs, func(i, j int) bool { return runs[i].Job < runs[j].Job })
+	return runs, nil
+}
+
+func (r *MemoryRunStore) Now(ctx context.Context) (time.Time, error) {
+	return time.Now(), nil
+}
+
+func (r *MemoryRunStore) GetJobCheckpoint(ctx context.Context, job string) (time.Time, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.checkpoints[job], nil
+}
+
+func (r *MemoryRunStore) SetJobCheckpoint(ctx context.Context, job string, checkpoint time.Time) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.checkpoints[job] = checkpoint
+	return nil
+}
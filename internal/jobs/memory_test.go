@@ -0,0 +1,66 @@
+package jobs
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func TestMemoryRunStoreKeepsLastRun(t *testing.T) {
+	runs := NewMemoryRunStore()
+	ctx := context.Background()
+	started := time.Now()
+	for _, run := range []Run{
+		{Job: "b", StartedAt: started, Duration: time.Second},
+		{Job: "a", StartedAt: started, Duration: time.Second, Error: "broken"},
+		{Job: "a", StartedAt: started.Add(time.Minute), Duration: 2 * time.Second},
+	} {
+		if err := runs.RecordJobRun(ctx, run); err != nil {
+			t.Fatalf("recording run: %v", err)
+		}
+	}
+
+	got, err := runs.GetJobRuns(ctx)
+	if err != nil {
+		t.Fatalf("getting runs: %v", err)
+	}
+	if len(got) != 2 || got[0].Job != "a" || got[0].Error != "" || got[0].Duration != 2*time.Second || got[1].Job != "b" {
+		t.Fatalf("expected the last run of a then b, got %+v", got)
+	}
+}
+
+func TestMemoryCheckpoints(t *testing.T) {
+	checkpoints := NewMemoryRunStore()
+	ctx := context.Background()
+
+	if checkpoint, err := checkpoints.GetJobCheckpoint(ctx, "job"); err != nil || !checkpoint.IsZero() {
+		t.Fatalf("expected no checkpoint, got %v, %v", checkpoint, err)
+	}
+	now := time.Now()
+	if err := checkpoints.SetJobCheckpoint(ctx, "job", now); err != nil {
+		t.Fatalf("setting checkpoint: %v", err)
+	}
+	if checkpoint, err := checkpoints.GetJobCheckpoint(ctx, "job"); err != nil || !checkpoint.Equal(now) {
+		t.Fatalf("expected checkpoint %v, got %v, %v", now, checkpoint, err)
+	}
+}
+
+func TestMemoryLockerHandsOutOneLock(t *testing.T) {
+	locker := NewMemoryLocker()
+	ctx := context.Background()
+
+	unlock, locked, err := locker.TryLock(ctx, "job")
+	if err != nil || !locked {
+		t.Fatalf("expected to take the lock, got %t, %v", locked, err)
+	}
+	if _, locked, _ := locker.TryLock(ctx, "job"); locked {
+		t.Fatal("expected the held lock to be refused")
+	}
+	if _, locked, _ := locker.TryLock(ctx, "other"); !locked {
+		t.Fatal("expected the lock of another job to be free")
+	}
+	unlock()
+	if _, locked, _ := locker.TryLock(ctx, "job"); !locked {
+		t.Fatal("expected the released lock to be free")
+	}
+}
@@ -0,0 +1,140 @@
+package jobs
+
+import (
+	"chat-system/internal/database"
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"log"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+// lockPrefix keeps the advisory locks of jobs apart from any other lock taken
+// on the server
+const lockPrefix = "chat-system:job:"
+
+// MySQLLocker takes MySQL advisory locks with GET_LOCK. A lock belongs to the
+// session that took it, so it is held on a connection of its own until it is
+// released, and released by the server if the instance dies.
+type MySQLLocker struct {
+	database *sqlx.DB
+}
+
+func NewMySQLLocker() *MySQLLocker {
+	return &MySQLLocker{database: database.DATABASE}
+}
+
+func (l *MySQLLocker) TryLock(ctx context.Context, name string) (func(), bool, error) {
+	conn, err := l.database.Connx(ctx)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to get connection: %w", err)
+	}
+
+	var locked sql.NullInt64
+	err = conn.GetContext(ctx, &locked, "SELECT GET_LOCK(?, 0)", lockPrefix+name)
+	if err != nil {
+		conn.Close()
+		return nil, false, fmt.Errorf("failed to take lock: %w", err)
+	}
+	if locked.Int64 != 1 {
+		conn.Close()
+		return nil, false, nil
+	}
+
+	return func() {
+		_, err := conn.ExecContext(context.Background(), "DO RELEASE_LOCK(?)", lockPrefix+name)
+		if err != nil {
+			log.Printf("error releasing lock of job %s, dropping its connection: %v", name, err)
+			// A connection still holding the lock must not go back to the pool
+			conn.Raw(func(interface{}) error { return driver.ErrBadConn })
+		}
+		conn.Close()
+	}, true, nil
+}
+
+// MySQLRunStore keeps the last run of each job in the JobRuns table, and its
+// checkpoint in JobCheckpoints.
+type MySQLRunStore struct {
+	database *sqlx.DB
+}
+
+func NewMySQLRunStore() *MySQLRunStore {
+	return &MySQLRunStore{database: database.DATABASE}
+}
+
+func (r *MySQLRunStore) RecordJobRun(ctx context.Context, run Run) error {
+	query := `
+        INSERT INTO JobRuns (job, started_at, duration_ms, error)
+        VALUES (?, ?, ?, ?)
+        ON DUPLICATE KEY UPDATE
+            started_at = VALUES(started_at),
+            duration_ms = VALUES(duration_ms),
+            error = VALUES(error)
+    `
+	_, err := r.database.ExecContext(ctx, query, run.Job, run.StartedAt, run.Duration.Milliseconds(), run.Error)
+	if err != nil {
+		return fmt.Errorf("failed to record job run: %w", err)
+	}
+	return nil
+}
+
+func (r *MySQLRunStore) GetJobRuns(ctx context.Context) ([]Run, error) {
+	rows := []struct {
+		Job        string    `db:"job"`
+		StartedAt  time.Time `db:"started_at"`
+		DurationMs int64     `db:"duration_ms"`
+		Error      string    `db:"error"`
+	}{}
+	err := r.database.SelectContext(ctx, &rows, "SELECT job, started_at, duration_ms, error FROM JobRuns ORDER BY job")
+	if err != nil {
+		return nil, fmt.Errorf("failed to get job runs: %w", err)
+	}
+
+	runs := make([]Run, 0, len(rows))
+	for _, row := range rows {
+		runs = append(runs, Run{
+			Job:       row.Job,
+			StartedAt: row.StartedAt,
+			Duration:  time.Duration(row.DurationMs) * time.Millisecond,
+			Error:     row.Error,
+		})
+	}
+	return runs, nil
+}
+
+// Now returns the clock of the database.
+func (r *MySQLRunStore) Now(ctx context.Context) (time.Time, error) {
+	var now time.Time
+	if err := r.database.GetContext(ctx, &now, "SELECT NOW()"); err != nil {
+		return time.Time{}, fmt.Errorf("failed to read the database clock: %w", err)
+	}
+	return now, nil
+}
+
+func (r *MySQLRunStore) GetJobCheckpoint(ctx context.Context, job string) (time.Time, error) {
+	var checkpoint time.Time
+	err := r.database.GetContext(ctx, &checkpoint, "SELECT checkpoint FROM JobCheckpoints WHERE job = ?", job)
+	if errors.Is(err, sql.ErrNoRows) {
+		return time.Time{}, nil
+	}
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed to get job checkpoint: %w", err)
+	}
+	return checkpoint, nil
+}
+
+func (r *MySQLRunStore) SetJobCheckpoint(ctx context.Context, job string, checkpoint time.Time) error {
+	query := `
+        INSERT INTO JobCheckpoints (job, checkpoint)
+        VALUES (?, ?)
+        ON DUPLICATE KEY UPDATE checkpoint = VALUES(checkpoint)
+    `
+	if _, err := r.database.ExecContext(ctx, query, job, checkpoint); err != nil {
+		return fmt.Errorf("failed to set job checkpoint: %w", err)
+	}
+	return nil
+}
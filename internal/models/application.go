@@ -11,6 +11,9 @@ type UserExposedApplication struct {
 type Application struct {
 	Id int64 `db:"id"`
 	UserExposedApplication
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	// NextChatNumber is the number the next chat of the application gets
+	NextChatNumber int64      `db:"next_chat_number"`
+	CreatedAt      time.Time  `db:"created_at"`
+	UpdatedAt      time.Time  `db:"updated_at"`
+	DeletedAt      *time.Time `db:"deleted_at"`
 }
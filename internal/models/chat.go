@@ -11,6 +11,12 @@ type Chat struct {
 	Id            int64 `db:"id"`
 	ApplicationId int64 `db:"application_id"`
 	UserExposedChat
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	// NextMessageNumber is the number the next message of the chat gets
+	NextMessageNumber int64      `db:"next_message_number"`
+	CreatedAt         time.Time  `db:"created_at"`
+	UpdatedAt         time.Time  `db:"updated_at"`
+	DeletedAt         *time.Time `db:"deleted_at"`
+	// Version counts the changes of the chat, deletes and restores included,
+	// starting from 1
+	Version int64 `db:"version"`
 }
@@ -0,0 +1,12 @@
+package models
+
+import "time"
+
+type IdempotencyKey struct {
+	Scope       string    `db:"scope"`
+	Key         string    `db:"idempotency_key"`
+	RequestHash string    `db:"request_hash"`
+	TaskId      string    `db:"task_id"`
+	CreatedAt   time.Time `db:"created_at"`
+	ExpiresAt   time.Time `db:"expires_at"`
+}
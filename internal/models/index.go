@@ -0,0 +1,35 @@
+package models
+
+import "time"
+
+// IndexScope selects the messages written to the search index by a reindex:
+// those of one chat, of every chat of one application, or all of them when
+// both ids are zero. Chats that are soft deleted are included, so that their
+// messages are dropped from the index.
+type IndexScope struct {
+	ChatId        int64
+	ApplicationId int64
+	// UpdatedSince, when set, keeps the messages and chats changed since then,
+	// and the messages of the chats changed since then
+	UpdatedSince time.Time
+}
+
+// Full tells whether the scope covers every chat.
+func (s IndexScope) Full() bool {
+	return s.ChatId == 0 && s.ApplicationId == 0
+}
+
+// IndexedMessage is a message along with the details of its chat that its
+// search document holds.
+type IndexedMessage struct {
+	Message
+	ApplicationId int64      `db:"application_id"`
+	ChatNumber    int64      `db:"chat_number"`
+	ChatDeletedAt *time.Time `db:"chat_deleted_at"`
+}
+
+// Searchable tells whether the message belongs in the search index: neither
+// it nor its chat is soft deleted.
+func (m IndexedMessage) Searchable() bool {
+	return m.DeletedAt == nil && m.ChatDeletedAt == nil
+}
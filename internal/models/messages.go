@@ -11,6 +11,10 @@ type Message struct {
 	Id     int64 `db:"id"`
 	ChatId int64 `db:"chat_id"`
 	UserExposedMessage
-	CreatedAt time.Time `db:"created_at"`
-	UpdatedAt time.Time `db:"updated_at"`
+	CreatedAt time.Time  `db:"created_at"`
+	UpdatedAt time.Time  `db:"updated_at"`
+	DeletedAt *time.Time `db:"deleted_at"`
+	// Version counts the changes of the message, deletes and restores
+	// included, starting from 1
+	Version int64 `db:"version"`
 }
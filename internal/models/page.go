@@ -0,0 +1,11 @@
+package models
+
+// PageQuery selects a window of rows ordered by their key (the id for
+// applications, the number for chats and messages). After and Before are
+// exclusive keys in the requested order; zero means unset.
+type PageQuery struct {
+	Limit      int
+	After      int64
+	Before     int64
+	Descending bool
+}
@@ -0,0 +1,23 @@
+package models
+
+import "time"
+
+const (
+	TaskPending   = "pending"
+	TaskRunning   = "running"
+	TaskCompleted = "completed"
+	TaskFailed    = "failed"
+)
+
+type TaskStatus struct {
+	TaskId string `db:"task_id"`
+	Status string `db:"status"`
+	Result []byte `db:"result"`
+	Error  string `db:"error"`
+	// ErrorKind is the kind of the domain error a failed task failed with,
+	// such as database.KindNotFound, and empty for any other failure
+	ErrorKind string    `db:"error_kind"`
+	CreatedAt time.Time `db:"created_at"`
+	UpdatedAt time.Time `db:"updated_at"`
+	ExpiresAt time.Time `db:"expires_at"`
+}
@@ -0,0 +1,58 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+const boundedPollInterval = 50 * time.Millisecond
+
+// BoundedQueue caps the number of pending tasks per queue so that a burst of
+// requests is pushed back to clients instead of piling up. Enqueue waits at
+// most timeout for room to free up before giving up with ErrFull. The bound
+// is checked before inserting, so concurrent producers may overshoot it by a
+// few tasks.
+type BoundedQueue struct {
+	Queue
+	capacity int64
+	timeout  time.Duration
+}
+
+func NewBoundedQueue(q Queue, capacity int64, timeout time.Duration) *BoundedQueue {
+	return &BoundedQueue{Queue: q, capacity: capacity, timeout: timeout}
+}
+
+func (b *BoundedQueue) Capacity() int64 {
+	return b.capacity
+}
+
+func (b *BoundedQueue) Enqueue(ctx context.Context, task Task) error {
+	ctx, cancel := context.WithTimeout(ctx, b.timeout)
+	defer cancel()
+
+	for {
+		depth, err := b.Queue.Depth(ctx, task.Queue)
+		if errors.Is(err, context.DeadlineExceeded) {
+			return ErrFull
+		}
+		if err != nil {
+			return err
+		}
+		if depth < b.capacity {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return ErrFull
+		case <-time.After(boundedPollInterval):
+		}
+	}
+
+	err := b.Queue.Enqueue(ctx, task)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return ErrFull
+	}
+	return err
+}
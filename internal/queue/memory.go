@@ -0,0 +1,252 @@
+package queue
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// MemoryQueue is a Queue and DeadLetterStore kept in process memory. It
+// follows the semantics of MySQLQueue, including partition ordering, leases
+// and delayed retries, but loses everything on restart, so it is only meant
+// for tests and local experiments.
+type MemoryQueue struct {
+	mu          sync.Mutex
+	seq         int64
+	tasks       []*memoryTask
+	deadLetters map[string]DeadLetter
+	statuses    StatusResetter
+}
+
+// StatusResetter puts the status of a replayed task back to pending, which
+// MySQLQueue does in the TaskStatuses table.
+type StatusResetter interface {
+	ResetTaskStatus(taskId string) error
+}
+
+type memoryTask struct {
+	Task
+	seq            int64
+	claimed        bool
+	leaseExpiresAt time.Time
+	availableAt    time.Time
+	lastError      string
+}
+
+func NewMemoryQueue() *MemoryQueue {
+	return &MemoryQueue{deadLetters: map[string]DeadLetter{}}
+}
+
+// ResetStatusesIn has replays reset the statuses of their tasks in statuses.
+func (q *MemoryQueue) ResetStatusesIn(statuses StatusResetter) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.statuses = statuses
+}
+
+func (q *MemoryQueue) Enqueue(ctx context.Context, task Task) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	q.enqueue(task)
+	return nil
+}
+
+// enqueue appends task to the queue, which q.mu guards.
+func (q *MemoryQueue) enqueue(task Task) {
+	q.seq++
+	task.Attempts = 0
+	task.CreatedAt = time.Now()
+	q.tasks = append(q.tasks, &memoryTask{Task: task, seq: q.seq, availableAt: task.CreatedAt})
+}
+
+func (q *MemoryQueue) Claim(ctx context.Context, queueName string, lease time.Duration) (*Task, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	now := time.Now()
+	heads := map[string]bool{}
+	for _, task := range q.tasks {
+		if task.Queue != queueName || heads[task.PartitionKey] {
+			continue
+		}
+		// Only the head of each partition is eligible
+		heads[task.PartitionKey] = true
+
+		ready := !task.claimed && !task.availableAt.After(now)
+		expired := task.claimed && task.leaseExpiresAt.Before(now)
+		if !ready && !expired {
+			continue
+		}
+
+		task.claimed = true
+		task.Attempts++
+		task.leaseExpiresAt = now.Add(lease)
+		claimed := task.Task
+		return &claimed, nil
+	}
+	return nil, ErrEmpty
+}
+
+// findClaimed returns the index of the task still claimed under attempt, or
+// -1.
+func (q *MemoryQueue) findClaimed(taskID string, attempt int) int {
+	i := q.find(taskID)
+	if i < 0 || !q.tasks[i].claimed || q.tasks[i].Attempts != attempt {
+		return -1
+	}
+	return i
+}
+
+func (q *MemoryQueue) Ack(ctx context.Context, taskID string, attempt int) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.findClaimed(taskID, attempt)
+	if i < 0 {
+		return ErrLeaseLost
+	}
+	q.tasks = append(q.tasks[:i], q.tasks[i+1:]...)
+	return nil
+}
+
+func (q *MemoryQueue) Extend(ctx context.Context, taskID string, attempt int, lease time.Duration) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.findClaimed(taskID, attempt)
+	if i < 0 {
+		return ErrLeaseLost
+	}
+	q.tasks[i].leaseExpiresAt = time.Now().Add(lease)
+	return nil
+}
+
+func (q *MemoryQueue) Retry(ctx context.Context, taskID string, attempt int, delay time.Duration, lastError string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.findClaimed(taskID, attempt)
+	if i < 0 {
+		return ErrLeaseLost
+	}
+	task := q.tasks[i]
+	task.claimed = false
+	task.leaseExpiresAt = time.Time{}
+	task.availableAt = time.Now().Add(delay)
+	task.lastError = lastError
+	return nil
+}
+
+func (q *MemoryQueue) Bury(ctx context.Context, taskID string, attempt int, lastError string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	i := q.findClaimed(taskID, attempt)
+	if i < 0 {
+		return ErrLeaseLost
+	}
+	q.deadLetters[taskID] = DeadLetter{Task: q.tasks[i].Task, Error: lastError, FailedAt: time.Now()}
+	q.tasks = append(q.tasks[:i], q.tasks[i+1:]...)
+	return nil
+}
+
+func (q *MemoryQueue) Recover(ctx context.Context, queueName string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var recovered int64
+	now := time.Now()
+	for _, task := range q.tasks {
+		if task.Queue == queueName && task.claimed && task.leaseExpiresAt.Before(now) {
+			task.claimed = false
+			task.leaseExpiresAt = time.Time{}
+			recovered++
+		}
+	}
+	return recovered, nil
+}
+
+func (q *MemoryQueue) Depth(ctx context.Context, queueName string) (int64, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var depth int64
+	for _, task := range q.tasks {
+		if task.Queue == queueName {
+			depth++
+		}
+	}
+	return depth, nil
+}
+
+// ListDeadLetters returns the most recent dead letters first. An empty
+// queueName lists every queue.
+func (q *MemoryQueue) ListDeadLetters(ctx context.Context, queueName string, limit int) ([]DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	deadLetters := []DeadLetter{}
+	for _, deadLetter := range q.deadLetters {
+		if queueName == "" || deadLetter.Queue == queueName {
+			deadLetters = append(deadLetters, deadLetter)
+		}
+	}
+	sort.Slice(deadLetters, func(i, j int) bool {
+		return deadLetters[i].FailedAt.After(deadLetters[j].FailedAt)
+	})
+	if len(deadLetters) > limit {
+		deadLetters = deadLetters[:limit]
+	}
+	return deadLetters, nil
+}
+
+func (q *MemoryQueue) GetDeadLetter(ctx context.Context, taskID string) (*DeadLetter, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	deadLetter, ok := q.deadLetters[taskID]
+	if !ok {
+		return nil, ErrNotFound
+	}
+	return &deadLetter, nil
+}
+
+func (q *MemoryQueue) ReplayDeadLetter(ctx context.Context, taskID string, resetStatus bool) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	deadLetter, ok := q.deadLetters[taskID]
+	if !ok {
+		return ErrNotFound
+	}
+	if resetStatus && q.statuses != nil {
+		if err := q.statuses.ResetTaskStatus(taskID); err != nil {
+			return err
+		}
+	}
+	delete(q.deadLetters, taskID)
+	q.enqueue(deadLetter.Task)
+	return nil
+}
+
+func (q *MemoryQueue) DiscardDeadLetter(ctx context.Context, taskID string) error {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if _, ok := q.deadLetters[taskID]; !ok {
+		return ErrNotFound
+	}
+	delete(q.deadLetters, taskID)
+	return nil
+}
+
+// find returns the position of a task, or -1. The caller holds mu.
+func (q *MemoryQueue) find(taskID string) int {
+	for i, task := range q.tasks {
+		if task.ID == taskID {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,197 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+const testQueue = "test"
+
+func enqueueTasks(t *testing.T, q Queue, ids ...string) {
+	t.Helper()
+	for _, id := range ids {
+		// Tasks are named <partition><n>, such as a1
+		task := Task{ID: id, Queue: testQueue, Kind: "test", PartitionKey: id[:1]}
+		if err := q.Enqueue(context.Background(), task); err != nil {
+			t.Fatalf("enqueuing %s: %v", id, err)
+		}
+	}
+}
+
+// expectClaim claims a task and checks that it is the one expected, or that
+// none is ready when id is empty.
+func expectClaim(t *testing.T, q Queue, lease time.Duration, id string) *Task {
+	t.Helper()
+	task, err := q.Claim(context.Background(), testQueue, lease)
+	if id == "" {
+		if !errors.Is(err, ErrEmpty) {
+			t.Fatalf("expected no task to be ready, got %+v, %v", task, err)
+		}
+		return nil
+	}
+	if err != nil {
+		t.Fatalf("expected to claim %s, got %v", id, err)
+	}
+	if task.ID != id {
+		t.Fatalf("expected to claim %s, got %s", id, task.ID)
+	}
+	return task
+}
+
+func TestClaimHandsOutPartitionHeadsInOrder(t *testing.T) {
+	q := NewMemoryQueue()
+	enqueueTasks(t, q, "a1", "a2", "b1", "a3", "b2")
+
+	expectClaim(t, q, time.Minute, "a1")
+	// a2 waits behind a1, so the next head is b1
+	expectClaim(t, q, time.Minute, "b1")
+	expectClaim(t, q, time.Minute, "")
+
+	q.Ack(context.Background(), "b1", 1)
+	expectClaim(t, q, time.Minute, "b2")
+	q.Ack(context.Background(), "a1", 1)
+	expectClaim(t, q, time.Minute, "a2")
+	q.Ack(context.Background(), "a2", 1)
+	expectClaim(t, q, time.Minute, "a3")
+}
+
+func TestRetryKeepsTaskAtPartitionHead(t *testing.T) {
+	q := NewMemoryQueue()
+	enqueueTasks(t, q, "a1", "a2")
+
+	expectClaim(t, q, time.Minute, "a1")
+	q.Retry(context.Background(), "a1", 1, 30*time.Millisecond, "failed")
+	expectClaim(t, q, time.Minute, "")
+
+	time.Sleep(40 * time.Millisecond)
+	if task := expectClaim(t, q, time.Minute, "a1"); task.Attempts != 2 {
+		t.Fatalf("expected attempt 2, got %d", task.Attempts)
+	}
+}
+
+func TestExpiredLeaseIsClaimedAgain(t *testing.T) {
+	q := NewMemoryQueue()
+	enqueueTasks(t, q, "a1", "a2")
+
+	expectClaim(t, q, 30*time.Millisecond, "a1")
+	time.Sleep(20 * time.Millisecond)
+	q.Extend(context.Background(), "a1", 1, 60*time.Millisecond)
+	time.Sleep(20 * time.Millisecond)
+	// Past the first lease, but not the extended one
+	expectClaim(t, q, time.Minute, "")
+
+	time.Sleep(60 * time.Millisecond)
+	if task := expectClaim(t, q, time.Minute, "a1"); task.Attempts != 2 {
+		t.Fatalf("expected attempt 2, got %d", task.Attempts)
+	}
+}
+
+func TestRecoverReleasesExpiredLeases(t *testing.T) {
+	q := NewMemoryQueue()
+	enqueueTasks(t, q, "a1", "b1")
+
+	expectClaim(t, q, time.Millisecond, "a1")
+	expectClaim(t, q, time.Minute, "b1")
+	time.Sleep(5 * time.Millisecond)
+
+	recovered, err := q.Recover(context.Background(), testQueue)
+	if err != nil || recovered != 1 {
+		t.Fatalf("expected a1 to be recovered, got %d, %v", recovered, err)
+	}
+	expectClaim(t, q, time.Minute, "a1")
+}
+
+func TestBuryAndReplayDeadLetter(t *testing.T) {
+	q := NewMemoryQueue()
+	enqueueTasks(t, q, "a1", "a2")
+	ctx := context.Background()
+
+	expectClaim(t, q, time.Minute, "a1")
+	if err := q.Bury(ctx, "a1", 1, "failed"); err != nil {
+		t.Fatalf("burying a1: %v", err)
+	}
+	if deadLetter, err := q.GetDeadLetter(ctx, "a1"); err != nil || deadLetter.Error != "failed" {
+		t.Fatalf("expected a1 in the dead letters, got %+v, %v", deadLetter, err)
+	}
+
+	// The replayed task goes behind what its partition received meanwhile
+	if err := q.ReplayDeadLetter(ctx, "a1", true); err != nil {
+		t.Fatalf("replaying a1: %v", err)
+	}
+	for _, id := range []string{"a2", "a1"} {
+		task := expectClaim(t, q, time.Minute, id)
+		q.Ack(ctx, id, task.Attempts)
+	}
+	if _, err := q.GetDeadLetter(ctx, "a1"); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected the replayed dead letter to be gone, got %v", err)
+	}
+	if depth, _ := q.Depth(ctx, testQueue); depth != 0 {
+		t.Fatalf("expected an empty queue, got %d tasks", depth)
+	}
+}
+
+func TestStaleClaimCannotSettleTask(t *testing.T) {
+	q := NewMemoryQueue()
+	enqueueTasks(t, q, "a1")
+	ctx := context.Background()
+
+	stale := expectClaim(t, q, time.Millisecond, "a1")
+	time.Sleep(5 * time.Millisecond)
+	current := expectClaim(t, q, time.Minute, "a1")
+
+	// The worker whose lease expired no longer owns the task
+	if err := q.Extend(ctx, "a1", stale.Attempts, time.Minute); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected the stale extend to be refused, got %v", err)
+	}
+	if err := q.Retry(ctx, "a1", stale.Attempts, 0, "failed"); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected the stale retry to be refused, got %v", err)
+	}
+	if err := q.Bury(ctx, "a1", stale.Attempts, "failed"); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected the stale bury to be refused, got %v", err)
+	}
+	if err := q.Ack(ctx, "a1", stale.Attempts); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected the stale ack to be refused, got %v", err)
+	}
+	expectClaim(t, q, time.Minute, "")
+
+	if err := q.Ack(ctx, "a1", current.Attempts); err != nil {
+		t.Fatalf("acknowledging a1: %v", err)
+	}
+	if err := q.Ack(ctx, "a1", current.Attempts); !errors.Is(err, ErrLeaseLost) {
+		t.Fatalf("expected a second ack to be refused, got %v", err)
+	}
+}
+
+// failingStatuses refuses to reset any status.
+type failingStatuses struct{}
+
+func (failingStatuses) ResetTaskStatus(taskId string) error {
+	return errors.New("statuses are down")
+}
+
+func TestFailedStatusResetKeepsDeadLetter(t *testing.T) {
+	q := NewMemoryQueue()
+	q.ResetStatusesIn(failingStatuses{})
+	enqueueTasks(t, q, "a1")
+	ctx := context.Background()
+
+	task := expectClaim(t, q, time.Minute, "a1")
+	if err := q.Bury(ctx, "a1", task.Attempts, "failed"); err != nil {
+		t.Fatalf("burying a1: %v", err)
+	}
+	if err := q.ReplayDeadLetter(ctx, "a1", true); err == nil {
+		t.Fatal("expected the replay to fail")
+	}
+	if _, err := q.GetDeadLetter(ctx, "a1"); err != nil {
+		t.Fatalf("expected a1 to stay in the dead letters, got %v", err)
+	}
+	expectClaim(t, q, time.Minute, "")
+
+	// Without a status to reset, the replay goes through
+	if err := q.ReplayDeadLetter(ctx, "a1", false); err != nil {
+		t.Fatalf("replaying a1: %v", err)
+	}
+	expectClaim(t, q, time.Minute, "a1")
+}
@@ -0,0 +1,326 @@
+package queue
+
+import (
+	"chat-system/internal/database"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/jmoiron/sqlx"
+)
+
+type MySQLQueue struct {
+	database *sqlx.DB
+}
+
+func NewMySQLQueue() *MySQLQueue {
+	return &MySQLQueue{database: database.DATABASE}
+}
+
+func (q *MySQLQueue) Enqueue(ctx context.Context, task Task) error {
+	query := `
+        INSERT INTO QueuedTasks (task_id, queue, kind, partition_key, payload)
+        VALUES (?, ?, ?, ?, ?)
+    `
+	_, err := q.database.ExecContext(ctx, query, task.ID, task.Queue, task.Kind, task.PartitionKey, task.Payload)
+	if err != nil {
+		return fmt.Errorf("failed to enqueue task: %w", err)
+	}
+	return nil
+}
+
+func (q *MySQLQueue) Claim(ctx context.Context, queueName string, lease time.Duration) (*Task, error) {
+	tx, err := q.database.BeginTxx(ctx, nil)
+	if err != nil {
+		return nil, fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	row := struct {
+		Seq          int64     `db:"seq"`
+		TaskID       string    `db:"task_id"`
+		Queue        string    `db:"queue"`
+		Kind         string    `db:"kind"`
+		PartitionKey string    `db:"partition_key"`
+		Payload      []byte    `db:"payload"`
+		Attempts     int       `db:"attempts"`
+		CreatedAt    time.Time `db:"created_at"`
+	}{}
+	// Only the head of each partition is eligible: while it is claimed, or
+	// locked by a concurrent Claim, the rest of its partition has to wait.
+	selectQuery := `
+        SELECT t.seq, t.task_id, t.queue, t.kind, t.partition_key, t.payload, t.attempts, t.created_at
+        FROM QueuedTasks t
+        WHERE t.queue = ?
+          AND ((t.state = 'ready' AND t.available_at <= NOW(6))
+               OR (t.state = 'claimed' AND t.lease_expires_at < NOW(6)))
+          AND t.seq = (
+              SELECT MIN(h.seq)
+              FROM QueuedTasks h
+              WHERE h.queue = t.queue AND h.partition_key = t.partition_key
+          )
+        ORDER BY t.seq
+        LIMIT 1
+        FOR UPDATE SKIP LOCKED
+    `
+	err = tx.GetContext(ctx, &row, selectQuery, queueName)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrEmpty
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to select task: %w", err)
+	}
+
+	updateQuery := `
+        UPDATE QueuedTasks
+        SET state = 'claimed', attempts = attempts + 1, lease_expires_at = NOW(6) + INTERVAL ? MICROSECOND
+        WHERE seq = ?
+    `
+	_, err = tx.ExecContext(ctx, updateQuery, lease.Microseconds(), row.Seq)
+	if err != nil {
+		return nil, fmt.Errorf("failed to claim task: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, fmt.Errorf("failed to commit transaction: %w", err)
+	}
+
+	return &Task{
+		ID:           row.TaskID,
+		Queue:        row.Queue,
+		Kind:         row.Kind,
+		PartitionKey: row.PartitionKey,
+		Payload:      row.Payload,
+		Attempts:     row.Attempts + 1,
+		CreatedAt:    row.CreatedAt,
+	}, nil
+}
+
+// claimedCondition matches a task still claimed under an attempt.
+const claimedCondition = "task_id = ? AND attempts = ? AND state = 'claimed'"
+
+// settled checks that a statement settling a claim found it.
+func settled(result sql.Result) error {
+	affected, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count settled tasks: %w", err)
+	}
+	if affected == 0 {
+		return ErrLeaseLost
+	}
+	return nil
+}
+
+func (q *MySQLQueue) Ack(ctx context.Context, taskID string, attempt int) error {
+	result, err := q.database.ExecContext(ctx, "DELETE FROM QueuedTasks WHERE "+claimedCondition, taskID, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to acknowledge task: %w", err)
+	}
+	return settled(result)
+}
+
+func (q *MySQLQueue) Extend(ctx context.Context, taskID string, attempt int, lease time.Duration) error {
+	query := `
+        UPDATE QueuedTasks
+        SET lease_expires_at = NOW(6) + INTERVAL ? MICROSECOND
+        WHERE ` + claimedCondition
+	result, err := q.database.ExecContext(ctx, query, lease.Microseconds(), taskID, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to extend lease: %w", err)
+	}
+	return settled(result)
+}
+
+func (q *MySQLQueue) Recover(ctx context.Context, queueName string) (int64, error) {
+	query := `
+        UPDATE QueuedTasks
+        SET state = 'ready', lease_expires_at = NULL
+        WHERE queue = ? AND state = 'claimed' AND lease_expires_at < NOW(6)
+    `
+	result, err := q.database.ExecContext(ctx, query, queueName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to recover tasks: %w", err)
+	}
+	recovered, err := result.RowsAffected()
+	if err != nil {
+		return 0, fmt.Errorf("failed to count recovered tasks: %w", err)
+	}
+	return recovered, nil
+}
+
+func (q *MySQLQueue) Depth(ctx context.Context, queueName string) (int64, error) {
+	var depth int64
+	err := q.database.GetContext(ctx, &depth, "SELECT COUNT(*) FROM QueuedTasks WHERE queue = ?", queueName)
+	if err != nil {
+		return 0, fmt.Errorf("failed to count queued tasks: %w", err)
+	}
+	return depth, nil
+}
+
+func (q *MySQLQueue) Retry(ctx context.Context, taskID string, attempt int, delay time.Duration, lastError string) error {
+	query := `
+        UPDATE QueuedTasks
+        SET state = 'ready', lease_expires_at = NULL, last_error = ?,
+            available_at = NOW(6) + INTERVAL ? MICROSECOND
+        WHERE ` + claimedCondition
+	result, err := q.database.ExecContext(ctx, query, lastError, delay.Microseconds(), taskID, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to reschedule task: %w", err)
+	}
+	return settled(result)
+}
+
+func (q *MySQLQueue) Bury(ctx context.Context, taskID string, attempt int, lastError string) error {
+	tx, err := q.database.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insertQuery := `
+        INSERT INTO DeadLetterTasks (task_id, queue, kind, partition_key, payload, attempts, error, created_at)
+        SELECT task_id, queue, kind, partition_key, payload, attempts, ?, created_at
+        FROM QueuedTasks
+        WHERE ` + claimedCondition
+	result, err := tx.ExecContext(ctx, insertQuery, lastError, taskID, attempt)
+	if err != nil {
+		return fmt.Errorf("failed to insert dead letter: %w", err)
+	}
+	if err := settled(result); err != nil {
+		return err
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM QueuedTasks WHERE task_id = ?", taskID)
+	if err != nil {
+		return fmt.Errorf("failed to remove buried task: %w", err)
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+type deadLetterRow struct {
+	TaskID       string    `db:"task_id"`
+	Queue        string    `db:"queue"`
+	Kind         string    `db:"kind"`
+	PartitionKey string    `db:"partition_key"`
+	Payload      []byte    `db:"payload"`
+	Attempts     int       `db:"attempts"`
+	Error        string    `db:"error"`
+	CreatedAt    time.Time `db:"created_at"`
+	FailedAt     time.Time `db:"failed_at"`
+}
+
+func (row deadLetterRow) toDeadLetter() DeadLetter {
+	return DeadLetter{
+		Task: Task{
+			ID:           row.TaskID,
+			Queue:        row.Queue,
+			Kind:         row.Kind,
+			PartitionKey: row.PartitionKey,
+			Payload:      row.Payload,
+			Attempts:     row.Attempts,
+			CreatedAt:    row.CreatedAt,
+		},
+		Error:    row.Error,
+		FailedAt: row.FailedAt,
+	}
+}
+
+// ListDeadLetters returns the most recent dead letters first. An empty
+// queueName lists every queue.
+func (q *MySQLQueue) ListDeadLetters(ctx context.Context, queueName string, limit int) ([]DeadLetter, error) {
+	rows := []deadLetterRow{}
+	query := `
+        SELECT * FROM DeadLetterTasks
+        WHERE ? = '' OR queue = ?
+        ORDER BY failed_at DESC
+        LIMIT ?
+    `
+	err := q.database.SelectContext(ctx, &rows, query, queueName, queueName, limit)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letters: %w", err)
+	}
+	deadLetters := make([]DeadLetter, 0, len(rows))
+	for _, row := range rows {
+		deadLetters = append(deadLetters, row.toDeadLetter())
+	}
+	return deadLetters, nil
+}
+
+func (q *MySQLQueue) GetDeadLetter(ctx context.Context, taskID string) (*DeadLetter, error) {
+	row := deadLetterRow{}
+	err := q.database.GetContext(ctx, &row, "SELECT * FROM DeadLetterTasks WHERE task_id = ?", taskID)
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, ErrNotFound
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to get dead letter: %w", err)
+	}
+	deadLetter := row.toDeadLetter()
+	return &deadLetter, nil
+}
+
+func (q *MySQLQueue) ReplayDeadLetter(ctx context.Context, taskID string, resetStatus bool) error {
+	tx, err := q.database.BeginTxx(ctx, nil)
+	if err != nil {
+		return fmt.Errorf("failed to begin transaction: %w", err)
+	}
+	defer tx.Rollback()
+
+	insertQuery := `
+        INSERT INTO QueuedTasks (task_id, queue, kind, partition_key, payload)
+        SELECT task_id, queue, kind, partition_key, payload
+        FROM DeadLetterTasks
+        WHERE task_id = ?
+    `
+	result, err := tx.ExecContext(ctx, insertQuery, taskID)
+	if err != nil {
+		return fmt.Errorf("failed to requeue dead letter: %w", err)
+	}
+	if inserted, err := result.RowsAffected(); err != nil {
+		return fmt.Errorf("failed to count requeued tasks: %w", err)
+	} else if inserted == 0 {
+		return ErrNotFound
+	}
+
+	_, err = tx.ExecContext(ctx, "DELETE FROM DeadLetterTasks WHERE task_id = ?", taskID)
+	if err != nil {
+		return fmt.Errorf("failed to remove dead letter: %w", err)
+	}
+
+	if resetStatus {
+		statusQuery := `
+            UPDATE TaskStatuses
+            SET status = 'pending', result = NULL, error = '', error_kind = ''
+            WHERE task_id = ?
+        `
+		if _, err := tx.ExecContext(ctx, statusQuery, taskID); err != nil {
+			return fmt.Errorf("failed to reset task status: %w", err)
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return fmt.Errorf("failed to commit transaction: %w", err)
+	}
+	return nil
+}
+
+func (q *MySQLQueue) DiscardDeadLetter(ctx context.Context, taskID string) error {
+	result, err := q.database.ExecContext(ctx, "DELETE FROM DeadLetterTasks WHERE task_id = ?", taskID)
+	if err != nil {
+		return fmt.Errorf("failed to discard dead letter: %w", err)
+	}
+	deleted, err := result.RowsAffected()
+	if err != nil {
+		return fmt.Errorf("failed to count discarded dead letters: %w", err)
+	}
+	if deleted == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -0,0 +1,186 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"log"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	pollInterval = 500 * time.Millisecond
+	claimLease   = time.Minute
+)
+
+// Pool processes a queue on a fixed number of goroutines. Ordering within a
+// partition is guaranteed by Claim, so workers never coordinate with each
+// other directly.
+//
+// A task whose processing fails with a retryable error is attempted again
+// after a backoff until the retry policy gives up, at which point it is moved
+// to the dead letters. Any other failure is final and the task is dropped.
+type Pool struct {
+	queue     Queue
+	queueName string
+	workers   int
+	policy    RetryPolicy
+	process   func(task *Task) error
+	// onFailure is told about every failed attempt and whether the task is
+	// going to be attempted again.
+	onFailure func(task *Task, err error, retrying bool)
+	// lease is how long a claimed task stays invisible to other workers. It
+	// is extended every third of it while the task is being processed.
+	lease time.Duration
+
+	stop     chan struct{}
+	stopOnce sync.Once
+	running  sync.WaitGroup
+	inFlight atomic.Int64
+}
+
+func NewPool(q Queue, queueName string, workers int, policy RetryPolicy, process func(task *Task) error, onFailure func(task *Task, err error, retrying bool)) *Pool {
+	if workers < 1 {
+		workers = 1
+	}
+	return &Pool{
+		queue:     q,
+		queueName: queueName,
+		workers:   workers,
+		policy:    policy,
+		process:   process,
+		onFailure: onFailure,
+		lease:     claimLease,
+		stop:      make(chan struct{}),
+	}
+}
+
+// Start puts back tasks left unacknowledged by a previous run and launches
+// the workers.
+func (p *Pool) Start() {
+	recovered, err := p.queue.Recover(context.Background(), p.queueName)
+	if err != nil {
+		log.Printf("error recovering %s queue: %v", p.queueName, err)
+	} else if recovered > 0 {
+		log.Printf("recovered %d unacknowledged tasks in %s queue", recovered, p.queueName)
+	}
+
+	p.running.Add(p.workers)
+	for i := 0; i < p.workers; i++ {
+		go p.work()
+	}
+	log.Printf("started %d workers for %s queue", p.workers, p.queueName)
+}
+
+// Stop keeps the workers from claiming new tasks and waits for the tasks they
+// are processing. Tasks still running when ctx is done are abandoned; their
+// lease expires and they are replayed on the next start. Tasks that were never
+// claimed simply stay in the queue. Stop may be called more than once.
+func (p *Pool) Stop(ctx context.Context) error {
+	p.stopOnce.Do(func() { close(p.stop) })
+	log.Printf("stopping %s queue workers, waiting for %d in-flight tasks", p.queueName, p.inFlight.Load())
+
+	stopped := make(chan struct{})
+	go func() {
+		p.running.Wait()
+		close(stopped)
+	}()
+
+	select {
+	case <-stopped:
+		log.Printf("%s queue workers stopped", p.queueName)
+		return nil
+	case <-ctx.Done():
+		log.Printf("gave up waiting for %d in-flight tasks of %s queue, they will be replayed", p.inFlight.Load(), p.queueName)
+		return ctx.Err()
+	}
+}
+
+func (p *Pool) work() {
+	defer p.running.Done()
+	ctx := context.Background()
+	for {
+		select {
+		case <-p.stop:
+			return
+		default:
+		}
+
+		task, err := p.queue.Claim(ctx, p.queueName, p.lease)
+		if errors.Is(err, ErrEmpty) {
+			p.idle()
+			continue
+		}
+		if err != nil {
+			log.Printf("error claiming task from %s queue: %v", p.queueName, err)
+			p.idle()
+			continue
+		}
+
+		p.inFlight.Add(1)
+		p.handle(ctx, task)
+		p.inFlight.Add(-1)
+	}
+}
+
+// idle waits before polling the queue again, unless the pool is stopping.
+func (p *Pool) idle() {
+	select {
+	case <-p.stop:
+	case <-time.After(pollInterval):
+	}
+}
+
+func (p *Pool) handle(ctx context.Context, task *Task) {
+	done := make(chan struct{})
+	go p.renewLease(ctx, task, done)
+	err := p.process(task)
+	close(done)
+	if err == nil {
+		if err := p.queue.Ack(ctx, task.ID, task.Attempts); err != nil {
+			log.Printf("error acknowledging task %s: %v", task.ID, err)
+		}
+		return
+	}
+
+	retrying := p.policy.shouldRetry(task, err)
+	if p.onFailure != nil {
+		p.onFailure(task, err, retrying)
+	}
+
+	switch {
+	case retrying:
+		delay := p.policy.Backoff(task.Attempts)
+		log.Printf("task %s failed on attempt %d, retrying in %s: %v", task.ID, task.Attempts, delay, err)
+		if err := p.queue.Retry(ctx, task.ID, task.Attempts, delay, err.Error()); err != nil {
+			log.Printf("error rescheduling task %s: %v", task.ID, err)
+		}
+	case p.policy.Retryable != nil && p.policy.Retryable(err):
+		log.Printf("task %s failed after %d attempts, moving it to dead letters: %v", task.ID, task.Attempts, err)
+		if err := p.queue.Bury(ctx, task.ID, task.Attempts, err.Error()); err != nil {
+			log.Printf("error burying task %s: %v", task.ID, err)
+		}
+	default:
+		log.Printf("task %s failed permanently: %v", task.ID, err)
+		if err := p.queue.Ack(ctx, task.ID, task.Attempts); err != nil {
+			log.Printf("error acknowledging task %s: %v", task.ID, err)
+		}
+	}
+}
+
+// renewLease keeps extending the lease of a task until done is closed.
+func (p *Pool) renewLease(ctx context.Context, task *Task, done <-chan struct{}) {
+	ticker := time.NewTicker(p.lease / 3)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-ticker.C:
+			if err := p.queue.Extend(ctx, task.ID, task.Attempts, p.lease); err != nil {
+				log.Printf("error extending lease of task %s: %v", task.ID, err)
+			}
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+var errTransient = errors.New("transient")
+
+func testPolicy(maxAttempts int) RetryPolicy {
+	return RetryPolicy{
+		MaxAttempts: maxAttempts,
+		BaseDelay:   time.Millisecond,
+		MaxDelay:    time.Millisecond,
+		Retryable:   func(err error) bool { return errors.Is(err, errTransient) },
+	}
+}
+
+func startPool(t *testing.T, pool *Pool) {
+	t.Helper()
+	pool.Start()
+	t.Cleanup(func() {
+		ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+		defer cancel()
+		pool.Stop(ctx)
+	})
+}
+
+// waitForEmpty waits until every task of the test queue is acknowledged or
+// buried.
+func waitForEmpty(t *testing.T, q Queue) {
+	t.Helper()
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		depth, err := q.Depth(context.Background(), testQueue)
+		if err == nil && depth == 0 {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("expected the queue to drain, %d tasks left", depth)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestPoolKeepsOrderWithinPartition(t *testing.T) {
+	q := NewMemoryQueue()
+	var ids []string
+	for i := 1; i <= 10; i++ {
+		for _, partition := range []string{"a", "b", "c"} {
+			ids = append(ids, fmt.Sprintf("%s%d", partition, i))
+		}
+	}
+	enqueueTasks(t, q, ids...)
+
+	var mu sync.Mutex
+	processed := map[string][]string{}
+	running := map[string]bool{}
+	overlapped := false
+	pool := NewPool(q, testQueue, 4, testPolicy(1), func(task *Task) error {
+		mu.Lock()
+		overlapped = overlapped || running[task.PartitionKey]
+		running[task.PartitionKey] = true
+		mu.Unlock()
+
+		time.Sleep(time.Millisecond)
+
+		mu.Lock()
+		running[task.PartitionKey] = false
+		processed[task.PartitionKey] = append(processed[task.PartitionKey], task.ID)
+		mu.Unlock()
+		return nil
+	}, nil)
+	startPool(t, pool)
+	waitForEmpty(t, q)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if overlapped {
+		t.Fatal("expected the tasks of a partition to run one at a time")
+	}
+	for _, partition := range []string{"a", "b", "c"} {
+		for i, id := range processed[partition] {
+			if expected := fmt.Sprintf("%s%d", partition, i+1); id != expected {
+				t.Fatalf("expected partition %s to run in order, got %v", partition, processed[partition])
+			}
+		}
+		if len(processed[partition]) != 10 {
+			t.Fatalf("expected 10 tasks in partition %s, got %v", partition, processed[partition])
+		}
+	}
+}
+
+func TestPoolRenewsLeaseOfLongTask(t *testing.T) {
+	q := NewMemoryQueue()
+	enqueueTasks(t, q, "a1")
+
+	started := make(chan struct{})
+	release := make(chan struct{})
+	pool := NewPool(q, testQueue, 1, testPolicy(1), func(task *Task) error {
+		close(started)
+		<-release
+		return nil
+	}, nil)
+	pool.lease = 30 * time.Millisecond
+	startPool(t, pool)
+
+	<-started
+	// Well past the first lease, the task is still held by the pool
+	for i := 0; i < 5; i++ {
+		time.Sleep(20 * time.Millisecond)
+		expectClaim(t, q, time.Minute, "")
+	}
+	close(release)
+	waitForEmpty(t, q)
+}
+
+func TestPoolRetriesThenBuries(t *testing.T) {
+	q := NewMemoryQueue()
+	enqueueTasks(t, q, "a1", "b1", "c1")
+
+	var mu sync.Mutex
+	attempts := map[string]int{}
+	var failures []string
+	pool := NewPool(q, testQueue, 2, testPolicy(3), func(task *Task) error {
+		mu.Lock()
+		defer mu.Unlock()
+		attempts[task.ID]++
+		switch task.ID {
+		case "a1":
+			// Succeeds on its second attempt
+			if attempts[task.ID] == 1 {
+				return errTransient
+			}
+			return nil
+		case "b1":
+			return errTransient
+		}
+		return errors.New("permanent")
+	}, func(task *Task, err error, retrying bool) {
+		mu.Lock()
+		defer mu.Unlock()
+		failures = append(failures, fmt.Sprintf("%s:%d:%t", task.ID, task.Attempts, retrying))
+	})
+	startPool(t, pool)
+	waitForEmpty(t, q)
+
+	mu.Lock()
+	defer mu.Unlock()
+	if attempts["a1"] != 2 || attempts["b1"] != 3 || attempts["c1"] != 1 {
+		t.Fatalf("unexpected attempts %v", attempts)
+	}
+	if len(failures) != 5 {
+		t.Fatalf("expected 5 failed attempts reported, got %v", failures)
+	}
+	if _, err := q.GetDeadLetter(context.Background(), "b1"); err != nil {
+		t.Fatalf("expected b1 in the dead letters, got %v", err)
+	}
+	for _, id := range []string{"a1", "c1"} {
+		if _, err := q.GetDeadLetter(context.Background(), id); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected %s not to be buried, got %v", id, err)
+		}
+	}
+}
+
+func TestPoolStopsTwice(t *testing.T) {
+	q := NewMemoryQueue()
+	pool := NewPool(q, testQueue, 2, testPolicy(1), func(task *Task) error { return nil }, nil)
+	pool.Start()
+
+	for i := 0; i < 2; i++ {
+		if err := pool.Stop(context.Background()); err != nil {
+			t.Fatalf("stopping the pool: %v", err)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package queue
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	// ErrEmpty is returned by Claim when no task is ready to be processed.
+	ErrEmpty = errors.New("queue is empty")
+	// ErrNotFound is returned when a task does not exist.
+	ErrNotFound = errors.New("task not found")
+	// ErrFull is returned by Enqueue when a queue has no room left.
+	ErrFull = errors.New("queue is full")
+	// ErrLeaseLost is returned when a worker settles a claim that is no
+	// longer its own: the lease expired and the task was put back or claimed
+	// again, or the task is gone.
+	ErrLeaseLost = errors.New("task lease was lost")
+)
+
+// Task is a unit of work persisted in a queue until a worker acknowledges it.
+type Task struct {
+	ID    string
+	Queue string
+	Kind  string
+	// PartitionKey groups tasks that must be processed one after the other,
+	// in the order they were enqueued. Tasks with different keys may run
+	// concurrently.
+	PartitionKey string
+	Payload      []byte
+	Attempts     int
+	CreatedAt    time.Time
+}
+
+// DeadLetter is a task that kept failing until its attempts were exhausted.
+type DeadLetter struct {
+	Task
+	Error    string
+	FailedAt time.Time
+}
+
+// Queue stores tasks durably so that work accepted by the API survives a
+// restart. A claimed task stays invisible to other workers for the lease
+// duration; if it is not acknowledged before the lease expires it becomes
+// claimable again. Claim only hands out the oldest task of a partition, so a
+// partition never has more than one task in flight.
+//
+// Every claim counts an attempt, so the Attempts of a claimed task identify
+// the claim. Ack, Extend, Retry and Bury take them as attempt and return
+// ErrLeaseLost unless the task is still claimed under that attempt, so that a
+// worker whose lease expired cannot settle the claim of another.
+type Queue interface {
+	Enqueue(ctx context.Context, task Task) error
+	Claim(ctx context.Context, queueName string, lease time.Duration) (*Task, error)
+	Ack(ctx context.Context, taskID string, attempt int) error
+	// Extend pushes back the lease of a claimed task so that a long running
+	// task is not claimed a second time.
+	Extend(ctx context.Context, taskID string, attempt int, lease time.Duration) error
+	// Retry releases a claimed task so that it can be claimed again once
+	// delay has passed. It stays at the head of its partition meanwhile.
+	Retry(ctx context.Context, taskID string, attempt int, delay time.Duration, lastError string) error
+	// Bury moves a claimed task to the dead letters.
+	Bury(ctx context.Context, taskID string, attempt int, lastError string) error
+	// Recover makes every unacknowledged task whose lease has expired ready
+	// again and reports how many tasks were put back.
+	Recover(ctx context.Context, queueName string) (int64, error)
+	// Depth counts the tasks of a queue that were not acknowledged yet,
+	// including claimed and delayed ones.
+	Depth(ctx context.Context, queueName string) (int64, error)
+}
+
+// DeadLetterStore lets an operator inspect buried tasks and either put them
+// back in their queue or discard them. A replayed task is enqueued behind
+// whatever its partition received in the meantime.
+type DeadLetterStore interface {
+	ListDeadLetters(ctx context.Context, queueName string, limit int) ([]DeadLetter, error)
+	GetDeadLetter(ctx context.Context, taskID string) (*DeadLetter, error)
+	// ReplayDeadLetter requeues a dead letter. With resetStatus, the status
+	// of the task goes back to pending in the same transaction, so that it
+	// only does when the task is requeued; a status swept meanwhile stays
+	// gone.
+	ReplayDeadLetter(ctx context.Context, taskID string, resetStatus bool) error
+	DiscardDeadLetter(ctx context.Context, taskID string) error
+}
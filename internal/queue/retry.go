@@ -0,0 +1,37 @@
+package queue
+
+import (
+	"math/rand"
+	"time"
+)
+
+// RetryPolicy decides whether a failed task is attempted again and how long
+// to wait before doing so.
+type RetryPolicy struct {
+	MaxAttempts int
+	BaseDelay   time.Duration
+	MaxDelay    time.Duration
+	// Retryable reports whether an error is transient. Tasks failing with any
+	// other error are not retried.
+	Retryable func(err error) bool
+}
+
+func (p RetryPolicy) shouldRetry(task *Task, err error) bool {
+	return p.Retryable != nil && p.Retryable(err) && task.Attempts < p.MaxAttempts
+}
+
+// Backoff doubles the delay on every attempt, up to MaxDelay, and adds up to
+// 20% of jitter so that tasks failing together do not retry together.
+func (p RetryPolicy) Backoff(attempt int) time.Duration {
+	delay := p.BaseDelay
+	for i := 1; i < attempt && delay < p.MaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > p.MaxDelay {
+		delay = p.MaxDelay
+	}
+	if delay <= 0 {
+		return 0
+	}
+	return delay + time.Duration(rand.Int63n(int64(delay)/5+1))
+}
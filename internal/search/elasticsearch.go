@@ -0,0 +1,342 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// MessagesIndexSettings creates the indices behind the messages alias, with
+// the fields of DocumentSchemaVersion. Bodies are split into words by the
+// standard tokenizer, then lowercased and stripped of accents, so "Café" and
+// "cafe" match each other.
+const MessagesIndexSettings = `{
+  "settings": {
+    "analysis": {
+      "analyzer": {
+        "message_body": {
+          "type": "custom",
+          "tokenizer": "standard",
+          "filter": ["lowercase", "asciifolding"]
+        }
+      }
+    }
+  },
+  "mappings": {
+    "properties": {
+      "application_id": {"type": "long"},
+      "chat_id": {"type": "long"},
+      "chat_number": {"type": "long"},
+      "message_id": {"type": "long"},
+      "number": {"type": "long"},
+      "body": {"type": "text", "analyzer": "message_body"},
+      "created_at": {"type": "date"},
+      "updated_at": {"type": "date"}
+    }
+  }
+}`
+
+// externalVersion lets a document replace those with the same or a lower
+// version. Replacing the same version keeps publishing a change twice
+// harmless.
+const externalVersion = "external_gte"
+
+type ElasticsearchSearcher struct {
+	client     *elasticsearch.Client
+	index      string
+	chatsIndex string
+}
+
+func NewElasticsearchSearcher(client *elasticsearch.Client) *ElasticsearchSearcher {
+	return &ElasticsearchSearcher{client: client, index: MessagesIndex, chatsIndex: ChatsIndex}
+}
+
+func documentID(chatId int64, messageId int64) string {
+	return fmt.Sprintf("%d-%d", chatId, messageId)
+}
+
+func documentSource(doc MessageDocument) map[string]interface{} {
+	return map[string]interface{}{
+		"application_id": doc.ApplicationId,
+		"chat_id":        doc.ChatId,
+		"chat_number":    doc.ChatNumber,
+		"message_id":     doc.MessageId,
+		"number":         doc.Number,
+		"body":           doc.Body,
+		"created_at":     doc.CreatedAt,
+		"updated_at":     doc.UpdatedAt,
+	}
+}
+
+func (s *ElasticsearchSearcher) IndexMessage(ctx context.Context, doc MessageDocument) error {
+	data, err := json.Marshal(documentSource(doc))
+	if err != nil {
+		return fmt.Errorf("failed to encode message document: %w", err)
+	}
+
+	options := []func(*esapi.IndexRequest){
+		s.client.Index.WithContext(ctx),
+		s.client.Index.WithDocumentID(documentID(doc.ChatId, doc.MessageId)),
+	}
+	if doc.Version != 0 {
+		options = append(options, s.client.Index.WithVersion(int(doc.Version)), s.client.Index.WithVersionType(externalVersion))
+	}
+	res, err := s.client.Index(s.index, bytes.NewReader(data), options...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// A conflict means a newer version is already indexed
+	if res.IsError() && res.StatusCode != http.StatusConflict {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+	return nil
+}
+
+func (s *ElasticsearchSearcher) DeleteMessage(ctx context.Context, doc MessageDocument) error {
+	options := []func(*esapi.DeleteRequest){s.client.Delete.WithContext(ctx)}
+	if doc.Version != 0 {
+		options = append(options, s.client.Delete.WithVersion(int(doc.Version)), s.client.Delete.WithVersionType(externalVersion))
+	}
+	res, err := s.client.Delete(s.index, documentID(doc.ChatId, doc.MessageId), options...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// A message that was never indexed has nothing to delete, and a conflict
+	// means it was restored or changed since
+	if res.IsError() && res.StatusCode != http.StatusNotFound && res.StatusCode != http.StatusConflict {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+	return nil
+}
+
+func (s *ElasticsearchSearcher) DeleteChats(ctx context.Context, chatIds []int64) error {
+	if len(chatIds) == 0 {
+		return nil
+	}
+	query := map[string]interface{}{
+		"query": map[string]interface{}{
+			"terms": map[string]interface{}{
+				"chat_id": chatIds,
+			},
+		},
+	}
+	data, _ := json.Marshal(query)
+
+	// Message and chat documents both hold their chat id
+	res, err := s.client.DeleteByQuery(
+		[]string{s.index, s.chatsIndex},
+		bytes.NewReader(data),
+		s.client.DeleteByQuery.WithContext(ctx),
+		s.client.DeleteByQuery.WithConflicts("proceed"),
+	)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+	return nil
+}
+
+func (s *ElasticsearchSearcher) SearchMessages(ctx context.Context, query Query) (Result, error) {
+	preTag, postTag := query.tags()
+	boolQuery := map[string]interface{}{
+		"filter": queryFilters(query),
+		"must": []interface{}{
+			bodyQuery(query),
+		},
+	}
+	searchQuery := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": boolQuery,
+		},
+		"from": query.From,
+		"size": query.size(),
+		"sort": sortOrder(query.Sort),
+		// Scores are still reported when sorting by date
+		"track_scores": true,
+		"highlight": map[string]interface{}{
+			"pre_tags":  []string{preTag},
+			"post_tags": []string{postTag},
+			"fields": map[string]interface{}{
+				// Highlight the whole body rather than fragments of it
+				"body": map[string]interface{}{"number_of_fragments": 0},
+			},
+		},
+	}
+	if query.CountChats {
+		searchQuery["aggs"] = map[string]interface{}{
+			"chats": map[string]interface{}{
+				"terms": map[string]interface{}{
+					"field": "chat_number",
+					"size":  MaxChatCounts,
+					"order": []interface{}{
+						map[string]interface{}{"_count": "desc"},
+						map[string]interface{}{"_key": "asc"},
+					},
+				},
+			},
+		}
+	}
+
+	reqBody, _ := json.Marshal(searchQuery)
+	res, err := s.client.Search(
+		s.client.Search.WithContext(ctx),
+		s.client.Search.WithIndex(s.index),
+		s.client.Search.WithBody(bytes.NewReader(reqBody)),
+		s.client.Search.WithTrackTotalHits(true),
+	)
+	if err != nil {
+		return Result{}, fmt.Errorf("failed to search messages: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return Result{}, fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Score  float64 `json:"_score"`
+				Source struct {
+					ChatNumber int64     `json:"chat_number"`
+					Number     int64     `json:"number"`
+					Body       string    `json:"body"`
+					CreatedAt  time.Time `json:"created_at"`
+				} `json:"_source"`
+				Highlight struct {
+					Body []string `json:"body"`
+				} `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+		Aggregations struct {
+			Chats struct {
+				Buckets []struct {
+					Key      int64 `json:"key"`
+					DocCount int64 `json:"doc_count"`
+				} `json:"buckets"`
+			} `json:"chats"`
+		} `json:"aggregations"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return Result{}, fmt.Errorf("failed to parse search response: %w", err)
+	}
+
+	hits := []Hit{}
+	for _, hit := range result.Hits.Hits {
+		highlight := hit.Source.Body
+		if len(hit.Highlight.Body) > 0 {
+			highlight = hit.Highlight.Body[0]
+		}
+		hits = append(hits, Hit{
+			ChatNumber: hit.Source.ChatNumber,
+			Number:     hit.Source.Number,
+			Body:       hit.Source.Body,
+			CreatedAt:  hit.Source.CreatedAt,
+			Score:      hit.Score,
+			Highlight:  highlight,
+		})
+	}
+	searchResult := Result{Total: result.Hits.Total.Value, Hits: hits}
+	if query.CountChats {
+		searchResult.ChatCounts = []ChatCount{}
+		for _, bucket := range result.Aggregations.Chats.Buckets {
+			searchResult.ChatCounts = append(searchResult.ChatCounts, ChatCount{ChatNumber: bucket.Key, Count: bucket.DocCount})
+		}
+	}
+	return searchResult, nil
+}
+
+// queryFilters restricts a search to the chat or application of a query and
+// to its chat numbers and creation dates.
+func queryFilters(query Query) []interface{} {
+	filters := []interface{}{}
+	if query.ApplicationId != 0 {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{
+				"application_id": query.ApplicationId,
+			},
+		})
+	} else {
+		filters = append(filters, map[string]interface{}{
+			"term": map[string]interface{}{
+				"chat_id": query.ChatId,
+			},
+		})
+	}
+	if len(query.ChatNumbers) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"terms": map[string]interface{}{
+				"chat_number": query.ChatNumbers,
+			},
+		})
+	}
+
+	created := map[string]interface{}{}
+	if !query.CreatedFrom.IsZero() {
+		created["gte"] = query.CreatedFrom
+	}
+	if !query.CreatedTo.IsZero() {
+		created["lte"] = query.CreatedTo
+	}
+	if len(created) > 0 {
+		filters = append(filters, map[string]interface{}{
+			"range": map[string]interface{}{
+				"created_at": created,
+			},
+		})
+	}
+	return filters
+}
+
+// sortOrder sorts by score or by message id, the order messages are created
+// in. Ties are broken by message id.
+func sortOrder(sort string) []interface{} {
+	switch sort {
+	case SortNewest:
+		return []interface{}{map[string]interface{}{"message_id": "desc"}}
+	case SortOldest:
+		return []interface{}{map[string]interface{}{"message_id": "asc"}}
+	}
+	return []interface{}{"_score", map[string]interface{}{"message_id": "asc"}}
+}
+
+// bodyQuery matches the text of a query against message bodies, according
+// to its mode.
+func bodyQuery(query Query) map[string]interface{} {
+	if query.Mode == ModePhrase {
+		return map[string]interface{}{
+			"match_phrase": map[string]interface{}{
+				"body": query.Text,
+			},
+		}
+	}
+
+	match := map[string]interface{}{
+		"query": query.Text,
+	}
+	if query.Fuzziness != "" {
+		match["fuzziness"] = query.Fuzziness
+	}
+	kind := "match"
+	if query.Mode == ModePrefix {
+		kind = "match_bool_prefix"
+	}
+	return map[string]interface{}{
+		kind: map[string]interface{}{
+			"body": match,
+		},
+	}
+}
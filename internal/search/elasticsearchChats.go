@@ -0,0 +1,173 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+
+	"github.com/elastic/go-elasticsearch/v8/esapi"
+)
+
+// ChatsIndexSettings creates the indices behind the chats alias. Subjects are analyzed like
+// message bodies, into a search_as_you_type field whose prefixes and word
+// pairs and triples are indexed too, so that type-ahead queries stay fast.
+const ChatsIndexSettings = `{
+  "settings": {
+    "analysis": {
+      "analyzer": {
+        "chat_subject": {
+          "type": "custom",
+          "tokenizer": "standard",
+          "filter": ["lowercase", "asciifolding"]
+        }
+      }
+    }
+  },
+  "mappings": {
+    "properties": {
+      "application_id": {"type": "long"},
+      "chat_id": {"type": "long"},
+      "number": {"type": "long"},
+      "subject": {"type": "search_as_you_type", "analyzer": "chat_subject"}
+    }
+  }
+}`
+
+func chatSource(doc ChatDocument) map[string]interface{} {
+	return map[string]interface{}{
+		"application_id": doc.ApplicationId,
+		"chat_id":        doc.ChatId,
+		"number":         doc.Number,
+		"subject":        doc.Subject,
+	}
+}
+
+func (s *ElasticsearchSearcher) IndexChat(ctx context.Context, doc ChatDocument) error {
+	data, err := json.Marshal(chatSource(doc))
+	if err != nil {
+		return fmt.Errorf("failed to encode chat document: %w", err)
+	}
+
+	options := []func(*esapi.IndexRequest){
+		s.client.Index.WithContext(ctx),
+		s.client.Index.WithDocumentID(strconv.FormatInt(doc.ChatId, 10)),
+	}
+	if doc.Version != 0 {
+		options = append(options, s.client.Index.WithVersion(int(doc.Version)), s.client.Index.WithVersionType(externalVersion))
+	}
+	res, err := s.client.Index(s.chatsIndex, bytes.NewReader(data), options...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	// A conflict means a newer version is already indexed
+	if res.IsError() && res.StatusCode != http.StatusConflict {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+	return nil
+}
+
+func (s *ElasticsearchSearcher) DeleteChat(ctx context.Context, doc ChatDocument) error {
+	options := []func(*esapi.DeleteRequest){s.client.Delete.WithContext(ctx)}
+	if doc.Version != 0 {
+		options = append(options, s.client.Delete.WithVersion(int(doc.Version)), s.client.Delete.WithVersionType(externalVersion))
+	}
+	res, err := s.client.Delete(s.chatsIndex, strconv.FormatInt(doc.ChatId, 10), options...)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.IsError() && res.StatusCode != http.StatusNotFound && res.StatusCode != http.StatusConflict {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+	return nil
+}
+
+// SearchChats matches subjects with a bool_prefix query over the fields of
+// search_as_you_type, which scores the words of the query like a match query
+// and gives the prefix a constant score.
+func (s *ElasticsearchSearcher) SearchChats(ctx context.Context, query ChatQuery) (ChatResult, error) {
+	preTag, postTag := query.tags()
+	searchQuery := map[string]interface{}{
+		"query": map[string]interface{}{
+			"bool": map[string]interface{}{
+				"filter": []interface{}{
+					map[string]interface{}{
+						"term": map[string]interface{}{
+							"application_id": query.ApplicationId,
+						},
+					},
+				},
+				"must": []interface{}{
+					map[string]interface{}{
+						"multi_match": map[string]interface{}{
+							"query":    query.Text,
+							"type":     "bool_prefix",
+							"operator": "and",
+							"fields":   []string{"subject", "subject._2gram", "subject._3gram"},
+						},
+					},
+				},
+			},
+		},
+		"from": query.From,
+		"size": query.size(),
+		"sort": []interface{}{"_score", map[string]interface{}{"number": "asc"}},
+		"highlight": map[string]interface{}{
+			"pre_tags":  []string{preTag},
+			"post_tags": []string{postTag},
+			"fields": map[string]interface{}{
+				"subject": map[string]interface{}{"number_of_fragments": 0},
+			},
+		},
+	}
+
+	reqBody, _ := json.Marshal(searchQuery)
+	res, err := s.client.Search(
+		s.client.Search.WithContext(ctx),
+		s.client.Search.WithIndex(s.chatsIndex),
+		s.client.Search.WithBody(bytes.NewReader(reqBody)),
+		s.client.Search.WithTrackTotalHits(true),
+	)
+	if err != nil {
+		return ChatResult{}, fmt.Errorf("failed to search chats: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return ChatResult{}, fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	var result struct {
+		Hits struct {
+			Total struct {
+				Value int64 `json:"value"`
+			} `json:"total"`
+			Hits []struct {
+				Score  float64 `json:"_score"`
+				Source struct {
+					Number  int64  `json:"number"`
+					Subject string `json:"subject"`
+				} `json:"_source"`
+				Highlight struct {
+					Subject []string `json:"subject"`
+				} `json:"highlight"`
+			} `json:"hits"`
+		} `json:"hits"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return ChatResult{}, fmt.Errorf("failed to parse search response: %w", err)
+	}
+
+	hits := []ChatHit{}
+	for _, hit := range result.Hits.Hits {
+		highlight := hit.Source.Subject
+		if len(hit.Highlight.Subject) > 0 {
+			highlight = hit.Highlight.Subject[0]
+		}
+		hits = append(hits, ChatHit{Number: hit.Source.Number, Subject: hit.Source.Subject, Score: hit.Score, Highlight: highlight})
+	}
+	return ChatResult{Total: result.Hits.Total.Value, Hits: hits}, nil
+}
@@ -0,0 +1,281 @@
+package search
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/elastic/go-elasticsearch/v8"
+)
+
+// CreateMessagesIndex creates an index with MessagesIndexSettings, named
+// after MessagesIndex and the time, and records DocumentSchemaVersion in the
+// metadata of its mapping. When live is set, the index is created behind the
+// MessagesIndex alias, which searches and writes go through.
+func CreateMessagesIndex(ctx context.Context, client *elasticsearch.Client, live bool) (string, error) {
+	return createIndex(ctx, client, MessagesIndex, MessagesIndexSettings, map[string]interface{}{"schema_version": DocumentSchemaVersion}, live)
+}
+
+// CreateChatsIndex creates an index with ChatsIndexSettings, named after
+// ChatsIndex and the time, behind the ChatsIndex alias when live is set.
+func CreateChatsIndex(ctx context.Context, client *elasticsearch.Client, live bool) (string, error) {
+	return createIndex(ctx, client, ChatsIndex, ChatsIndexSettings, nil, live)
+}
+
+// createIndex creates an index named after alias and the time, with settings
+// and the metadata meta in its mapping, behind alias when live is set.
+func createIndex(ctx context.Context, client *elasticsearch.Client, alias string, settingsJSON string, meta map[string]interface{}, live bool) (string, error) {
+	var settings map[string]interface{}
+	if err := json.Unmarshal([]byte(settingsJSON), &settings); err != nil {
+		return "", fmt.Errorf("failed to decode index settings: %w", err)
+	}
+	if meta != nil {
+		mappings := settings["mappings"].(map[string]interface{})
+		mappings["_meta"] = meta
+	}
+	if live {
+		settings["aliases"] = map[string]interface{}{alias: map[string]interface{}{}}
+	}
+	body, _ := json.Marshal(settings)
+
+	name := fmt.Sprintf("%s-%d", alias, time.Now().UnixNano())
+	res, err := client.Indices.Create(name, client.Indices.Create.WithContext(ctx), client.Indices.Create.WithBody(bytes.NewReader(body)))
+	if err != nil {
+		return "", fmt.Errorf("failed to create index %s: %w", name, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return "", fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+	return name, nil
+}
+
+// IndexSchemaVersion returns the lowest document schema version of the
+// indices behind MessagesIndex. Indices created before schemas were versioned
+// have version 1.
+func IndexSchemaVersion(ctx context.Context, client *elasticsearch.Client) (int, error) {
+	res, err := client.Indices.GetMapping(client.Indices.GetMapping.WithContext(ctx), client.Indices.GetMapping.WithIndex(MessagesIndex))
+	if err != nil {
+		return 0, fmt.Errorf("failed to get the mapping of %s: %w", MessagesIndex, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return 0, fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	var indices map[string]struct {
+		Mappings struct {
+			Meta struct {
+				SchemaVersion int `json:"schema_version"`
+			} `json:"_meta"`
+		} `json:"mappings"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&indices); err != nil {
+		return 0, fmt.Errorf("failed to parse mapping: %w", err)
+	}
+	lowest := DocumentSchemaVersion
+	for _, index := range indices {
+		lowest = min(lowest, max(index.Mappings.Meta.SchemaVersion, 1))
+	}
+	return lowest, nil
+}
+
+func (s *ElasticsearchSearcher) WriteBatch(ctx context.Context, batch Batch) error {
+	return bulk(ctx, s.client, s.index, s.chatsIndex, batch)
+}
+
+// bulk sends a batch in a single bulk request, the messages to index and the
+// chats to chatsIndex, and reports the first write that failed. Deleting a
+// document that is not indexed is not a failure, nor is a write losing to a
+// newer version.
+func bulk(ctx context.Context, client *elasticsearch.Client, index string, chatsIndex string, batch Batch) error {
+	if len(batch.Index) == 0 && len(batch.Delete) == 0 && len(batch.IndexChats) == 0 && len(batch.DeleteChats) == 0 {
+		return nil
+	}
+	lines := []interface{}{}
+	for _, doc := range batch.Index {
+		lines = append(lines, map[string]interface{}{"index": bulkMetadata(index, documentID(doc.ChatId, doc.MessageId), doc.Version)}, documentSource(doc))
+	}
+	for _, doc := range batch.Delete {
+		lines = append(lines, map[string]interface{}{"delete": bulkMetadata(index, documentID(doc.ChatId, doc.MessageId), doc.Version)})
+	}
+	for _, doc := range batch.IndexChats {
+		lines = append(lines, map[string]interface{}{"index": bulkMetadata(chatsIndex, strconv.FormatInt(doc.ChatId, 10), doc.Version)}, chatSource(doc))
+	}
+	for _, doc := range batch.DeleteChats {
+		lines = append(lines, map[string]interface{}{"delete": bulkMetadata(chatsIndex, strconv.FormatInt(doc.ChatId, 10), doc.Version)})
+	}
+	var body bytes.Buffer
+	encoder := json.NewEncoder(&body)
+	for _, line := range lines {
+		if err := encoder.Encode(line); err != nil {
+			return fmt.Errorf("failed to encode bulk request: %w", err)
+		}
+	}
+
+	res, err := client.Bulk(&body, client.Bulk.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to send bulk request: %w", err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	var result struct {
+		Errors bool `json:"errors"`
+		Items  []map[string]struct {
+			ID     string `json:"_id"`
+			Status int    `json:"status"`
+			Error  struct {
+				Reason string `json:"reason"`
+			} `json:"error"`
+		} `json:"items"`
+	}
+	if err := json.NewDecoder(res.Body).Decode(&result); err != nil {
+		return fmt.Errorf("failed to parse bulk response: %w", err)
+	}
+	if !result.Errors {
+		return nil
+	}
+	for _, item := range result.Items {
+		for action, outcome := range item {
+			if outcome.Status < 300 || outcome.Status == http.StatusConflict || (action == "delete" && outcome.Status == http.StatusNotFound) {
+				continue
+			}
+			return fmt.Errorf("failed to %s document %s: %s", action, outcome.ID, outcome.Error.Reason)
+		}
+	}
+	return nil
+}
+
+func bulkMetadata(index string, id string, version int64) map[string]interface{} {
+	metadata := map[string]interface{}{"_index": index, "_id": id}
+	if version != 0 {
+		metadata["version"] = version
+		metadata["version_type"] = externalVersion
+	}
+	return metadata
+}
+
+func (s *ElasticsearchSearcher) BeginRebuild(ctx context.Context) (Rebuild, error) {
+	index, err := CreateMessagesIndex(ctx, s.client, false)
+	if err != nil {
+		return nil, err
+	}
+	chatsIndex, err := CreateChatsIndex(ctx, s.client, false)
+	if err != nil {
+		deleteIndices(ctx, s.client, index)
+		return nil, err
+	}
+	return &elasticsearchRebuild{client: s.client, index: index, chatsIndex: chatsIndex}, nil
+}
+
+// elasticsearchRebuild fills a messages index and a chats index, swapped in
+// together.
+type elasticsearchRebuild struct {
+	client     *elasticsearch.Client
+	index      string
+	chatsIndex string
+}
+
+func (r *elasticsearchRebuild) WriteBatch(ctx context.Context, batch Batch) error {
+	return bulk(ctx, r.client, r.index, r.chatsIndex, batch)
+}
+
+// Commit moves the MessagesIndex and ChatsIndex aliases to the rebuilt
+// indices and deletes the indices they pointed to, in a single atomic
+// request. An index named after an alias, created before the alias existed,
+// is replaced the same way.
+func (r *elasticsearchRebuild) Commit(ctx context.Context) error {
+	res, err := r.client.Indices.Refresh(r.client.Indices.Refresh.WithContext(ctx), r.client.Indices.Refresh.WithIndex(r.index, r.chatsIndex))
+	if err != nil {
+		return fmt.Errorf("failed to refresh indices %s and %s: %w", r.index, r.chatsIndex, err)
+	}
+	res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	actions := []interface{}{}
+	for _, swap := range []struct{ alias, index string }{{MessagesIndex, r.index}, {ChatsIndex, r.chatsIndex}} {
+		alias, index := swap.alias, swap.index
+		live, err := r.liveIndices(ctx, alias, index)
+		if err != nil {
+			return err
+		}
+		actions = append(actions, map[string]interface{}{"add": map[string]interface{}{"index": index, "alias": alias}})
+		for _, old := range live {
+			actions = append(actions, map[string]interface{}{"remove_index": map[string]interface{}{"index": old}})
+		}
+	}
+	body, _ := json.Marshal(map[string]interface{}{"actions": actions})
+
+	res, err = r.client.Indices.UpdateAliases(bytes.NewReader(body), r.client.Indices.UpdateAliases.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to swap the %s and %s aliases: %w", MessagesIndex, ChatsIndex, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+	return nil
+}
+
+// liveIndices returns the indices searches currently go to through alias,
+// other than rebuilt.
+func (r *elasticsearchRebuild) liveIndices(ctx context.Context, alias string, rebuilt string) ([]string, error) {
+	res, err := r.client.Indices.GetAlias(r.client.Indices.GetAlias.WithContext(ctx), r.client.Indices.GetAlias.WithName(alias))
+	if err != nil {
+		return nil, fmt.Errorf("failed to get the %s alias: %w", alias, err)
+	}
+	defer res.Body.Close()
+
+	if res.StatusCode == http.StatusNotFound {
+		// No alias yet: the live index, if any, has the name of the alias
+		exists, err := r.client.Indices.Exists([]string{alias}, r.client.Indices.Exists.WithContext(ctx))
+		if err != nil {
+			return nil, fmt.Errorf("failed to check index %s: %w", alias, err)
+		}
+		exists.Body.Close()
+		if exists.StatusCode == http.StatusOK {
+			return []string{alias}, nil
+		}
+		return nil, nil
+	}
+	if res.IsError() {
+		return nil, fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+
+	var aliases map[string]interface{}
+	if err := json.NewDecoder(res.Body).Decode(&aliases); err != nil {
+		return nil, fmt.Errorf("failed to parse aliases: %w", err)
+	}
+	indices := []string{}
+	for index := range aliases {
+		if index != rebuilt {
+			indices = append(indices, index)
+		}
+	}
+	return indices, nil
+}
+
+func (r *elasticsearchRebuild) Abort(ctx context.Context) error {
+	return deleteIndices(ctx, r.client, r.index, r.chatsIndex)
+}
+
+func deleteIndices(ctx context.Context, client *elasticsearch.Client, indices ...string) error {
+	res, err := client.Indices.Delete(indices, client.Indices.Delete.WithContext(ctx))
+	if err != nil {
+		return fmt.Errorf("failed to delete indices %v: %w", indices, err)
+	}
+	defer res.Body.Close()
+	if res.IsError() {
+		return fmt.Errorf("elasticsearch responded with %s", res.Status())
+	}
+	return nil
+}
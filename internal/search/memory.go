@@ -0,0 +1,576 @@
+package search
+
+import (
+	"context"
+	"math"
+	"slices"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"unicode"
+	"unicode/utf8"
+
+	"golang.org/x/text/runes"
+	"golang.org/x/text/transform"
+	"golang.org/x/text/unicode/norm"
+)
+
+// BM25 parameters, the defaults of Elasticsearch
+const (
+	bm25K1 = 1.2
+	bm25B  = 0.75
+)
+
+// prefixScore is the constant score of a prefix match, as Elasticsearch
+// does not rank the words a prefix expands to.
+const prefixScore = 1.0
+
+type documentKey struct {
+	chatId    int64
+	messageId int64
+}
+
+type indexedDocument struct {
+	MessageDocument
+	// length is the number of terms of the body
+	length int
+}
+
+// MemorySearcher is an inverted index held in process memory. It needs no
+// cluster, which makes it suitable for tests and single-instance setups, but
+// it starts empty on every run.
+//
+// Bodies are analyzed roughly like the message_body analyzer of
+// MessagesIndexSettings: split into terms on anything that is not a letter or
+// a digit, lowercased and stripped of accents. Each term maps to the
+// documents containing it and the positions it occurs at, and matches are
+// ranked with BM25.
+type MemorySearcher struct {
+	mu          sync.RWMutex
+	documents   map[documentKey]indexedDocument
+	totalLength int
+	postings    map[string]map[documentKey][]int
+	// versions holds the version of the last write of every document, deletes
+	// included, like the external versions of Elasticsearch.
+	versions map[documentKey]int64
+	// chats is the chats index, by chat id. It is small enough to be scanned
+	// by every search.
+	chats map[int64]ChatDocument
+	// chatVersions holds the versions of the chat documents like versions.
+	chatVersions map[int64]int64
+}
+
+func NewMemorySearcher() *MemorySearcher {
+	return &MemorySearcher{
+		documents:    map[documentKey]indexedDocument{},
+		postings:     map[string]map[documentKey][]int{},
+		versions:     map[documentKey]int64{},
+		chats:        map[int64]ChatDocument{},
+		chatVersions: map[int64]int64{},
+	}
+}
+
+// token is a term of a text and the bytes of the text it was read from.
+type token struct {
+	term  string
+	start int
+	end   int
+}
+
+// analyze splits text into lowercase terms without accents.
+func analyze(text string) []token {
+	tokens := []token{}
+	start := -1
+	for i, r := range text {
+		if unicode.IsLetter(r) || unicode.IsNumber(r) || unicode.Is(unicode.Mn, r) {
+			if start < 0 {
+				start = i
+			}
+			continue
+		}
+		if start >= 0 {
+			tokens = append(tokens, token{term: fold(text[start:i]), start: start, end: i})
+			start = -1
+		}
+	}
+	if start >= 0 {
+		tokens = append(tokens, token{term: fold(text[start:]), start: start, end: len(text)})
+	}
+	return tokens
+}
+
+func tokenize(text string) []string {
+	tokens := analyze(text)
+	terms := make([]string, len(tokens))
+	for i, token := range tokens {
+		terms[i] = token.term
+	}
+	return terms
+}
+
+// fold lowercases a word and strips its accents.
+func fold(word string) string {
+	folding := transform.Chain(norm.NFD, runes.Remove(runes.In(unicode.Mn)), norm.NFC)
+	if folded, _, err := transform.String(folding, word); err == nil {
+		word = folded
+	}
+	return strings.ToLower(word)
+}
+
+// match is how well a document matches a query and the positions of the
+// terms that matched.
+type match struct {
+	score     float64
+	positions map[int]bool
+}
+
+type matches map[documentKey]*match
+
+func (m matches) add(key documentKey, score float64, positions ...int) {
+	if m[key] == nil {
+		m[key] = &match{positions: map[int]bool{}}
+	}
+	m[key].score += score
+	for _, position := range positions {
+		m[key].positions[position] = true
+	}
+}
+
+func (s *MemorySearcher) IndexMessage(ctx context.Context, doc MessageDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.add(doc)
+	return nil
+}
+
+func (s *MemorySearcher) WriteBatch(ctx context.Context, batch Batch) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, doc := range batch.Index {
+		s.add(doc)
+	}
+	for _, doc := range batch.Delete {
+		s.delete(doc)
+	}
+	for _, doc := range batch.IndexChats {
+		s.addChat(doc)
+	}
+	for _, doc := range batch.DeleteChats {
+		s.deleteChat(doc)
+	}
+	return nil
+}
+
+// BeginRebuild fills a second MemorySearcher, whose contents replace those
+// of s on Commit, except for the documents written to s at a higher version
+// in the meantime.
+func (s *MemorySearcher) BeginRebuild(ctx context.Context) (Rebuild, error) {
+	return &memoryRebuild{live: s, next: NewMemorySearcher()}, nil
+}
+
+type memoryRebuild struct {
+	live *MemorySearcher
+	next *MemorySearcher
+}
+
+func (r *memoryRebuild) WriteBatch(ctx context.Context, batch Batch) error {
+	return r.next.WriteBatch(ctx, batch)
+}
+
+func (r *memoryRebuild) Commit(ctx context.Context) error {
+	r.next.mu.Lock()
+	defer r.next.mu.Unlock()
+	r.live.mu.Lock()
+	defer r.live.mu.Unlock()
+
+	// Writes to the live index during the copy, deletes included, win over
+	// the copy when they are newer, as the versions of Elasticsearch do
+	for key, version := range r.live.versions {
+		if version <= r.next.versions[key] {
+			continue
+		}
+		r.next.remove(key)
+		r.next.versions[key] = version
+		if doc, ok := r.live.documents[key]; ok {
+			r.next.add(doc.MessageDocument)
+		}
+	}
+	for chatId, version := range r.live.chatVersions {
+		if version <= r.next.chatVersions[chatId] {
+			continue
+		}
+		delete(r.next.chats, chatId)
+		r.next.chatVersions[chatId] = version
+		if doc, ok := r.live.chats[chatId]; ok {
+			r.next.chats[chatId] = doc
+		}
+	}
+	r.live.documents, r.live.totalLength, r.live.postings = r.next.documents, r.next.totalLength, r.next.postings
+	r.live.versions = r.next.versions
+	r.live.chats, r.live.chatVersions = r.next.chats, r.next.chatVersions
+	return nil
+}
+
+func (r *memoryRebuild) Abort(ctx context.Context) error {
+	return nil
+}
+
+// add indexes a document, replacing any lower version. The caller holds mu.
+func (s *MemorySearcher) add(doc MessageDocument) {
+	key := documentKey{chatId: doc.ChatId, messageId: doc.MessageId}
+	if !s.newer(key, doc.Version) {
+		return
+	}
+	s.remove(key)
+	terms := tokenize(doc.Body)
+	s.documents[key] = indexedDocument{MessageDocument: doc, length: len(terms)}
+	s.totalLength += len(terms)
+	for position, term := range terms {
+		if s.postings[term] == nil {
+			s.postings[term] = map[documentKey][]int{}
+		}
+		s.postings[term][key] = append(s.postings[term][key], position)
+	}
+}
+
+func (s *MemorySearcher) DeleteMessage(ctx context.Context, doc MessageDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.delete(doc)
+	return nil
+}
+
+// delete removes a document unless it has a higher version. The caller holds
+// mu.
+func (s *MemorySearcher) delete(doc MessageDocument) {
+	key := documentKey{chatId: doc.ChatId, messageId: doc.MessageId}
+	if s.newer(key, doc.Version) {
+		s.remove(key)
+	}
+}
+
+// newer tells whether a write at version may replace the last one of a
+// document, and records it if so. The caller holds mu.
+func (s *MemorySearcher) newer(key documentKey, version int64) bool {
+	return newerVersion(s.versions, key, version)
+}
+
+// newerVersion tells whether a write at version may replace the last one
+// recorded in versions under key, and records it if so.
+func newerVersion[K comparable](versions map[K]int64, key K, version int64) bool {
+	if version == 0 {
+		return true
+	}
+	if version < versions[key] {
+		return false
+	}
+	versions[key] = version
+	return true
+}
+
+func (s *MemorySearcher) DeleteChats(ctx context.Context, chatIds []int64) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	deleted := map[int64]bool{}
+	for _, chatId := range chatIds {
+		deleted[chatId] = true
+	}
+	// The versions are kept, so that a late write cannot bring a message back
+	for key := range s.documents {
+		if deleted[key.chatId] {
+			s.remove(key)
+		}
+	}
+	for chatId := range deleted {
+		delete(s.chats, chatId)
+	}
+	return nil
+}
+
+// SearchMessages ranks the messages of a chat or an application the way the queries of
+// ElasticsearchSearcher do: every word of the query adds the score of its
+// best matching term, and a phrase adds the score of its words wherever they
+// follow each other.
+func (s *MemorySearcher) SearchMessages(ctx context.Context, query Query) (Result, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := tokenize(query.Text)
+	found := matches{}
+	if query.Mode == ModePhrase {
+		s.matchPhrase(query, terms, found)
+	} else {
+		for i, term := range terms {
+			if query.Mode == ModePrefix && i == len(terms)-1 {
+				s.matchPrefix(query, term, found)
+			} else {
+				s.matchTerm(query, term, maxEdits(query.Fuzziness, term), found)
+			}
+		}
+	}
+
+	keys := make([]documentKey, 0, len(found))
+	for key := range found {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		switch query.Sort {
+		case SortNewest:
+			return keys[i].messageId > keys[j].messageId
+		case SortOldest:
+			return keys[i].messageId < keys[j].messageId
+		}
+		if found[keys[i]].score != found[keys[j]].score {
+			return found[keys[i]].score > found[keys[j]].score
+		}
+		return keys[i].messageId < keys[j].messageId
+	})
+	page := keys[min(query.From, len(keys)):min(query.From+query.size(), len(keys))]
+
+	preTag, postTag := query.tags()
+	hits := make([]Hit, 0, len(page))
+	for _, key := range page {
+		doc := s.documents[key]
+		hits = append(hits, Hit{
+			ChatNumber: doc.ChatNumber,
+			Number:     doc.Number,
+			Body:       doc.Body,
+			CreatedAt:  doc.CreatedAt,
+			Score:      found[key].score,
+			Highlight:  highlight(doc.Body, found[key].positions, preTag, postTag),
+		})
+	}
+	result := Result{Total: int64(len(keys)), Hits: hits}
+	if query.CountChats {
+		result.ChatCounts = s.countChats(keys)
+	}
+	return result, nil
+}
+
+// selects tells whether a document is among those searched by query. The
+// caller holds mu.
+func (s *MemorySearcher) selects(query Query, key documentKey) bool {
+	doc := s.documents[key]
+	if query.ApplicationId != 0 {
+		if doc.ApplicationId != query.ApplicationId {
+			return false
+		}
+	} else if key.chatId != query.ChatId {
+		return false
+	}
+	if len(query.ChatNumbers) > 0 && !slices.Contains(query.ChatNumbers, doc.ChatNumber) {
+		return false
+	}
+	if !query.CreatedFrom.IsZero() && doc.CreatedAt.Before(query.CreatedFrom) {
+		return false
+	}
+	return query.CreatedTo.IsZero() || !doc.CreatedAt.After(query.CreatedTo)
+}
+
+// countChats counts matches by chat number like the terms aggregation of
+// ElasticsearchSearcher: most matches first, then by chat number. The caller
+// holds mu.
+func (s *MemorySearcher) countChats(keys []documentKey) []ChatCount {
+	counts := map[int64]int64{}
+	for _, key := range keys {
+		counts[s.documents[key].ChatNumber]++
+	}
+	chatCounts := make([]ChatCount, 0, len(counts))
+	for chatNumber, count := range counts {
+		chatCounts = append(chatCounts, ChatCount{ChatNumber: chatNumber, Count: count})
+	}
+	sort.Slice(chatCounts, func(i, j int) bool {
+		if chatCounts[i].Count != chatCounts[j].Count {
+			return chatCounts[i].Count > chatCounts[j].Count
+		}
+		return chatCounts[i].ChatNumber < chatCounts[j].ChatNumber
+	})
+	return chatCounts[:min(len(chatCounts), MaxChatCounts)]
+}
+
+// matchTerm adds to each message selected by query the score of its best
+// term within edits of term.
+func (s *MemorySearcher) matchTerm(query Query, term string, edits int, found matches) {
+	best := map[documentKey]float64{}
+	matched := map[documentKey][]int{}
+	for indexed, documents := range s.postings {
+		if indexed != term && (edits == 0 || editDistance(indexed, term) > edits) {
+			continue
+		}
+		for key, positions := range documents {
+			if !s.selects(query, key) {
+				continue
+			}
+			best[key] = math.Max(best[key], s.bm25(key, len(positions), len(documents)))
+			matched[key] = append(matched[key], positions...)
+		}
+	}
+	for key, score := range best {
+		found.add(key, score, matched[key]...)
+	}
+}
+
+// matchPrefix adds prefixScore to each message selected by query having a
+// term that starts with prefix.
+func (s *MemorySearcher) matchPrefix(query Query, prefix string, found matches) {
+	matched := map[documentKey][]int{}
+	for indexed, documents := range s.postings {
+		if !strings.HasPrefix(indexed, prefix) {
+			continue
+		}
+		for key, positions := range documents {
+			if s.selects(query, key) {
+				matched[key] = append(matched[key], positions...)
+			}
+		}
+	}
+	for key, positions := range matched {
+		found.add(key, prefixScore, positions...)
+	}
+}
+
+// matchPhrase scores the messages selected by query containing terms at
+// consecutive positions, counting how often the whole phrase occurs.
+func (s *MemorySearcher) matchPhrase(query Query, terms []string, found matches) {
+	if len(terms) == 0 {
+		return
+	}
+	for key, starts := range s.postings[terms[0]] {
+		if !s.selects(query, key) {
+			continue
+		}
+		positions := []int{}
+		for _, start := range starts {
+			if s.followedBy(key, start, terms[1:]) {
+				for offset := range terms {
+					positions = append(positions, start+offset)
+				}
+			}
+		}
+		frequency := len(positions) / len(terms)
+		if frequency == 0 {
+			continue
+		}
+		for _, term := range terms {
+			found.add(key, s.bm25(key, frequency, len(s.postings[term])), positions...)
+		}
+	}
+}
+
+// highlight wraps the words of body at the given positions in tags.
+func highlight(body string, positions map[int]bool, preTag string, postTag string) string {
+	var highlighted strings.Builder
+	last := 0
+	for position, token := range analyze(body) {
+		if !positions[position] {
+			continue
+		}
+		highlighted.WriteString(body[last:token.start])
+		highlighted.WriteString(preTag)
+		highlighted.WriteString(body[token.start:token.end])
+		highlighted.WriteString(postTag)
+		last = token.end
+	}
+	highlighted.WriteString(body[last:])
+	return highlighted.String()
+}
+
+// followedBy tells whether terms occur in a document right after position.
+func (s *MemorySearcher) followedBy(key documentKey, position int, terms []string) bool {
+	for offset, term := range terms {
+		found := false
+		for _, p := range s.postings[term][key] {
+			if p == position+offset+1 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// bm25 scores a term occurring frequency times in a message, matching being
+// the number of messages containing the term.
+func (s *MemorySearcher) bm25(key documentKey, frequency int, matching int) float64 {
+	return bm25Score(frequency, matching, len(s.documents), s.documents[key].length, float64(s.totalLength)/float64(len(s.documents)))
+}
+
+// bm25Score scores a term occurring frequency times in a document of length
+// terms, out of count documents averaging averageLength terms, matching of
+// which contain the term.
+func bm25Score(frequency int, matching int, count int, length int, averageLength float64) float64 {
+	idf := math.Log(1 + (float64(count)-float64(matching)+0.5)/(float64(matching)+0.5))
+	tf := float64(frequency)
+	lengthNorm := bm25K1 * (1 - bm25B + bm25B*float64(length)/averageLength)
+	return idf * tf / (tf + lengthNorm)
+}
+
+// maxEdits turns a fuzziness into the number of typos allowed in term. AUTO
+// allows none up to 2 characters, one up to 5 and two beyond.
+func maxEdits(fuzziness string, term string) int {
+	if fuzziness == "AUTO" {
+		switch length := utf8.RuneCountInString(term); {
+		case length < 3:
+			return 0
+		case length < 6:
+			return 1
+		}
+		return 2
+	}
+	edits, err := strconv.Atoi(fuzziness)
+	if err != nil {
+		return 0
+	}
+	return edits
+}
+
+// editDistance counts the insertions, deletions, substitutions and swaps of
+// adjacent characters turning a into b.
+func editDistance(a string, b string) int {
+	x, y := []rune(a), []rune(b)
+	previous2 := make([]int, len(y)+1)
+	previous := make([]int, len(y)+1)
+	current := make([]int, len(y)+1)
+	for j := range previous {
+		previous[j] = j
+	}
+	for i := 1; i <= len(x); i++ {
+		current[0] = i
+		for j := 1; j <= len(y); j++ {
+			cost := 1
+			if x[i-1] == y[j-1] {
+				cost = 0
+			}
+			current[j] = min(previous[j]+1, current[j-1]+1, previous[j-1]+cost)
+			if i > 1 && j > 1 && x[i-1] == y[j-2] && x[i-2] == y[j-1] {
+				current[j] = min(current[j], previous2[j-2]+1)
+			}
+		}
+		previous2, previous, current = previous, current, previous2
+	}
+	return previous[len(y)]
+}
+
+// remove drops a document and its postings. The caller holds mu.
+func (s *MemorySearcher) remove(key documentKey) {
+	doc, ok := s.documents[key]
+	if !ok {
+		return
+	}
+	delete(s.documents, key)
+	s.totalLength -= doc.length
+	for _, term := range tokenize(doc.Body) {
+		delete(s.postings[term], key)
+		if len(s.postings[term]) == 0 {
+			delete(s.postings, term)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package search
+
+import (
+	"context"
+	"sort"
+	"strings"
+)
+
+func (s *MemorySearcher) IndexChat(ctx context.Context, doc ChatDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.addChat(doc)
+	return nil
+}
+
+func (s *MemorySearcher) DeleteChat(ctx context.Context, doc ChatDocument) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.deleteChat(doc)
+	return nil
+}
+
+// addChat indexes the document of a chat, replacing any lower version. The
+// caller holds mu.
+func (s *MemorySearcher) addChat(doc ChatDocument) {
+	if newerVersion(s.chatVersions, doc.ChatId, doc.Version) {
+		s.chats[doc.ChatId] = doc
+	}
+}
+
+// deleteChat removes the document of a chat unless it has a higher version.
+// The caller holds mu.
+func (s *MemorySearcher) deleteChat(doc ChatDocument) {
+	if newerVersion(s.chatVersions, doc.ChatId, doc.Version) {
+		delete(s.chats, doc.ChatId)
+	}
+}
+
+// chatMatch is a chat matching every word of a query.
+type chatMatch struct {
+	doc       ChatDocument
+	score     float64
+	positions map[int]bool
+}
+
+// SearchChats ranks subjects the way the bool_prefix query of
+// ElasticsearchSearcher does: every word of the query but the last adds its
+// BM25 score over the chats index, and the last one, taken as a prefix, adds
+// prefixScore.
+func (s *MemorySearcher) SearchChats(ctx context.Context, query ChatQuery) (ChatResult, error) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	terms := tokenize(query.Text)
+	subjects := map[int64][]string{}
+	frequencies := map[string]int{}
+	totalLength := 0
+	for chatId, doc := range s.chats {
+		subject := tokenize(doc.Subject)
+		subjects[chatId] = subject
+		totalLength += len(subject)
+		seen := map[string]bool{}
+		for _, term := range subject {
+			if !seen[term] {
+				seen[term] = true
+				frequencies[term]++
+			}
+		}
+	}
+
+	found := []chatMatch{}
+	for chatId, doc := range s.chats {
+		if doc.ApplicationId != query.ApplicationId || len(terms) == 0 {
+			continue
+		}
+		match := chatMatch{doc: doc, positions: map[int]bool{}}
+		matchesAll := true
+		for i, term := range terms {
+			prefix := i == len(terms)-1
+			frequency := 0
+			for position, word := range subjects[chatId] {
+				if word == term || (prefix && strings.HasPrefix(word, term)) {
+					match.positions[position] = true
+					frequency++
+				}
+			}
+			if frequency == 0 {
+				matchesAll = false
+				break
+			}
+			if prefix {
+				match.score += prefixScore
+			} else {
+				averageLength := float64(totalLength) / float64(len(s.chats))
+				match.score += bm25Score(frequency, frequencies[term], len(s.chats), len(subjects[chatId]), averageLength)
+			}
+		}
+		if matchesAll {
+			found = append(found, match)
+		}
+	}
+
+	sort.Slice(found, func(i, j int) bool {
+		if found[i].score != found[j].score {
+			return found[i].score > found[j].score
+		}
+		return found[i].doc.Number < found[j].doc.Number
+	})
+	page := found[min(query.From, len(found)):min(query.From+query.size(), len(found))]
+
+	preTag, postTag := query.tags()
+	hits := make([]ChatHit, 0, len(page))
+	for _, match := range page {
+		hits = append(hits, ChatHit{
+			Number:    match.doc.Number,
+			Subject:   match.doc.Subject,
+			Score:     match.score,
+			Highlight: highlight(match.doc.Subject, match.positions, preTag, postTag),
+		})
+	}
+	return ChatResult{Total: int64(len(found)), Hits: hits}, nil
+}
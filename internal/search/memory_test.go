@@ -0,0 +1,332 @@
+package search
+
+import (
+	"context"
+	"math"
+	"slices"
+	"testing"
+)
+
+func TestAnalyze(t *testing.T) {
+	tests := []struct {
+		text  string
+		terms []string
+	}{
+		{"Hello, World!", []string{"hello", "world"}},
+		{"  café  CRÈME brûlée ", []string{"cafe", "creme", "brulee"}},
+		{"e-mail at 10:30", []string{"e", "mail", "at", "10", "30"}},
+		{"Ünïcödé_names", []string{"unicode", "names"}},
+		// A combining accent belongs to the word it follows
+		{"café noir", []string{"cafe", "noir"}},
+		{"", []string{}},
+		{"?!", []string{}},
+	}
+	for _, test := range tests {
+		if terms := tokenize(test.text); !slices.Equal(terms, test.terms) {
+			t.Errorf("tokenize(%q) = %q, expected %q", test.text, terms, test.terms)
+		}
+	}
+
+	// Tokens point back at the words of the text, accents included
+	text := "Déjà vu"
+	for _, token := range analyze(text) {
+		if fold(text[token.start:token.end]) != token.term {
+			t.Errorf("token %q does not match %q", token.term, text[token.start:token.end])
+		}
+	}
+}
+
+func TestEditDistance(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		distance int
+	}{
+		{"hello", "hello", 0},
+		{"hello", "hallo", 1},
+		{"hello", "hell", 1},
+		{"hello", "helloo", 1},
+		// An adjacent swap is a single edit
+		{"hello", "hlelo", 1},
+		{"hello", "olleh", 4},
+		{"", "abc", 3},
+		{"résumé", "resume", 2},
+		{"kitten", "sitting", 3},
+	}
+	for _, test := range tests {
+		if distance := editDistance(test.a, test.b); distance != test.distance {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", test.a, test.b, distance, test.distance)
+		}
+		if distance := editDistance(test.b, test.a); distance != test.distance {
+			t.Errorf("editDistance(%q, %q) = %d, expected %d", test.b, test.a, distance, test.distance)
+		}
+	}
+}
+
+func TestMaxEdits(t *testing.T) {
+	tests := []struct {
+		fuzziness string
+		term      string
+		edits     int
+	}{
+		{"", "hello", 0},
+		{"1", "hi", 1},
+		{"2", "hello", 2},
+		{"AUTO", "hi", 0},
+		{"AUTO", "hey", 1},
+		{"AUTO", "hello", 1},
+		{"AUTO", "helloo", 2},
+		// Characters are counted, not bytes
+		{"AUTO", "éé", 0},
+		{"many", "hello", 0},
+	}
+	for _, test := range tests {
+		if edits := maxEdits(test.fuzziness, test.term); edits != test.edits {
+			t.Errorf("maxEdits(%q, %q) = %d, expected %d", test.fuzziness, test.term, edits, test.edits)
+		}
+	}
+}
+
+func TestBM25Score(t *testing.T) {
+	// The example of the BM25 formula: a term found once in a document of
+	// average length, in 1 out of 2 documents
+	if score := bm25Score(1, 1, 2, 3, 3); math.Abs(score-math.Log(1+1.5/1.5)/2.2) > 1e-9 {
+		t.Fatalf("unexpected score %f", score)
+	}
+	tests := []struct {
+		name          string
+		higher, lower float64
+	}{
+		{"more occurrences", bm25Score(3, 1, 10, 5, 5), bm25Score(1, 1, 10, 5, 5)},
+		{"rarer term", bm25Score(1, 1, 10, 5, 5), bm25Score(1, 5, 10, 5, 5)},
+		{"shorter document", bm25Score(1, 1, 10, 2, 5), bm25Score(1, 1, 10, 10, 5)},
+	}
+	for _, test := range tests {
+		if test.higher <= test.lower {
+			t.Errorf("%s: expected %f to score higher than %f", test.name, test.higher, test.lower)
+		}
+	}
+}
+
+// indexMessages indexes bodies as messages 1, 2... of chat 1 of application 1.
+func indexMessages(t *testing.T, s *MemorySearcher, bodies ...string) {
+	t.Helper()
+	for i, body := range bodies {
+		doc := MessageDocument{ApplicationId: 1, ChatId: 1, ChatNumber: 1, MessageId: int64(i + 1), Number: int64(i + 1), Body: body}
+		if err := s.IndexMessage(context.Background(), doc); err != nil {
+			t.Fatalf("indexing %q: %v", body, err)
+		}
+	}
+}
+
+// searchNumbers returns the numbers of the messages of chat 1 matching query,
+// best first.
+func searchNumbers(t *testing.T, s *MemorySearcher, query Query) []int64 {
+	t.Helper()
+	query.ChatId = 1
+	result, err := s.SearchMessages(context.Background(), query)
+	if err != nil {
+		t.Fatalf("searching %+v: %v", query, err)
+	}
+	numbers := []int64{}
+	for _, hit := range result.Hits {
+		numbers = append(numbers, hit.Number)
+	}
+	return numbers
+}
+
+func TestSearchMessages(t *testing.T) {
+	s := NewMemorySearcher()
+	indexMessages(t, s,
+		"the quick brown fox",
+		"quick quick quick",
+		"a brown dog and a quick fox",
+		"Crème brûlée for dessert",
+		"the fox is quick and brown",
+	)
+
+	tests := []struct {
+		name    string
+		query   Query
+		numbers []int64
+	}{
+		{"more occurrences rank first", Query{Text: "quick"}, []int64{2, 1, 5, 3}},
+		{"any word matches, shorter first", Query{Text: "dog dessert"}, []int64{4, 3}},
+		{"accents are folded", Query{Text: "CREME"}, []int64{4}},
+		{"phrase keeps word order", Query{Text: "quick fox", Mode: ModePhrase}, []int64{3}},
+		{"phrase across three words", Query{Text: "the quick brown", Mode: ModePhrase}, []int64{1}},
+		{"phrase of unknown word", Query{Text: "quick cat", Mode: ModePhrase}, []int64{}},
+		{"prefix of last word", Query{Text: "dess", Mode: ModePrefix}, []int64{4}},
+		{"typo without fuzziness", Query{Text: "quikc"}, []int64{}},
+		{"swapped letters with fuzziness", Query{Text: "quikc", Fuzziness: "1"}, []int64{2, 1, 5, 3}},
+		{"newest first", Query{Text: "fox", Sort: SortNewest}, []int64{5, 3, 1}},
+		{"paged", Query{Text: "quick", From: 1, Size: 2}, []int64{1, 5}},
+	}
+	for _, test := range tests {
+		if numbers := searchNumbers(t, s, test.query); !slices.Equal(numbers, test.numbers) {
+			t.Errorf("%s: expected %v, got %v", test.name, test.numbers, numbers)
+		}
+	}
+}
+
+func TestFollowedBy(t *testing.T) {
+	s := NewMemorySearcher()
+	indexMessages(t, s, "one two three two three")
+	key := documentKey{chatId: 1, messageId: 1}
+
+	tests := []struct {
+		position int
+		terms    []string
+		followed bool
+	}{
+		{0, []string{"two", "three"}, true},
+		{1, []string{"three", "two"}, true},
+		{2, []string{"two", "three"}, true},
+		{0, []string{"three"}, false},
+		{3, []string{"three", "two"}, false},
+		{0, []string{}, true},
+	}
+	for _, test := range tests {
+		if followed := s.followedBy(key, test.position, test.terms); followed != test.followed {
+			t.Errorf("followedBy(%d, %q) = %t, expected %t", test.position, test.terms, followed, test.followed)
+		}
+	}
+}
+
+func TestHighlight(t *testing.T) {
+	tests := []struct {
+		body        string
+		positions   []int
+		highlighted string
+	}{
+		{"the quick fox", []int{1}, "the <em>quick</em> fox"},
+		{"the quick fox", []int{0, 2}, "<em>the</em> quick <em>fox</em>"},
+		{"Déjà vu, déjà!", []int{0, 2}, "<em>Déjà</em> vu, <em>déjà</em>!"},
+		{"nothing matched", nil, "nothing matched"},
+	}
+	for _, test := range tests {
+		positions := map[int]bool{}
+		for _, position := range test.positions {
+			positions[position] = true
+		}
+		if highlighted := highlight(test.body, positions, "<em>", "</em>"); highlighted != test.highlighted {
+			t.Errorf("highlight(%q, %v) = %q, expected %q", test.body, test.positions, highlighted, test.highlighted)
+		}
+	}
+}
+
+func TestVersionsRefuseStaleWrites(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemorySearcher()
+	doc := MessageDocument{ApplicationId: 1, ChatId: 1, MessageId: 1, Number: 1, Body: "new body", Version: 2}
+	s.IndexMessage(ctx, doc)
+
+	s.IndexMessage(ctx, MessageDocument{ApplicationId: 1, ChatId: 1, MessageId: 1, Number: 1, Body: "old body", Version: 1})
+	if numbers := searchNumbers(t, s, Query{Text: "old"}); len(numbers) != 0 {
+		t.Fatalf("expected the older body to be refused, got %v", numbers)
+	}
+
+	s.DeleteMessage(ctx, MessageDocument{ChatId: 1, MessageId: 1, Version: 3})
+	s.IndexMessage(ctx, doc)
+	if numbers := searchNumbers(t, s, Query{Text: "body"}); len(numbers) != 0 {
+		t.Fatalf("expected the deleted message to stay deleted, got %v", numbers)
+	}
+
+	// Purging the chat keeps the versions of its messages
+	s.IndexMessage(ctx, MessageDocument{ApplicationId: 1, ChatId: 1, MessageId: 1, Number: 1, Body: "restored body", Version: 4})
+	s.DeleteChats(ctx, []int64{1})
+	s.IndexMessage(ctx, MessageDocument{ApplicationId: 1, ChatId: 1, MessageId: 1, Number: 1, Body: "restored body", Version: 3})
+	if numbers := searchNumbers(t, s, Query{Text: "body"}); len(numbers) != 0 {
+		t.Fatalf("expected a stale write not to bring back a purged message, got %v", numbers)
+	}
+}
+
+// chatSubjects returns the subjects of the chats of application 1 matching
+// text.
+func chatSubjects(t *testing.T, s *MemorySearcher, text string) []string {
+	t.Helper()
+	result, err := s.SearchChats(context.Background(), ChatQuery{ApplicationId: 1, Text: text})
+	if err != nil {
+		t.Fatalf("searching chats for %q: %v", text, err)
+	}
+	subjects := []string{}
+	for _, hit := range result.Hits {
+		subjects = append(subjects, hit.Subject)
+	}
+	return subjects
+}
+
+func TestChatVersionsRefuseStaleWrites(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemorySearcher()
+	chat := func(subject string, version int64) ChatDocument {
+		return ChatDocument{ApplicationId: 1, ChatId: 1, Number: 1, Subject: subject, Version: version}
+	}
+	s.IndexChat(ctx, chat("new subject", 2))
+
+	s.WriteBatch(ctx, Batch{IndexChats: []ChatDocument{chat("old subject", 1)}})
+	if subjects := chatSubjects(t, s, "subject"); !slices.Equal(subjects, []string{"new subject"}) {
+		t.Fatalf("expected the older subject to be refused, got %q", subjects)
+	}
+
+	s.DeleteChat(ctx, chat("", 3))
+	s.IndexChat(ctx, chat("new subject", 2))
+	if subjects := chatSubjects(t, s, "subject"); len(subjects) != 0 {
+		t.Fatalf("expected the deleted chat to stay deleted, got %q", subjects)
+	}
+
+	s.WriteBatch(ctx, Batch{IndexChats: []ChatDocument{chat("restored subject", 4)}})
+	if subjects := chatSubjects(t, s, "subject"); !slices.Equal(subjects, []string{"restored subject"}) {
+		t.Fatalf("expected the restored chat, got %q", subjects)
+	}
+}
+
+func TestRebuildKeepsNewerLiveWrites(t *testing.T) {
+	ctx := context.Background()
+	s := NewMemorySearcher()
+	message := func(id int64, body string, version int64) MessageDocument {
+		return MessageDocument{ApplicationId: 1, ChatId: 1, MessageId: id, Number: id, Body: body, Version: version}
+	}
+	s.WriteBatch(ctx, Batch{Index: []MessageDocument{message(1, "first", 1), message(2, "second", 1), message(3, "third", 1)}})
+
+	rebuild, err := s.BeginRebuild(ctx)
+	if err != nil {
+		t.Fatalf("beginning rebuild: %v", err)
+	}
+	chat := func(id int64, subject string, version int64) ChatDocument {
+		return ChatDocument{ApplicationId: 1, ChatId: id, Number: id, Subject: subject, Version: version}
+	}
+	s.WriteBatch(ctx, Batch{IndexChats: []ChatDocument{chat(1, "first chat", 1), chat(2, "second chat", 1)}})
+	rebuild.WriteBatch(ctx, Batch{
+		Index:      []MessageDocument{message(1, "first", 1), message(2, "second", 1), message(3, "third copied", 2)},
+		IndexChats: []ChatDocument{chat(1, "first chat", 1), chat(2, "second chat", 1)},
+	})
+
+	// Changes to the live index while the copy runs
+	s.DeleteMessage(ctx, message(1, "", 2))
+	s.IndexMessage(ctx, message(2, "second edited", 2))
+	s.IndexMessage(ctx, message(3, "third stale", 1))
+	s.IndexChat(ctx, chat(1, "first chat renamed", 2))
+	s.DeleteChat(ctx, chat(2, "", 2))
+
+	if err := rebuild.Commit(ctx); err != nil {
+		t.Fatalf("committing rebuild: %v", err)
+	}
+	if numbers := searchNumbers(t, s, Query{Text: "first"}); len(numbers) != 0 {
+		t.Fatalf("expected the delete made during the copy to stick, got %v", numbers)
+	}
+	if numbers := searchNumbers(t, s, Query{Text: "edited"}); !slices.Equal(numbers, []int64{2}) {
+		t.Fatalf("expected the edit made during the copy to stick, got %v", numbers)
+	}
+	if numbers := searchNumbers(t, s, Query{Text: "copied"}); !slices.Equal(numbers, []int64{3}) {
+		t.Fatalf("expected the newer copy to win, got %v", numbers)
+	}
+
+	if subjects := chatSubjects(t, s, "chat"); !slices.Equal(subjects, []string{"first chat renamed"}) {
+		t.Fatalf("expected the chat writes made during the copy to stick, got %q", subjects)
+	}
+
+	s.IndexMessage(ctx, message(1, "first", 1))
+	if numbers := searchNumbers(t, s, Query{Text: "first"}); len(numbers) != 0 {
+		t.Fatalf("expected a stale write not to bring back a deleted message, got %v", numbers)
+	}
+}
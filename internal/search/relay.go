@@ -0,0 +1,172 @@
+package search
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"sync"
+	"time"
+)
+
+const (
+	relayPollInterval = 500 * time.Millisecond
+	relayLease        = time.Minute
+)
+
+// OutboxGrace is how long a new outbox entry is left to the writer that
+// added it, which publishes it right after committing, before relays pick it
+// up.
+const OutboxGrace = time.Second
+
+// OutboxEntry asks for the search document of a message, or of a chat when
+// Chat is set, to be brought in line with the database. Document or Chat is
+// built from the message or chat as it is stored when the entry is claimed;
+// Deleted is set when it was deleted or purged since.
+type OutboxEntry struct {
+	Id       int64
+	Attempts int
+	Document MessageDocument
+	Chat     *ChatDocument
+	Deleted  bool
+}
+
+// Outbox holds the entries written in the same transaction as the messages
+// and chats they are about, until they are published to the index.
+type Outbox interface {
+	// ClaimOutboxEntries returns up to limit due entries, oldest first, and
+	// hides them from other claims for lease.
+	ClaimOutboxEntries(ctx context.Context, limit int, lease time.Duration) ([]OutboxEntry, error)
+	DeleteOutboxEntries(ctx context.Context, ids []int64) error
+	// RetryOutboxEntries makes entries due again after delay.
+	RetryOutboxEntries(ctx context.Context, ids []int64, delay time.Duration, lastError string) error
+}
+
+// Relay publishes outbox entries to a MessageSearcher in batches. Entries that
+// could not be published are retried after the delay backoff gives for their
+// attempt, without limit, so the index eventually converges with the
+// database. Relays of several instances can share an outbox.
+type Relay struct {
+	outbox    Outbox
+	searcher  MessageSearcher
+	batchSize int
+	backoff   func(attempt int) time.Duration
+
+	stop    chan struct{}
+	stopped sync.WaitGroup
+}
+
+func NewRelay(outbox Outbox, searcher MessageSearcher, batchSize int, backoff func(attempt int) time.Duration) *Relay {
+	if batchSize < 1 {
+		batchSize = 1
+	}
+	return &Relay{
+		outbox:    outbox,
+		searcher:  searcher,
+		batchSize: batchSize,
+		backoff:   backoff,
+		stop:      make(chan struct{}),
+	}
+}
+
+func (r *Relay) Start() {
+	r.stopped.Add(1)
+	go r.run()
+	log.Println("started search outbox relay")
+}
+
+// Stop waits for the batch being published. Entries left in the outbox are
+// published by the next start.
+func (r *Relay) Stop(ctx context.Context) error {
+	close(r.stop)
+
+	done := make(chan struct{})
+	go func() {
+		r.stopped.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		log.Println("search outbox relay stopped")
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (r *Relay) run() {
+	defer r.stopped.Done()
+	ctx := context.Background()
+	for {
+		select {
+		case <-r.stop:
+			return
+		default:
+		}
+
+		published, err := r.publishBatch(ctx)
+		if err != nil {
+			log.Printf("error publishing search outbox: %v", err)
+		}
+		// A full batch suggests more entries are due
+		if err != nil || published < r.batchSize {
+			select {
+			case <-r.stop:
+				return
+			case <-time.After(relayPollInterval):
+			}
+		}
+	}
+}
+
+// publishBatch writes the next due entries to the index and returns how many
+// were published.
+func (r *Relay) publishBatch(ctx context.Context) (int, error) {
+	entries, err := r.outbox.ClaimOutboxEntries(ctx, r.batchSize, relayLease)
+	if err != nil {
+		return 0, err
+	}
+	if len(entries) == 0 {
+		return 0, nil
+	}
+
+	batch := Batch{}
+	ids := make([]int64, 0, len(entries))
+	for _, entry := range entries {
+		switch {
+		case entry.Chat != nil && entry.Deleted:
+			batch.DeleteChats = append(batch.DeleteChats, *entry.Chat)
+		case entry.Chat != nil:
+			batch.IndexChats = append(batch.IndexChats, *entry.Chat)
+		case entry.Deleted:
+			batch.Delete = append(batch.Delete, entry.Document)
+		default:
+			batch.Index = append(batch.Index, entry.Document)
+		}
+		ids = append(ids, entry.Id)
+	}
+
+	if err := r.searcher.WriteBatch(ctx, batch); err != nil {
+		r.retry(ctx, entries, err)
+		return 0, fmt.Errorf("failed to publish %d entries: %w", len(entries), err)
+	}
+	if err := r.outbox.DeleteOutboxEntries(ctx, ids); err != nil {
+		// They are published again once their lease expires
+		return len(entries), err
+	}
+	return len(entries), nil
+}
+
+// retry reschedules entries after the backoff of their attempt.
+func (r *Relay) retry(ctx context.Context, entries []OutboxEntry, cause error) {
+	byDelay := map[time.Duration][]int64{}
+	for _, entry := range entries {
+		delay := r.backoff(entry.Attempts)
+		byDelay[delay] = append(byDelay[delay], entry.Id)
+	}
+	for delay, ids := range byDelay {
+		if err := r.outbox.RetryOutboxEntries(ctx, ids, delay, cause.Error()); err != nil {
+			log.Printf("error rescheduling search outbox entries: %v", err)
+		}
+	}
+}
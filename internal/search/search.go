@@ -0,0 +1,249 @@
+// Package search keeps messages searchable. MySQL stays the source of truth:
+// the database handlers push every stored, updated or deleted message to a
+// MessageSearcher, and search requests are answered from it.
+package search
+
+import (
+	"context"
+	"time"
+)
+
+// MessagesIndex is the name of the alias of the index holding message
+// documents.
+const MessagesIndex = "messages"
+
+// ChatsIndex is the name of the alias of the index holding chat documents.
+const ChatsIndex = "chats"
+
+// DocumentSchemaVersion is the version of the fields of MessageDocument as
+// stored in an index. Bump it whenever they change: indices created for an
+// older version are rebuilt at startup.
+const DocumentSchemaVersion = 2
+
+// defaultResultSize is the number of hits of a search that sets no size,
+// as in Elasticsearch.
+const defaultResultSize = 10
+
+// MaxChatCounts bounds Result.ChatCounts to the chats with the most hits.
+const MaxChatCounts = 100
+
+// MaxResultWindow bounds From+Size. Elasticsearch refuses to page deeper by
+// default.
+const MaxResultWindow = 10000
+
+// Highlight tags used when a query sets none
+const (
+	DefaultPreTag  = "<em>"
+	DefaultPostTag = "</em>"
+)
+
+// MessageDocument is the searchable copy of a message. Number is the number
+// of the message within its chat, which is ChatNumber within its application.
+//
+// Version orders the changes of a message. A document never replaces one with
+// a higher version, nor one deleted at a higher version, so writes arriving
+// out of order cannot bring back an older body. Version 0 is written
+// unconditionally.
+type MessageDocument struct {
+	ApplicationId int64
+	ChatId        int64
+	ChatNumber    int64
+	MessageId     int64
+	Number        int64
+	Body          string
+	CreatedAt     time.Time
+	UpdatedAt     time.Time
+	Version       int64
+}
+
+// Query modes. An empty mode is ModeMatch.
+const (
+	// ModeMatch finds messages containing any of the words of the query
+	ModeMatch = "match"
+	// ModePhrase finds messages containing the words next to each other,
+	// in the order of the query
+	ModePhrase = "phrase"
+	// ModePrefix is ModeMatch with the last word taken as a prefix, for
+	// search as you type
+	ModePrefix = "prefix"
+)
+
+// Sort orders. An empty sort is SortRelevance.
+const (
+	SortRelevance = "relevance"
+	// SortNewest and SortOldest order by creation of the messages
+	SortNewest = "newest"
+	SortOldest = "oldest"
+)
+
+// Query looks for Text in the messages of one chat, or of every chat of an
+// application when ApplicationId is set. Fuzziness lets words of the query
+// match words with that many typos: "1", "2", or "AUTO" to allow more for
+// longer words. It does not apply to ModePhrase, nor to the prefix of
+// ModePrefix.
+//
+// Matches can be narrowed to the chats numbered ChatNumbers, and to messages
+// created from CreatedFrom to CreatedTo, both included, when set.
+//
+// The hits returned skip the first From matches and stop after Size. The
+// words of a hit that matched are wrapped in PreTag and PostTag. CountChats
+// asks for Result.ChatCounts.
+type Query struct {
+	ChatId        int64
+	ApplicationId int64
+	ChatNumbers   []int64
+	CreatedFrom   time.Time
+	CreatedTo     time.Time
+	Text          string
+	Mode          string
+	Fuzziness     string
+	Sort          string
+	From          int
+	Size          int
+	PreTag        string
+	PostTag       string
+	CountChats    bool
+}
+
+// Result is a page of hits and the number of matches over all pages.
+// ChatCounts splits the matches by chat, most matches first, for up to
+// MaxChatCounts chats.
+type Result struct {
+	Total      int64
+	Hits       []Hit
+	ChatCounts []ChatCount
+}
+
+// Hit is a matching message. Highlight is its body with the matching words
+// tagged.
+type Hit struct {
+	ChatNumber int64
+	Number     int64
+	Body       string
+	CreatedAt  time.Time
+	Score      float64
+	Highlight  string
+}
+
+// ChatCount is the number of matches in a chat.
+type ChatCount struct {
+	ChatNumber int64
+	Count      int64
+}
+
+// ChatDocument is the searchable copy of the subject of a chat. Number is
+// the number of the chat within its application. Version orders the changes
+// of a chat like that of MessageDocument.
+type ChatDocument struct {
+	ApplicationId int64
+	ChatId        int64
+	Number        int64
+	Subject       string
+	Version       int64
+}
+
+// ChatQuery looks for Text in the subjects of the chats of an application,
+// as the user types it: every word must match, the last one as a prefix. It
+// pages and highlights hits like Query.
+type ChatQuery struct {
+	ApplicationId int64
+	Text          string
+	From          int
+	Size          int
+	PreTag        string
+	PostTag       string
+}
+
+// ChatResult is a page of chats matching a ChatQuery and the number of
+// matches over all pages.
+type ChatResult struct {
+	Total int64
+	Hits  []ChatHit
+}
+
+// ChatHit is a matching chat. Highlight is its subject with the matching
+// words tagged.
+type ChatHit struct {
+	Number    int64
+	Subject   string
+	Score     float64
+	Highlight string
+}
+
+// Batch is a set of writes sent to the index at once, to message documents
+// and to chat documents. Only the ids and the version of the documents to
+// delete are used.
+type Batch struct {
+	Index       []MessageDocument
+	Delete      []MessageDocument
+	IndexChats  []ChatDocument
+	DeleteChats []ChatDocument
+}
+
+// MessageSearcher is a full-text index of messages, and of the subjects of
+// their chats in an index of their own. Message documents are identified by
+// their chat and message ids, and chat documents by their chat id, so
+// indexing either again replaces it.
+type MessageSearcher interface {
+	IndexMessage(ctx context.Context, doc MessageDocument) error
+	// DeleteMessage removes the document with the ids of doc, unless it has
+	// a higher version. It succeeds when the message was never indexed.
+	DeleteMessage(ctx context.Context, doc MessageDocument) error
+	// DeleteChats removes the given chats and every message of them.
+	DeleteChats(ctx context.Context, chatIds []int64) error
+	SearchMessages(ctx context.Context, query Query) (Result, error)
+	IndexChat(ctx context.Context, doc ChatDocument) error
+	// DeleteChat removes the document with the chat id of doc, leaving its
+	// messages, unless it has a higher version. It succeeds when the chat
+	// was never indexed.
+	DeleteChat(ctx context.Context, doc ChatDocument) error
+	SearchChats(ctx context.Context, query ChatQuery) (ChatResult, error)
+	WriteBatch(ctx context.Context, batch Batch) error
+	// BeginRebuild starts filling an empty index next to the live one.
+	BeginRebuild(ctx context.Context) (Rebuild, error)
+}
+
+// Rebuild is an index of messages and chats being filled from scratch.
+// Searches keep being answered by the live index until Commit swaps the two. Writes made to the live index
+// meanwhile may not be carried over, so whatever changed during the copy has
+// to be written again after Commit.
+type Rebuild interface {
+	WriteBatch(ctx context.Context, batch Batch) error
+	// Commit makes the rebuilt index the live one and drops the old one.
+	Commit(ctx context.Context) error
+	// Abort drops the rebuilt index.
+	Abort(ctx context.Context) error
+}
+
+func (q Query) size() int {
+	return resultSize(q.Size)
+}
+
+func (q Query) tags() (string, string) {
+	return highlightTags(q.PreTag, q.PostTag)
+}
+
+func (q ChatQuery) size() int {
+	return resultSize(q.Size)
+}
+
+func (q ChatQuery) tags() (string, string) {
+	return highlightTags(q.PreTag, q.PostTag)
+}
+
+func resultSize(size int) int {
+	if size <= 0 {
+		return defaultResultSize
+	}
+	return size
+}
+
+func highlightTags(preTag string, postTag string) (string, string) {
+	if preTag == "" {
+		preTag = DefaultPreTag
+	}
+	if postTag == "" {
+		postTag = DefaultPostTag
+	}
+	return preTag, postTag
+}
@@ -0,0 +1,14 @@
+-- The number the next chat of an application, and the next message of a chat, get.
+-- Inserts take their number by bumping these in their transaction, so numbers are
+-- never reused, even once the rows holding the highest ones are purged.
+ALTER TABLE Applications
+    ADD COLUMN next_chat_number BIGINT NOT NULL DEFAULT 1;
+
+ALTER TABLE Chats
+    ADD COLUMN next_message_number BIGINT NOT NULL DEFAULT 1;
+
+UPDATE Applications a
+SET next_chat_number = (SELECT COALESCE(MAX(c.number), 0) + 1 FROM Chats c WHERE c.application_id = a.id);
+
+UPDATE Chats c
+SET next_message_number = (SELECT COALESCE(MAX(m.number), 0) + 1 FROM Messages m WHERE m.chat_id = c.id);
@@ -0,0 +1,9 @@
+-- Count reconciliation only recounts the rows changed since its last run
+ALTER TABLE Applications
+    ADD INDEX idx_applications_updated_at (updated_at);
+
+ALTER TABLE Chats
+    ADD INDEX idx_chats_updated_at (updated_at);
+
+ALTER TABLE Messages
+    ADD INDEX idx_messages_updated_at (updated_at);
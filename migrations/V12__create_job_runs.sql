@@ -0,0 +1,8 @@
+-- The last run of each background job, whichever instance ran it
+CREATE TABLE JobRuns (
+    job VARCHAR(64) PRIMARY KEY,
+    started_at TIMESTAMP(6) NOT NULL,
+    duration_ms BIGINT NOT NULL,
+    -- empty when the run succeeded
+    error TEXT NOT NULL
+);
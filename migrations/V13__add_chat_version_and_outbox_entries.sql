@@ -0,0 +1,7 @@
+-- Bumped on every change of a chat, and used as the external version of its search document
+ALTER TABLE Chats
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 1;
+
+-- Entries without a message are about the search document of the chat itself
+ALTER TABLE SearchOutbox
+    MODIFY message_id BIGINT NULL;
@@ -0,0 +1,4 @@
+-- The kind of the domain error a failed task failed with (not_found, conflict or validation),
+-- so that a create waiting for its task answers like the request would have. Empty otherwise.
+ALTER TABLE TaskStatuses
+    ADD COLUMN error_kind VARCHAR(16) NOT NULL DEFAULT '';
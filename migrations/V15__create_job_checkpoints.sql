@@ -0,0 +1,6 @@
+-- Where a background job left off, such as the database clock of the last count reconciliation,
+-- so that its next run resumes from there on any instance, even after a restart
+CREATE TABLE JobCheckpoints (
+    job VARCHAR(64) PRIMARY KEY,
+    checkpoint TIMESTAMP(6) NOT NULL
+);
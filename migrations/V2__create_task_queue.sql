@@ -0,0 +1,15 @@
+-- Durable queue backing the asynchronous chat and message writes
+CREATE TABLE QueuedTasks (
+    -- insertion order, used to claim tasks first-in first-out
+    seq BIGINT AUTO_INCREMENT PRIMARY KEY,
+    task_id VARCHAR(36) NOT NULL UNIQUE,
+    queue VARCHAR(64) NOT NULL,
+    kind VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    -- "ready" until a worker claims it, then "claimed" until acknowledged
+    state VARCHAR(16) NOT NULL DEFAULT 'ready',
+    attempts INT NOT NULL DEFAULT 0,
+    lease_expires_at TIMESTAMP(6) NULL,
+    created_at TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_queued_tasks_claim (queue, state, seq)
+);
@@ -0,0 +1,12 @@
+-- Create the TaskStatuses table
+CREATE TABLE TaskStatuses (
+    task_id VARCHAR(36) PRIMARY KEY,
+    -- one of pending, running, completed, failed
+    status VARCHAR(16) NOT NULL,
+    result JSON NULL,
+    error TEXT NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    updated_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP ON UPDATE CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    INDEX idx_task_statuses_expires_at (expires_at)
+);
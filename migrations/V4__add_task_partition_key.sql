@@ -0,0 +1,4 @@
+-- Tasks sharing a partition key (e.g. the same chat) are processed one at a time, in order
+ALTER TABLE QueuedTasks
+    ADD COLUMN partition_key VARCHAR(64) NOT NULL DEFAULT '' AFTER kind,
+    ADD INDEX idx_queued_tasks_partition (queue, partition_key, seq);
@@ -0,0 +1,18 @@
+-- Failed tasks are retried with a delay and moved to DeadLetterTasks once their attempts are exhausted
+ALTER TABLE QueuedTasks
+    ADD COLUMN available_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6) AFTER lease_expires_at,
+    ADD COLUMN last_error TEXT NULL AFTER attempts;
+
+CREATE TABLE DeadLetterTasks (
+    task_id VARCHAR(36) PRIMARY KEY,
+    queue VARCHAR(64) NOT NULL,
+    kind VARCHAR(64) NOT NULL,
+    partition_key VARCHAR(64) NOT NULL,
+    payload JSON NOT NULL,
+    attempts INT NOT NULL,
+    error TEXT NOT NULL,
+    -- when the task was originally enqueued
+    created_at TIMESTAMP(6) NOT NULL,
+    failed_at TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_dead_letter_tasks_queue (queue, failed_at)
+);
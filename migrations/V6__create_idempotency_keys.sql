@@ -0,0 +1,13 @@
+-- Create the IdempotencyKeys table
+CREATE TABLE IdempotencyKeys (
+    -- request method and path the key was used on
+    scope VARCHAR(512) NOT NULL,
+    idempotency_key VARCHAR(255) NOT NULL,
+    -- SHA-256 of the request payload
+    request_hash CHAR(64) NOT NULL,
+    task_id VARCHAR(36) NOT NULL,
+    created_at TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    expires_at TIMESTAMP NOT NULL,
+    PRIMARY KEY (scope, idempotency_key),
+    INDEX idx_idempotency_keys_expires_at (expires_at)
+);
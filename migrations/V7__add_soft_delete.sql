@@ -0,0 +1,12 @@
+-- Deleted rows are kept with their deletion time until the retention window passes
+ALTER TABLE Applications
+    ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL,
+    ADD INDEX idx_applications_deleted_at (deleted_at);
+
+ALTER TABLE Chats
+    ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL,
+    ADD INDEX idx_chats_deleted_at (deleted_at);
+
+ALTER TABLE Messages
+    ADD COLUMN deleted_at TIMESTAMP NULL DEFAULT NULL,
+    ADD INDEX idx_messages_deleted_at (deleted_at);
@@ -0,0 +1,12 @@
+-- Messages whose search document is out of date, written in the same transaction as the change
+CREATE TABLE SearchOutbox (
+    id BIGINT AUTO_INCREMENT PRIMARY KEY,
+    -- no foreign keys: entries of purged messages remove them from the index
+    chat_id BIGINT NOT NULL,
+    message_id BIGINT NOT NULL,
+    attempts INT NOT NULL DEFAULT 0,
+    last_error TEXT NULL,
+    available_at TIMESTAMP(6) NOT NULL DEFAULT CURRENT_TIMESTAMP(6),
+    created_at TIMESTAMP(6) DEFAULT CURRENT_TIMESTAMP(6),
+    INDEX idx_search_outbox_available_at (available_at, id)
+);
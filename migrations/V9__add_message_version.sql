@@ -0,0 +1,3 @@
+-- Bumped on every change of a message, and used as the external version of its search document
+ALTER TABLE Messages
+    ADD COLUMN version BIGINT NOT NULL DEFAULT 1;